@@ -0,0 +1,99 @@
+// Package agent implements a credential agent, which holds credentials in
+// memory for the duration of a session, similar to what ssh-agent does for
+// SSH keys. Helpers that need expensive operations to retrieve a secret,
+// such as decrypting it with gpg, consult the agent before doing so, and
+// populate it afterwards. The agent also remembers the approvals the user
+// gives through pinentry, which helpers cannot keep anywhere their callers
+// could forge them.
+//
+// The agent listens on a per-user unix socket, which is advertised to
+// helpers through the DOCKER_CREDENTIAL_AGENT_SOCK environment variable.
+// Each connection carries a single JSON-encoded request and response.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// EnvSocket is the environment variable holding the path of the agent's
+// socket.
+const EnvSocket = "DOCKER_CREDENTIAL_AGENT_SOCK"
+
+// EnvTTL is the environment variable holding the time helpers ask the agent
+// to keep credentials for, as a duration such as "15m". If it's not set,
+// the default TTL of the agent is used.
+const EnvTTL = "DOCKER_CREDENTIAL_AGENT_TTL"
+
+// List of operations supported by the agent.
+const (
+	opGet    = "get"
+	opAdd    = "add"
+	opDelete = "delete"
+	opLock   = "lock"
+	opUnlock = "unlock"
+	opFlush  = "flush"
+
+	opApprove  = "approve"
+	opApproved = "approved"
+)
+
+// request is sent by a Client to the agent.
+type request struct {
+	Op         string        `json:"op"`
+	ServerURL  string        `json:"serverURL,omitempty"`
+	Username   string        `json:"username,omitempty"`
+	Secret     string        `json:"secret,omitempty"`
+	TTL        time.Duration `json:"ttl,omitempty"`
+	Passphrase string        `json:"passphrase,omitempty"`
+	Executable string        `json:"executable,omitempty"`
+}
+
+// response is sent by the agent in reply to a request.
+type response struct {
+	Username string `json:"username,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	NotFound bool   `json:"notFound,omitempty"`
+	Locked   bool   `json:"locked,omitempty"`
+	Approved bool   `json:"approved,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ErrLocked is returned by the agent while it is locked.
+var ErrLocked = errors.New("credential agent is locked")
+
+// DefaultSocket returns the default path of the agent's socket. It is
+// located in $XDG_RUNTIME_DIR if set, or in a per-user directory in the
+// temporary directory otherwise. As another user could create that
+// directory first, the agent and its clients refuse to use a socket
+// directory that is not owned by, and private to, the current user.
+func DefaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "docker-credential-agent.sock")
+	}
+	return filepath.Join(os.TempDir(), "docker-credential-agent-"+strconv.Itoa(os.Getuid()), "agent.sock")
+}
+
+// checkSocketDir returns an error unless dir, the directory of the agent's
+// socket, is a directory owned by the current user and only accessible by
+// them, and not a symbolic link, so that no other user can replace the
+// socket with their own.
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !info.IsDir():
+		return fmt.Errorf("agent socket directory %s is not a directory", dir)
+	case !ownedByCurrentUser(info):
+		return fmt.Errorf("agent socket directory %s is not owned by the current user", dir)
+	case info.Mode().Perm()&0o077 != 0:
+		return fmt.Errorf("agent socket directory %s is accessible by other users", dir)
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+func startAgent(t *testing.T, s *Server) *Client {
+	t.Helper()
+	// Listen creates the socket directory private to the current user.
+	socket := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	l, err := Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() { _ = s.Serve(l) }()
+	return NewClient(socket)
+}
+
+func TestAgent(t *testing.T) {
+	now := time.Now()
+	s := NewServer(time.Minute)
+	s.now = func() time.Time { return now }
+	c := startAgent(t, s)
+
+	creds := &credentials.Credentials{
+		ServerURL: "https://registry.example.com",
+		Username:  "foo",
+		Secret:    "bar",
+	}
+
+	if _, _, err := c.Get(creds.ServerURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Fatalf("expected credentials not found, got %v", err)
+	}
+
+	if err := c.Add(creds, 0); err != nil {
+		t.Fatal(err)
+	}
+	username, secret, err := c.Get(creds.ServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != creds.Username || secret != creds.Secret {
+		t.Errorf("expected %s:%s, got %s:%s", creds.Username, creds.Secret, username, secret)
+	}
+
+	if err := c.Delete(creds.ServerURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(creds.ServerURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found after delete, got %v", err)
+	}
+
+	if err := c.Add(creds, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(creds.ServerURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found after flush, got %v", err)
+	}
+}
+
+func TestAgentDeleteAccounts(t *testing.T) {
+	c := startAgent(t, NewServer(time.Minute))
+	for _, serverURL := range []string{"https://ghcr.io", "https://robot@ghcr.io", "https://jane@ghcr.io", "https://ghcr.io/org", "quay.io"} {
+		if err := c.Add(&credentials.Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting an account leaves the other accounts.
+	if err := c.Delete("https://jane@ghcr.io"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get("https://robot@ghcr.io"); err != nil {
+		t.Errorf("expected robot account to be left, got %v", err)
+	}
+
+	// Deleting a server URL evicts all its accounts.
+	if err := c.Delete("https://ghcr.io"); err != nil {
+		t.Fatal(err)
+	}
+	for _, serverURL := range []string{"https://ghcr.io", "https://robot@ghcr.io"} {
+		if _, _, err := c.Get(serverURL); !credentials.IsErrCredentialsNotFound(err) {
+			t.Errorf("%s: expected credentials not found after delete, got %v", serverURL, err)
+		}
+	}
+	for _, serverURL := range []string{"https://ghcr.io/org", "quay.io"} {
+		if _, _, err := c.Get(serverURL); err != nil {
+			t.Errorf("%s: expected credentials to be left, got %v", serverURL, err)
+		}
+	}
+}
+
+func TestListenInsecureDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on Windows")
+	}
+	dir := filepath.Join(t.TempDir(), "shared")
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Listen(filepath.Join(dir, "agent.sock")); err == nil {
+		_ = l.Close()
+		t.Error("expected error with a directory accessible by other users")
+	}
+	if _, _, err := NewClient(filepath.Join(dir, "agent.sock")).Get("registry.example.com"); err == nil || !strings.Contains(err.Error(), "accessible by other users") {
+		t.Errorf("expected client to refuse the directory, got %v", err)
+	}
+
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Listen(filepath.Join(link, "agent.sock")); err == nil {
+		_ = l.Close()
+		t.Error("expected error with a symbolic link")
+	}
+}
+
+func TestAgentTTL(t *testing.T) {
+	now := time.Now()
+	s := NewServer(time.Minute)
+	s.now = func() time.Time { return now }
+	c := startAgent(t, s)
+
+	short := &credentials.Credentials{ServerURL: "short.example.com", Username: "foo", Secret: "bar"}
+	long := &credentials.Credentials{ServerURL: "long.example.com", Username: "foo", Secret: "bar"}
+	if err := c.Add(short, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(long, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, _, err := c.Get(short.ServerURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected entry with short TTL to expire, got %v", err)
+	}
+	if _, _, err := c.Get(long.ServerURL); err != nil {
+		t.Errorf("expected entry with default TTL to be kept, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, err := c.Get(long.ServerURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected entry with default TTL to expire, got %v", err)
+	}
+}
+
+func TestAgentLock(t *testing.T) {
+	c := startAgent(t, NewServer(time.Minute))
+
+	creds := &credentials.Credentials{ServerURL: "registry.example.com", Username: "foo", Secret: "bar"}
+	if err := c.Add(creds, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Lock("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(creds.ServerURL); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected locked agent to refuse get, got %v", err)
+	}
+	if err := c.Add(creds, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected locked agent to refuse add, got %v", err)
+	}
+	if err := c.Lock("other"); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected locked agent to refuse lock, got %v", err)
+	}
+	if err := c.Unlock("wrong"); err == nil {
+		t.Error("expected unlock with wrong passphrase to fail")
+	}
+
+	if err := c.Unlock("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, secret, err := c.Get(creds.ServerURL); err != nil || secret != creds.Secret {
+		t.Errorf("expected credentials to be kept while locked, got %q, %v", secret, err)
+	}
+}
+
+func TestClientNoAgent(t *testing.T) {
+	t.Setenv(EnvSocket, "")
+	if c := NewClientFromEnv(); c != nil {
+		t.Error("expected no client without agent socket")
+	}
+
+	c := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+	if _, _, err := c.Get("registry.example.com"); err == nil {
+		t.Error("expected error without agent")
+	}
+}
+
+func TestAgentPolicy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("callers are only identified on Linux")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := credentials.ParsePolicy(strings.NewReader(`{
+  "rules": [
+    {"actions": ["get"], "registries": ["allowed.example.com"], "callers": ["` + exe + `"]},
+    {"actions": ["get"], "registries": ["denied.example.com"], "callers": ["/usr/bin/docker"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+
+	s := NewServer(time.Minute)
+	s.SetPolicy(p)
+	s.SetAuditLog(credentials.NewAuditLog(auditPath))
+	c := startAgent(t, s)
+
+	for _, serverURL := range []string{"allowed.example.com", "denied.example.com"} {
+		if err := c.Add(&credentials.Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := c.Get("allowed.example.com"); err != nil {
+		t.Errorf("expected get to be allowed, got %v", err)
+	}
+	if _, _, err := c.Get("denied.example.com"); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("expected get to be denied, got %v", err)
+	}
+
+	if n, err := credentials.VerifyAuditLog(auditPath); err != nil || n != 1 {
+		t.Errorf("expected denial to be audited, got %d records, %v", n, err)
+	}
+}
+
+func TestAgentApprovals(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("agents and helpers are only identified on Linux")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	s := NewServer(time.Minute)
+	s.now = func() time.Time { return now }
+	c := startAgent(t, s)
+
+	// The test binary is neither a trusted helper nor a trusted agent.
+	if _, err := c.IsApproved("/usr/bin/docker", "registry.example.com"); err == nil {
+		t.Fatal("expected approvals to be refused to an untrusted agent")
+	}
+	c.agentExecutable = exe
+	if err := c.Approve("/usr/bin/docker", "registry.example.com", time.Minute); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("expected approvals to be refused from an untrusted helper, got %v", err)
+	}
+
+	s.SetTrustedHelpers([]string{exe})
+	if err := c.Approve("/usr/bin/docker", "registry.example.com", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		executable string
+		serverURL  string
+		approved   bool
+	}{
+		{executable: "/usr/bin/docker", serverURL: "registry.example.com", approved: true},
+		{executable: "/usr/bin/curl", serverURL: "registry.example.com", approved: false},
+		{executable: "/usr/bin/docker", serverURL: "other.example.com", approved: false},
+	} {
+		approved, err := c.IsApproved(tc.executable, tc.serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if approved != tc.approved {
+			t.Errorf("expected approval of %s for %s: %t, got %t", tc.serverURL, tc.executable, tc.approved, approved)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if approved, err := c.IsApproved("/usr/bin/docker", "registry.example.com"); err != nil || approved {
+		t.Errorf("expected expired approval, got %t, %v", approved, err)
+	}
+}
+
+func TestRequester(t *testing.T) {
+	dir := t.TempDir()
+	helper := filepath.Join(dir, "docker-credential-pass")
+	if err := os.WriteFile(helper, nil, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(time.Minute)
+	s.SetTrustedHelpers([]string{helper})
+	helper = resolvePath(helper)
+
+	relayed := &credentials.Caller{PID: 1234, PPID: os.Getpid(), Executable: helper}
+	if r := s.requester(relayed); r.PID != os.Getpid() {
+		t.Errorf("expected request of trusted helper to be attributed to its parent, got %+v", r)
+	}
+	for _, executable := range []string{filepath.Join(dir, "docker-credential-x"), "/tmp/docker-credential-pass", ""} {
+		caller := &credentials.Caller{PID: 1234, PPID: os.Getpid(), Executable: executable}
+		if r := s.requester(caller); r != caller {
+			t.Errorf("%s: expected request to be attributed to the caller, got %+v", executable, r)
+		}
+	}
+
+	// By default, only the helpers next to the agent are trusted.
+	s = NewServer(time.Minute)
+	if s.isTrustedHelper(helper) {
+		t.Errorf("expected %s not to be trusted", helper)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.isTrustedHelper(filepath.Join(filepath.Dir(resolvePath(self)), "docker-credential-pass")) {
+		t.Error("expected helper installed next to the agent to be trusted")
+	}
+}
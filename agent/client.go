@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// Client talks to a credential agent.
+type Client struct {
+	socket string
+	// agentExecutable is the resolved path of the agent's executable, or
+	// empty for the docker-credential-agent installed next to the current
+	// executable.
+	agentExecutable string
+}
+
+// NewClient creates a Client for the agent listening on socket.
+func NewClient(socket string) *Client {
+	return &Client{socket: socket}
+}
+
+// NewClientFromEnv creates a Client for the agent advertised in the
+// environment. It returns nil if no agent is advertised.
+func NewClientFromEnv() *Client {
+	socket := os.Getenv(EnvSocket)
+	if socket == "" {
+		return nil
+	}
+	return NewClient(socket)
+}
+
+// TTLFromEnv returns the TTL set in the environment for credentials handed
+// to the agent, or zero if it is not set or invalid.
+func TTLFromEnv() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv(EnvTTL))
+	if err != nil {
+		return 0
+	}
+	return ttl
+}
+
+func (c *Client) do(req request) (response, error) {
+	if err := checkSocketDir(filepath.Dir(c.socket)); err != nil {
+		return response{}, err
+	}
+	conn, err := net.DialTimeout("unix", c.socket, time.Second)
+	if err != nil {
+		return response{}, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Approvals must come from the agent itself, and not from another
+	// process listening on the socket in its place.
+	if req.Op == opApprove || req.Op == opApproved {
+		if err := c.checkAgent(conn); err != nil {
+			return response{}, err
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return response{}, err
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return response{}, err
+	}
+	switch {
+	case resp.Locked && req.Op != opUnlock:
+		return resp, ErrLocked
+	case resp.Error != "":
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// checkAgent returns an error unless the process at the other end of conn
+// is the agent, running as the current user. Agents can only be identified
+// on Linux.
+func (c *Client) checkAgent(conn net.Conn) error {
+	peer, err := peerCaller(conn)
+	if err != nil {
+		return err
+	}
+	if peer == nil {
+		return errors.New("cannot identify the credential agent")
+	}
+	expected := c.agentExecutable
+	if expected == "" {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		expected = resolvePath(filepath.Join(filepath.Dir(resolvePath(self)), "docker-credential-agent"))
+	}
+	if peer.UID != os.Getuid() || peer.Executable == "" || peer.Executable != expected {
+		return fmt.Errorf("process %d (%s) is not a trusted credential agent", peer.PID, peer.Executable)
+	}
+	return nil
+}
+
+// Get returns the username and secret held by the agent for serverURL. It
+// returns a "credentials not found" error if the agent has no credentials
+// for serverURL.
+func (c *Client) Get(serverURL string) (string, string, error) {
+	resp, err := c.do(request{Op: opGet, ServerURL: serverURL})
+	if err != nil {
+		return "", "", err
+	}
+	if resp.NotFound {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+	return resp.Username, resp.Secret, nil
+}
+
+// Add hands credentials to the agent, which holds them for ttl, or for its
+// default TTL if ttl is zero.
+func (c *Client) Add(creds *credentials.Credentials, ttl time.Duration) error {
+	if creds == nil {
+		return errors.New("missing credentials")
+	}
+	_, err := c.do(request{
+		Op:        opAdd,
+		ServerURL: creds.ServerURL,
+		Username:  creds.Username,
+		Secret:    creds.Secret,
+		TTL:       ttl,
+	})
+	return err
+}
+
+// Delete removes the credentials for serverURL from the agent. Unless
+// serverURL designates an account, as returned by
+// credentials.AccountServerURL, the credentials of all the accounts of
+// serverURL are removed as well.
+func (c *Client) Delete(serverURL string) error {
+	_, err := c.do(request{Op: opDelete, ServerURL: serverURL})
+	return err
+}
+
+// Lock locks the agent with passphrase. While locked, the agent refuses to
+// hand out or accept credentials.
+func (c *Client) Lock(passphrase string) error {
+	_, err := c.do(request{Op: opLock, Passphrase: passphrase})
+	return err
+}
+
+// Unlock unlocks the agent, using the passphrase it was locked with.
+func (c *Client) Unlock(passphrase string) error {
+	_, err := c.do(request{Op: opUnlock, Passphrase: passphrase})
+	return err
+}
+
+// Flush removes all credentials from the agent.
+func (c *Client) Flush() error {
+	_, err := c.do(request{Op: opFlush})
+	return err
+}
+
+// IsApproved reports whether the agent remembers that the user approved
+// releasing the credentials for serverURL to executable. With Approve, it
+// implements [credentials.ApprovalStore].
+func (c *Client) IsApproved(executable, serverURL string) (bool, error) {
+	resp, err := c.do(request{Op: opApproved, Executable: executable, ServerURL: serverURL})
+	if err != nil {
+		return false, err
+	}
+	return resp.Approved, nil
+}
+
+// Approve asks the agent to remember for d that the user approved releasing
+// the credentials for serverURL to executable.
+func (c *Client) Approve(executable, serverURL string, d time.Duration) error {
+	_, err := c.do(request{Op: opApprove, Executable: executable, ServerURL: serverURL, TTL: d})
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/agent"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"golang.org/x/term"
+)
+
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [--socket <path>] <serve|lock|unlock|flush>\n\n", credentials.Name)
+	flag.PrintDefaults()
+}
+
+func main() {
+	socket := flag.String("socket", "", "path of the agent's socket (default $"+agent.EnvSocket+" or "+agent.DefaultSocket()+")")
+	ttl := flag.Duration("ttl", 15*time.Minute, "time to hold credentials for, unless a helper asks otherwise (serve only)")
+	trustedHelpers := flag.String("trusted-helpers", "", "comma-separated paths of the credential helpers whose requests are attributed to the process executing them (serve only, default the docker-credential-* executables next to the agent)")
+	version := flag.Bool("version", false, "print version and exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		_ = credentials.PrintVersion(os.Stdout)
+		return
+	}
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if *socket == "" {
+		*socket = os.Getenv(agent.EnvSocket)
+	}
+	if *socket == "" {
+		*socket = agent.DefaultSocket()
+	}
+
+	var err error
+	switch cmd := flag.Arg(0); cmd {
+	case "serve":
+		err = serve(*socket, *ttl, *trustedHelpers)
+	case "lock":
+		var passphrase string
+		if passphrase, err = readPassphrase("Enter lock passphrase: "); err == nil {
+			err = agent.NewClient(*socket).Lock(passphrase)
+		}
+	case "unlock":
+		var passphrase string
+		if passphrase, err = readPassphrase("Enter lock passphrase: "); err == nil {
+			err = agent.NewClient(*socket).Unlock(passphrase)
+		}
+	case "flush":
+		err = agent.NewClient(*socket).Flush()
+	default:
+		err = fmt.Errorf("unknown command: %s", cmd)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the agent in the foreground, until it receives SIGINT or
+// SIGTERM. The shell commands to advertise the agent are printed to
+// stdout, in the same way as ssh-agent.
+func serve(socket string, ttl time.Duration, trustedHelpers string) error {
+	p, err := credentials.LoadDefaultPolicy()
+	if err != nil {
+		return err
+	}
+
+	l, err := agent.Listen(socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	_, _ = fmt.Fprintf(os.Stdout, "%s=%s; export %s;\n", agent.EnvSocket, socket, agent.EnvSocket)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		_ = l.Close()
+	}()
+
+	s := agent.NewServer(ttl)
+	s.SetPolicy(p)
+	s.SetAuditLog(credentials.DefaultAuditLog())
+	if trustedHelpers != "" {
+		var paths []string
+		for _, path := range strings.Split(trustedHelpers, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+		s.SetTrustedHelpers(paths)
+	}
+	return s.Serve(l)
+}
+
+// readPassphrase reads a passphrase from the terminal with echo disabled,
+// or from the first line of stdin if it is not a terminal.
+func readPassphrase(prompt string) (string, error) {
+	_, _ = fmt.Fprint(os.Stderr, prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		_, _ = fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// peerCaller identifies the process at the other end of conn, using
+// SO_PEERCRED.
+func peerCaller(conn net.Conn) (*credentials.Caller, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	c := credentials.CallerFromPID(int(cred.Pid))
+	c.UID = int(cred.Uid)
+	return &c, nil
+}
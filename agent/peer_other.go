@@ -0,0 +1,15 @@
+//go:build !linux
+
+package agent
+
+import (
+	"net"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// peerCaller identifies the process at the other end of conn. It is only
+// implemented on Linux, and returns nil on other platforms.
+func peerCaller(net.Conn) (*credentials.Caller, error) {
+	return nil, nil
+}
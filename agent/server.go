@@ -0,0 +1,364 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// Server holds credentials in memory and answers requests from clients.
+//
+// On Linux, the server identifies the process connecting to it. Processes
+// running as another user are refused. If a policy is set, get, add and
+// delete requests are checked against it; requests relayed by a trusted
+// credential helper, see SetTrustedHelpers, are attributed to the process
+// that executed the helper.
+//
+// The server also remembers the approvals given by the user through
+// pinentry, see [credentials.SetApprovalStore]. They are only accepted from,
+// and disclosed to, trusted credential helpers.
+type Server struct {
+	defaultTTL time.Duration
+	now        func() time.Time
+	policy     *credentials.Policy
+	auditLog   *credentials.AuditLog
+	// trustedHelpers are the resolved paths of the trusted helpers, or
+	// nil to trust the helpers installed next to the agent.
+	trustedHelpers []string
+
+	mu        sync.Mutex
+	entries   map[string]entry
+	approvals map[approval]time.Time
+	locked    bool
+	lockHash  [sha256.Size]byte
+}
+
+type entry struct {
+	username string
+	secret   string
+	expires  time.Time
+}
+
+// approval identifies an approval remembered by the server.
+type approval struct {
+	executable string
+	serverURL  string
+}
+
+// NewServer creates a Server that keeps credentials for defaultTTL unless
+// the client asks for a different TTL.
+func NewServer(defaultTTL time.Duration) *Server {
+	return &Server{
+		defaultTTL: defaultTTL,
+		now:        time.Now,
+		entries:    make(map[string]entry),
+		approvals:  make(map[approval]time.Time),
+	}
+}
+
+// SetPolicy sets the policy requests are checked against.
+func (s *Server) SetPolicy(p *credentials.Policy) {
+	s.policy = p
+}
+
+// SetAuditLog sets the audit log requests refused by the policy are
+// recorded in.
+func (s *Server) SetAuditLog(l *credentials.AuditLog) {
+	s.auditLog = l
+}
+
+// SetTrustedHelpers sets the executables of the credential helpers whose
+// requests are attributed to the process that executed them. Requests of
+// other processes are attributed to the process itself. By default, the
+// executables named docker-credential-* in the directory of the agent's
+// own executable are trusted.
+func (s *Server) SetTrustedHelpers(paths []string) {
+	s.trustedHelpers = make([]string, 0, len(paths))
+	for _, path := range paths {
+		s.trustedHelpers = append(s.trustedHelpers, resolvePath(path))
+	}
+}
+
+// isTrustedHelper reports whether executable, as resolved by the kernel, is
+// a trusted credential helper.
+func (s *Server) isTrustedHelper(executable string) bool {
+	if executable == "" {
+		return false
+	}
+	if s.trustedHelpers != nil {
+		for _, path := range s.trustedHelpers {
+			if executable == path {
+				return true
+			}
+		}
+		return false
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	return filepath.Dir(executable) == filepath.Dir(resolvePath(self)) &&
+		strings.HasPrefix(filepath.Base(executable), "docker-credential-")
+}
+
+// resolvePath returns the absolute path of path with symbolic links
+// resolved, or the cleaned path if it cannot be resolved.
+func resolvePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// Listen creates a unix socket at path that is only accessible by the
+// current user. A stale socket at path is removed. The directory of the
+// socket is created if needed, and must only be accessible by the current
+// user.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := checkSocketDir(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve accepts connections on l until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.mu.Lock()
+				s.expire()
+				s.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		_ = json.NewEncoder(conn).Encode(response{Error: err.Error()})
+		return
+	}
+
+	caller, err := peerCaller(conn)
+	if err != nil {
+		_ = json.NewEncoder(conn).Encode(response{Error: err.Error()})
+		return
+	}
+	if caller != nil && caller.UID != os.Getuid() {
+		_ = json.NewEncoder(conn).Encode(response{Error: "access denied: agent belongs to another user"})
+		return
+	}
+	if (req.Op == opApprove || req.Op == opApproved) && (caller == nil || !s.isTrustedHelper(caller.Executable)) {
+		_ = json.NewEncoder(conn).Encode(response{Error: "access denied: approvals are only accepted from trusted helpers"})
+		return
+	}
+	_ = json.NewEncoder(conn).Encode(s.handle(req, s.requester(caller)))
+}
+
+// requester returns the process a request is made on behalf of. Requests
+// made by a trusted credential helper are made on behalf of the helper's
+// parent.
+func (s *Server) requester(caller *credentials.Caller) *credentials.Caller {
+	if caller == nil || !s.isTrustedHelper(caller.Executable) {
+		return caller
+	}
+	if caller.PPID > 0 {
+		parent := credentials.CallerFromPID(caller.PPID)
+		return &parent
+	}
+	return caller
+}
+
+// authorize checks a request made by caller against the policy, if any,
+// and records refused requests in the audit log.
+func (s *Server) authorize(req request, caller *credentials.Caller) error {
+	if s.policy == nil {
+		return nil
+	}
+
+	var action credentials.Action
+	switch req.Op {
+	case opGet:
+		action = credentials.ActionGet
+	case opAdd:
+		action = credentials.ActionStore
+	case opDelete:
+		action = credentials.ActionErase
+	default:
+		return nil
+	}
+
+	err := s.policy.CheckCaller(action, req.ServerURL, req.Username, caller)
+	if err != nil && s.auditLog != nil {
+		rec := credentials.AuditRecord{
+			Time:      time.Now().UTC(),
+			Helper:    credentials.Name,
+			Action:    action,
+			ServerURL: req.ServerURL,
+			Username:  req.Username,
+			Outcome:   credentials.AuditOutcomeDenied,
+			Error:     err.Error(),
+		}
+		rec.SetCaller(caller)
+		if auditErr := s.auditLog.Append(rec); auditErr != nil {
+			credentials.Logger().Debug("writing audit log failed", "error", auditErr)
+		}
+	}
+	return err
+}
+
+// handle executes a request made by caller.
+func (s *Server) handle(req request, caller *credentials.Caller) response {
+	if err := s.authorize(req, caller); err != nil {
+		return response{Error: err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	switch req.Op {
+	case opLock:
+		if s.locked {
+			return response{Locked: true, Error: ErrLocked.Error()}
+		}
+		s.locked = true
+		s.lockHash = sha256.Sum256([]byte(req.Passphrase))
+		return response{}
+	case opUnlock:
+		h := sha256.Sum256([]byte(req.Passphrase))
+		if s.locked && subtle.ConstantTimeCompare(h[:], s.lockHash[:]) != 1 {
+			return response{Locked: true, Error: "incorrect passphrase"}
+		}
+		s.locked = false
+		return response{}
+	case opFlush:
+		s.entries = make(map[string]entry)
+		s.approvals = make(map[approval]time.Time)
+		return response{}
+	case opDelete:
+		s.evict(req.ServerURL)
+		return response{}
+	}
+
+	if s.locked {
+		return response{Locked: true, Error: ErrLocked.Error()}
+	}
+
+	switch req.Op {
+	case opGet:
+		e, ok := s.entries[req.ServerURL]
+		if !ok {
+			return response{NotFound: true}
+		}
+		return response{Username: e.username, Secret: e.secret}
+	case opAdd:
+		if req.ServerURL == "" {
+			return response{Error: "missing server url"}
+		}
+		ttl := req.TTL
+		if ttl <= 0 {
+			ttl = s.defaultTTL
+		}
+		s.entries[req.ServerURL] = entry{
+			username: req.Username,
+			secret:   req.Secret,
+			expires:  s.now().Add(ttl),
+		}
+		return response{}
+	case opApprove:
+		if req.Executable == "" || req.ServerURL == "" || req.TTL <= 0 {
+			return response{Error: "missing executable, server url or ttl"}
+		}
+		s.approvals[approval{executable: req.Executable, serverURL: req.ServerURL}] = s.now().Add(req.TTL)
+		return response{}
+	case opApproved:
+		_, ok := s.approvals[approval{executable: req.Executable, serverURL: req.ServerURL}]
+		return response{Approved: ok}
+	default:
+		return response{Error: "unknown operation: " + req.Op}
+	}
+}
+
+// evict removes the entry for serverURL and, unless serverURL designates an
+// account, the entries of all the accounts of serverURL, as erasing or
+// storing credentials for a server URL may change any of them. It must be
+// called with s.mu held.
+func (s *Server) evict(serverURL string) {
+	delete(s.entries, serverURL)
+	if _, username := credentials.SplitAccountServerURL(serverURL); username != "" {
+		return
+	}
+	for key := range s.entries {
+		if server, _ := credentials.SplitAccountServerURL(key); server == serverURL {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// expire removes expired entries and approvals. It must be called with
+// s.mu held.
+func (s *Server) expire() {
+	now := s.now()
+	for serverURL, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, serverURL)
+		}
+	}
+	for a, expires := range s.approvals {
+		if !now.Before(expires) {
+			delete(s.approvals, a)
+		}
+	}
+}
@@ -45,8 +45,48 @@ func Store(program ProgramFunc, creds *credentials.Credentials) error {
 
 // Get executes an external program to get the credentials from a native store.
 func Get(program ProgramFunc, serverURL string) (*credentials.Credentials, error) {
+	return get(program, serverURL, serverURL)
+}
+
+// GetAccount executes an external program to get the credentials of the
+// account of username among the accounts kept for serverURL in a native
+// store.
+func GetAccount(program ProgramFunc, serverURL, username string) (*credentials.Credentials, error) {
+	input, err := requestInput(serverURL, username, "")
+	if err != nil {
+		return nil, err
+	}
+	return get(program, serverURL, input)
+}
+
+// GetForAccess executes an external program to get the least privileged
+// credentials for serverURL allowing access, credentials.AccessPull or
+// credentials.AccessPush, from a native store.
+func GetForAccess(program ProgramFunc, serverURL, access string) (*credentials.Credentials, error) {
+	input, err := requestInput(serverURL, "", access)
+	if err != nil {
+		return nil, err
+	}
+	return get(program, serverURL, input)
+}
+
+// requestInput returns the input of the get and erase actions selecting
+// the account of username for serverURL, or the credentials for access.
+func requestInput(serverURL, username, access string) (string, error) {
+	b, err := json.Marshal(struct {
+		ServerURL string
+		Username  string `json:",omitempty"`
+		Access    string `json:",omitempty"`
+	}{serverURL, username, access})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func get(program ProgramFunc, serverURL, input string) (*credentials.Credentials, error) {
 	cmd := program(credentials.ActionGet)
-	cmd.Input(strings.NewReader(serverURL))
+	cmd.Input(strings.NewReader(input))
 
 	out, err := cmd.Output()
 	if err != nil {
@@ -74,8 +114,23 @@ func Get(program ProgramFunc, serverURL string) (*credentials.Credentials, error
 
 // Erase executes a program to remove the server credentials from the native store.
 func Erase(program ProgramFunc, serverURL string) error {
+	return erase(program, serverURL)
+}
+
+// EraseAccount executes a program to remove the credentials of the account
+// of username for serverURL from the native store, leaving the other
+// accounts of serverURL.
+func EraseAccount(program ProgramFunc, serverURL, username string) error {
+	input, err := requestInput(serverURL, username, "")
+	if err != nil {
+		return err
+	}
+	return erase(program, input)
+}
+
+func erase(program ProgramFunc, input string) error {
 	cmd := program(credentials.ActionErase)
-	cmd.Input(strings.NewReader(serverURL))
+	cmd.Input(strings.NewReader(input))
 	out, err := cmd.Output()
 	if err != nil {
 		t := strings.TrimSpace(string(out))
@@ -92,7 +147,23 @@ func Erase(program ProgramFunc, serverURL string) error {
 
 // List executes a program to list server credentials in the native store.
 func List(program ProgramFunc) (map[string]string, error) {
-	cmd := program(credentials.ActionList)
+	return list(program, credentials.ActionList)
+}
+
+// ListAll executes a program to list all the accounts in the native store,
+// including the accounts other than the default account of each server
+// URL, see [credentials.MultiAccountHelper]. Programs that do not support
+// listing all the accounts list the default accounts.
+func ListAll(program ProgramFunc) (map[string]string, error) {
+	accts, err := list(program, credentials.ActionListAll)
+	if err != nil {
+		return List(program)
+	}
+	return accts, nil
+}
+
+func list(program ProgramFunc, action credentials.Action) (map[string]string, error) {
+	cmd := program(action)
 	cmd.Input(strings.NewReader("unused"))
 	out, err := cmd.Output()
 	if err != nil {
@@ -20,6 +20,14 @@ const (
 
 var errProgramExited = fmt.Errorf("exited 1")
 
+// validAccountInput is the input selecting the account of validUsername for
+// validServerAddress.
+var validAccountInput = fmt.Sprintf(`{"ServerURL":%q,"Username":%q}`, validServerAddress, validUsername)
+
+// validPullInput is the input requesting pull credentials for
+// validServerAddress.
+var validPullInput = fmt.Sprintf(`{"ServerURL":%q,"Access":"pull"}`, validServerAddress)
+
 // mockProgram simulates interactions between the docker client and a remote
 // credentials-helper.
 // Unit tests inject this mocked command into the remote to control execution.
@@ -40,7 +48,7 @@ func (m *mockProgram) Output() ([]byte, error) {
 	switch m.arg {
 	case "erase":
 		switch inS {
-		case validServerAddress:
+		case validServerAddress, validAccountInput:
 			return nil, nil
 		default:
 			return []byte("program failed"), errProgramExited
@@ -51,6 +59,10 @@ func (m *mockProgram) Output() ([]byte, error) {
 			return []byte(`{"Username": "foo", "Secret": "bar"}`), nil
 		case validServerAddress2:
 			return []byte(`{"Username": "<token>", "Secret": "abcd1234"}`), nil
+		case validAccountInput:
+			return []byte(`{"Username": "linus", "Secret": "baz"}`), nil
+		case validPullInput:
+			return []byte(`{"Username": "reader", "Secret": "qux", "Access": "pull"}`), nil
 		case missingCredsAddress:
 			return []byte(credentials.NewErrCredentialsNotFound().Error()), errProgramExited
 		case invalidServerAddress:
@@ -207,6 +219,40 @@ func TestErase(t *testing.T) {
 	}
 }
 
+func TestGetAccount(t *testing.T) {
+	c, err := GetAccount(mockProgramFn, validServerAddress, validUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServerURL != validServerAddress || c.Username != validUsername || c.Secret != "baz" {
+		t.Errorf("unexpected credentials %+v", c)
+	}
+
+	if _, err := GetAccount(mockProgramFn, validServerAddress, "other"); err == nil {
+		t.Error("expected error for unknown account")
+	}
+}
+
+func TestGetForAccess(t *testing.T) {
+	c, err := GetForAccess(mockProgramFn, validServerAddress, credentials.AccessPull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServerURL != validServerAddress || c.Username != "reader" || c.Secret != "qux" || c.Access != credentials.AccessPull {
+		t.Errorf("unexpected credentials %+v", c)
+	}
+}
+
+func TestEraseAccount(t *testing.T) {
+	if err := EraseAccount(mockProgramFn, validServerAddress, validUsername); err != nil {
+		t.Error(err)
+	}
+
+	if err := EraseAccount(mockProgramFn, validServerAddress, "other"); err == nil {
+		t.Error("expected error for unknown account")
+	}
+}
+
 func TestList(t *testing.T) {
 	auths, err := List(mockProgramFn)
 	if err != nil {
@@ -1,9 +1,13 @@
 package client
 
 import (
+	"context"
 	"io"
 	"os"
 	"os/exec"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
 )
 
 // Program is an interface to execute external programs.
@@ -18,7 +22,7 @@ type ProgramFunc func(args ...string) Program
 // NewShellProgramFunc creates a [ProgramFunc] to run command in a [Shell].
 func NewShellProgramFunc(command string) ProgramFunc {
 	return func(args ...string) Program {
-		return createProgramCmdRedirectErr(command, args, nil)
+		return createProgramCmdRedirectErr(context.Background(), command, args, nil)
 	}
 }
 
@@ -26,12 +30,20 @@ func NewShellProgramFunc(command string) ProgramFunc {
 // in a [Shell] with the given environment variables.
 func NewShellProgramFuncWithEnv(command string, env *map[string]string) ProgramFunc {
 	return func(args ...string) Program {
-		return createProgramCmdRedirectErr(command, args, env)
+		return createProgramCmdRedirectErr(context.Background(), command, args, env)
+	}
+}
+
+// newHelperProgramFunc creates a [ProgramFunc] to run the
+// docker-credential-<helper> program, which is killed when ctx is done.
+func newHelperProgramFunc(ctx context.Context, helper string) ProgramFunc {
+	return func(args ...string) Program {
+		return createProgramCmdRedirectErr(ctx, "docker-credential-"+helper, args, nil)
 	}
 }
 
-func createProgramCmdRedirectErr(command string, args []string, env *map[string]string) *Shell {
-	ec := exec.Command(command, args...)
+func createProgramCmdRedirectErr(ctx context.Context, command string, args []string, env *map[string]string) *Shell {
+	ec := exec.CommandContext(ctx, command, args...)
 	if env != nil {
 		for k, v := range *env {
 			ec.Env = append(ec.Environ(), k+"="+v)
@@ -48,7 +60,10 @@ type Shell struct {
 
 // Output returns responses from the remote credentials-helper.
 func (s *Shell) Output() ([]byte, error) {
-	return s.cmd.Output()
+	start := time.Now()
+	out, err := s.cmd.Output()
+	credentials.Logger().Debug("ran credentials helper", "path", s.cmd.Path, "args", s.cmd.Args[1:], "duration", time.Since(start), "error", err)
+	return out, err
 }
 
 // Input sets the input to send to a remote credentials-helper.
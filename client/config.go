@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// dockerHubServerURL is the key of Docker Hub in docker's config file, and
+// the server URL docker passes to credentials helpers for it.
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// tokenUsername is the username identity tokens are returned with, as
+// credentials helpers store them.
+const tokenUsername = "<token>"
+
+// dockerConfig is the part of docker's config file about credentials.
+type dockerConfig struct {
+	Auths       map[string]authEntry `json:"auths"`
+	CredsStore  string               `json:"credsStore"`
+	CredHelpers map[string]string    `json:"credHelpers"`
+}
+
+// authEntry is an entry of the auths of docker's config file.
+type authEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// Resolver finds the credentials for registries the way docker does, from
+// its config file: with the helper set in credHelpers for the registry,
+// else with the credsStore helper, else from the credentials stored in
+// plain text in auths.
+type Resolver struct {
+	config dockerConfig
+	// program creates the ProgramFunc running the helper with the given
+	// name.
+	program func(ctx context.Context, helper string) ProgramFunc
+}
+
+// NewResolver creates a Resolver from docker's config file at configFile.
+// If configFile is empty, it is config.json in $DOCKER_CONFIG, or in
+// ~/.docker. A missing config file is treated as an empty one, as docker
+// does.
+func NewResolver(configFile string) (*Resolver, error) {
+	if configFile == "" {
+		var err error
+		if configFile, err = credentials.DockerConfigFile(); err != nil {
+			return nil, err
+		}
+	}
+	r := &Resolver{program: newHelperProgramFunc}
+	b, err := os.ReadFile(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &r.config); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %w", configFile, err)
+	}
+	return r, nil
+}
+
+// HelperName returns the name of the credentials helper docker uses for
+// registry, without the docker-credential- prefix, or an empty string if
+// it uses the credentials stored in plain text in its config file.
+func (r *Resolver) HelperName(registry string) string {
+	key := normalizeRegistry(registry)
+	candidates := []string{key}
+	if key == dockerHubServerURL {
+		candidates = append(candidates, "docker.io", "index.docker.io")
+	}
+	for _, k := range candidates {
+		if h, ok := r.config.CredHelpers[k]; ok {
+			return h
+		}
+	}
+	return r.config.CredsStore
+}
+
+// Resolve returns the credentials for registry, which can be a hostname,
+// a URL, or a reference to a repository, such as ghcr.io/org/image. Docker
+// Hub can be given as docker.io, index.docker.io, or docker's
+// https://index.docker.io/v1/. Identity tokens are returned with the
+// <token> username.
+//
+// It returns an error satisfying credentials.IsErrCredentialsNotFound if
+// there are no credentials for registry.
+func (r *Resolver) Resolve(ctx context.Context, registry string) (*credentials.Credentials, error) {
+	key := normalizeRegistry(registry)
+	if key == "" {
+		return nil, credentials.NewErrCredentialsMissingServerURL()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if helper := r.HelperName(key); helper != "" {
+		creds, err := Get(r.program(ctx, helper), key)
+		if err == nil {
+			creds.ServerURL = key
+			return creds, nil
+		}
+		if !credentials.IsErrCredentialsNotFound(err) {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, fmt.Errorf("docker-credential-%s: %w", helper, err)
+		}
+	}
+
+	if creds, err := r.auth(key); err != nil || creds != nil {
+		return creds, err
+	}
+	return nil, credentials.NewErrCredentialsNotFound()
+}
+
+// auth returns the credentials stored in plain text in auths for the
+// registry with the given key, or nil if there are none. Entries are
+// matched exactly first, then by hostname, ignoring their scheme and path.
+func (r *Resolver) auth(key string) (*credentials.Credentials, error) {
+	if entry, ok := r.config.Auths[key]; ok {
+		return entry.credentials(key)
+	}
+	keys := make([]string, 0, len(r.config.Auths))
+	for k := range r.config.Auths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if normalizeRegistry(k) != key {
+			continue
+		}
+		creds, err := r.config.Auths[k].credentials(key)
+		if err != nil || creds != nil {
+			return creds, err
+		}
+	}
+	return nil, nil
+}
+
+// credentials decodes the credentials of the entry, or returns nil if it
+// has none.
+func (e authEntry) credentials(serverURL string) (*credentials.Credentials, error) {
+	creds := &credentials.Credentials{ServerURL: serverURL, Username: e.Username, Secret: e.Password}
+	if e.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth for %s: %w", serverURL, err)
+		}
+		var ok bool
+		if creds.Username, creds.Secret, ok = strings.Cut(string(decoded), ":"); !ok {
+			return nil, fmt.Errorf("invalid auth for %s: missing password", serverURL)
+		}
+	}
+	if e.IdentityToken != "" {
+		creds.Username, creds.Secret = tokenUsername, e.IdentityToken
+	}
+	if creds.Username == "" || creds.Secret == "" {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// normalizeRegistry returns the key docker uses for registry in its config
+// file and with credentials helpers: the hostname, with its port, or
+// https://index.docker.io/v1/ for Docker Hub.
+func normalizeRegistry(registry string) string {
+	host := registry
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubServerURL
+	}
+	return host
+}
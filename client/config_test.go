@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// fakeHelperProgram is a credentials helper answering get with the
+// credentials it holds.
+type fakeHelperProgram struct {
+	creds map[string]credentials.Credentials
+	calls *[]string
+	arg   string
+	input io.Reader
+}
+
+func (p *fakeHelperProgram) Output() ([]byte, error) {
+	in, err := io.ReadAll(p.input)
+	if err != nil {
+		return nil, err
+	}
+	*p.calls = append(*p.calls, p.arg+" "+string(in))
+	if p.arg != "get" {
+		return []byte("unsupported"), errProgramExited
+	}
+	creds, ok := p.creds[string(in)]
+	if !ok {
+		return []byte(credentials.NewErrCredentialsNotFound().Error()), errProgramExited
+	}
+	return json.Marshal(creds)
+}
+
+func (p *fakeHelperProgram) Input(in io.Reader) {
+	p.input = in
+}
+
+const testDockerConfig = `{
+	"auths": {
+		"https://index.docker.io/v1/": {},
+		"https://plain.example.com/v1/": {"auth": "cGxhaW46c2VjcmV0"},
+		"token.example.com": {"identitytoken": "refresh-token"},
+		"ghcr.io": {"auth": "Z2hjcjpwbGFpbg=="}
+	},
+	"credsStore": "desktop",
+	"credHelpers": {
+		"ghcr.io": "gh",
+		"docker.io": "hub"
+	}
+}`
+
+func newTestResolver(t *testing.T, config string, helpers map[string]map[string]credentials.Credentials) (*Resolver, *[]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	r.program = func(_ context.Context, helper string) ProgramFunc {
+		return func(args ...string) Program {
+			calls = append(calls, helper)
+			return &fakeHelperProgram{creds: helpers[helper], calls: &calls, arg: args[0]}
+		}
+	}
+	return r, &calls
+}
+
+func TestResolverHelperName(t *testing.T) {
+	r, _ := newTestResolver(t, testDockerConfig, nil)
+	tests := map[string]string{
+		"ghcr.io":                     "gh",
+		"https://ghcr.io/v2/":         "gh",
+		"ghcr.io/org/image":           "gh",
+		"docker.io":                   "hub",
+		"https://index.docker.io/v1/": "hub",
+		"registry.example.com:5000":   "desktop",
+	}
+	for registry, expected := range tests {
+		if helper := r.HelperName(registry); helper != expected {
+			t.Errorf("%s: expected %s, got %s", registry, expected, helper)
+		}
+	}
+}
+
+func TestResolverResolve(t *testing.T) {
+	r, calls := newTestResolver(t, testDockerConfig, map[string]map[string]credentials.Credentials{
+		"gh":      {"ghcr.io": {Username: "octocat", Secret: "gh-token"}},
+		"hub":     {dockerHubServerURL: {Username: "whale", Secret: "hub-token"}},
+		"desktop": {"registry.example.com:5000": {Username: "foo", Secret: "bar"}},
+	})
+
+	tests := []struct {
+		registry string
+		expected credentials.Credentials
+		calls    []string
+	}{
+		{
+			registry: "ghcr.io/org/image",
+			expected: credentials.Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "gh-token"},
+			calls:    []string{"gh", "get ghcr.io"},
+		},
+		{
+			registry: "docker.io",
+			expected: credentials.Credentials{ServerURL: dockerHubServerURL, Username: "whale", Secret: "hub-token"},
+			calls:    []string{"hub", "get " + dockerHubServerURL},
+		},
+		{
+			registry: "https://registry.example.com:5000",
+			expected: credentials.Credentials{ServerURL: "registry.example.com:5000", Username: "foo", Secret: "bar"},
+			calls:    []string{"desktop", "get registry.example.com:5000"},
+		},
+		{
+			registry: "plain.example.com",
+			expected: credentials.Credentials{ServerURL: "plain.example.com", Username: "plain", Secret: "secret"},
+			calls:    []string{"desktop", "get plain.example.com"},
+		},
+		{
+			registry: "token.example.com",
+			expected: credentials.Credentials{ServerURL: "token.example.com", Username: tokenUsername, Secret: "refresh-token"},
+			calls:    []string{"desktop", "get token.example.com"},
+		},
+	}
+	for _, tc := range tests {
+		*calls = nil
+		creds, err := r.Resolve(context.Background(), tc.registry)
+		if err != nil {
+			t.Errorf("%s: %v", tc.registry, err)
+			continue
+		}
+		if *creds != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.registry, tc.expected, *creds)
+		}
+		if len(*calls) != len(tc.calls) || (*calls)[0] != tc.calls[0] || (*calls)[1] != tc.calls[1] {
+			t.Errorf("%s: expected calls %q, got %q", tc.registry, tc.calls, *calls)
+		}
+	}
+
+	if _, err := r.Resolve(context.Background(), "missing.example.com"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), ""); !credentials.IsCredentialsMissingServerURL(err) {
+		t.Errorf("expected missing server URL, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	*calls = nil
+	if _, err := r.Resolve(ctx, "ghcr.io"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("expected no helper to run, got %q", *calls)
+	}
+}
+
+func TestResolverHelperError(t *testing.T) {
+	r, _ := newTestResolver(t, `{"credsStore": "desktop", "auths": {"ghcr.io": {"auth": "Z2hjcjpwbGFpbg=="}}}`, nil)
+	r.program = func(context.Context, string) ProgramFunc { return mockProgramFn }
+	_, err := r.Resolve(context.Background(), invalidServerAddress)
+	if err == nil || credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected helper error, got %v", err)
+	}
+}
+
+func TestResolverPlainText(t *testing.T) {
+	r, calls := newTestResolver(t, `{"auths": {"https://ghcr.io": {"username": "octocat", "password": "hunter2"}}}`, nil)
+	creds, err := r.Resolve(context.Background(), "ghcr.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "octocat" || creds.Secret != "hunter2" {
+		t.Errorf("expected octocat:hunter2, got %s:%s", creds.Username, creds.Secret)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("expected no helper to run, got %q", *calls)
+	}
+
+	r, _ = newTestResolver(t, `{"auths": {"ghcr.io": {"auth": "invalid"}}}`, nil)
+	if _, err := r.Resolve(context.Background(), "ghcr.io"); err == nil || credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected invalid auth error, got %v", err)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	r, err := NewResolver("")
+	if err != nil {
+		t.Fatalf("expected missing config file to be ignored, got %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), "ghcr.io"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewResolver(""); err == nil {
+		t.Error("expected error with invalid config file")
+	}
+}
+
+func ExampleResolver_Resolve() {
+	r, err := NewResolver("")
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return
+	}
+
+	creds, err := r.Resolve(context.Background(), "ghcr.io")
+	if err != nil {
+		_, _ = fmt.Println(err)
+		return
+	}
+
+	_, _ = fmt.Printf("Got credentials for user `%s` in `%s`\n", creds.Username, creds.ServerURL)
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// Helper is a [credentials.Helper] that delegates every operation to an
+// external credentials-helper program. It allows programs such as
+// docker-credential-pass to be combined with in-process helpers, for
+// example as a backend of a [credentials.Router].
+type Helper struct {
+	program ProgramFunc
+}
+
+// NewHelper creates a Helper that runs program for every operation.
+func NewHelper(program ProgramFunc) *Helper {
+	return &Helper{program: program}
+}
+
+// Add stores credentials using the external program. The access scope of
+// secrets returned by Get is passed apart, as the program expects it.
+func (h *Helper) Add(creds *credentials.Credentials) error {
+	if creds != nil && creds.Access == "" {
+		c := *creds
+		c.Secret, c.Access = credentials.SplitSecretAccess(creds.Secret)
+		creds = &c
+	}
+	return Store(h.program, creds)
+}
+
+// Delete removes credentials using the external program.
+func (h *Helper) Delete(serverURL string) error {
+	return Erase(h.program, serverURL)
+}
+
+// Get retrieves credentials using the external program. The secret of
+// pull-only credentials carries their access scope, see
+// [credentials.SecretWithAccess].
+func (h *Helper) Get(serverURL string) (string, string, error) {
+	creds, err := Get(h.program, serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	return creds.Username, credentials.SecretWithAccess(creds.Secret, creds.Access), nil
+}
+
+// List returns the server URLs and usernames known to the external program.
+func (h *Helper) List() (map[string]string, error) {
+	return List(h.program)
+}
+
+// ListAll returns all the accounts known to the external program, see
+// [credentials.MultiAccountHelper].
+func (h *Helper) ListAll() (map[string]string, error) {
+	return ListAll(h.program)
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+func TestHelper(t *testing.T) {
+	var _ credentials.Helper = &Helper{}
+
+	h := NewHelper(mockProgramFn)
+
+	if err := h.Add(&credentials.Credentials{ServerURL: validServerAddress, Username: "foo", Secret: "bar"}); err != nil {
+		t.Error(err)
+	}
+
+	username, secret, err := h.Get(validServerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "foo" || secret != "bar" {
+		t.Errorf("expected foo:bar, got %s:%s", username, secret)
+	}
+
+	if _, _, err := h.Get(missingCredsAddress); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+
+	auths, err := h.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auths[validServerAddress] != validUsername {
+		t.Errorf("expected %s for %s, got %v", validUsername, validServerAddress, auths)
+	}
+
+	if err := h.Delete(validServerAddress); err != nil {
+		t.Error(err)
+	}
+}
+
+// storeProgram is a credentials-helper program keeping credentials in
+// memory, along with their access scope.
+type storeProgram struct {
+	arg   string
+	input io.Reader
+	store map[string]credentials.Credentials
+}
+
+func (p *storeProgram) Input(in io.Reader) {
+	p.input = in
+}
+
+func (p *storeProgram) Output() ([]byte, error) {
+	in, err := io.ReadAll(p.input)
+	if err != nil {
+		return nil, err
+	}
+	switch p.arg {
+	case "store":
+		var c credentials.Credentials
+		if err := json.Unmarshal(in, &c); err != nil {
+			return []byte(err.Error()), errProgramExited
+		}
+		p.store[c.ServerURL] = c
+		return nil, nil
+	case "get":
+		c, ok := p.store[string(in)]
+		if !ok {
+			return []byte(credentials.NewErrCredentialsNotFound().Error()), errProgramExited
+		}
+		return json.Marshal(c)
+	case "list":
+		accts := make(map[string]string)
+		for serverURL, c := range p.store {
+			accts[serverURL] = c.Username
+		}
+		return json.Marshal(accts)
+	}
+	return []byte("unknown argument " + p.arg), errProgramExited
+}
+
+func TestHelperAccess(t *testing.T) {
+	store := make(map[string]credentials.Credentials)
+	h := NewHelper(func(args ...string) Program {
+		return &storeProgram{arg: args[0], store: store}
+	})
+
+	// Secrets are passed as a Helper returns them, with their access scope.
+	if err := h.Add(&credentials.Credentials{ServerURL: validServerAddress, Username: "reader", Secret: credentials.SecretWithAccess("bar", credentials.AccessPull)}); err != nil {
+		t.Fatal(err)
+	}
+	if c := store[validServerAddress]; c.Secret != "bar" || c.Access != credentials.AccessPull {
+		t.Errorf("expected pull-only credentials to be stored, got %+v", c)
+	}
+
+	_, secret, err := h.Get(validServerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, access := credentials.SplitSecretAccess(secret); s != "bar" || access != credentials.AccessPull {
+		t.Errorf("expected pull-only secret, got %s (%q)", s, access)
+	}
+
+	// Wrappers of the helper see the credentials as pull-only.
+	out := new(bytes.Buffer)
+	if err := credentials.Get(h, strings.NewReader(`{"ServerURL": "`+validServerAddress+`", "Access": "push"}`), out); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected no credentials to push, got %s, %v", out, err)
+	}
+	out.Reset()
+	if err := credentials.Get(h, strings.NewReader(validServerAddress), out); err != nil {
+		t.Fatal(err)
+	}
+	var creds credentials.Credentials
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+		t.Fatal(err)
+	}
+	if creds.Secret != "bar" || creds.Access != credentials.AccessPull {
+		t.Errorf("expected pull-only credentials, got %+v", creds)
+	}
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker-credential-helpers/internal/registryauth"
+)
+
+// tokenClientID identifies the transport to token services, which require
+// a client ID for refresh token grants.
+const tokenClientID = "docker-credential-helpers"
+
+// CredentialsFunc returns the credentials for a registry, given as a
+// hostname with its port. It returns an error satisfying
+// credentials.IsErrCredentialsNotFound if there are none. Resolver.Resolve
+// is a CredentialsFunc.
+type CredentialsFunc func(ctx context.Context, registry string) (*credentials.Credentials, error)
+
+// HelperCredentials creates a CredentialsFunc getting credentials from the
+// credentials helper run by program.
+func HelperCredentials(program ProgramFunc) CredentialsFunc {
+	return func(_ context.Context, registry string) (*credentials.Credentials, error) {
+		return Get(program, normalizeRegistry(registry))
+	}
+}
+
+// Transport is an http.RoundTripper authenticating requests to registries
+// implementing the OCI distribution specification. It answers the
+// WWW-Authenticate challenges of registries with Basic authentication, or
+// with bearer tokens obtained from their token service, using the
+// credentials returned by a CredentialsFunc. Without credentials, it
+// requests anonymous bearer tokens.
+//
+// Bearer tokens are cached per registry and scope until they expire, and
+// the scheme registries use is remembered, so later requests are
+// authenticated without waiting for a challenge.
+type Transport struct {
+	base        http.RoundTripper
+	credentials CredentialsFunc
+
+	mu         sync.Mutex
+	challenges map[string]registryauth.Challenge
+	tokens     map[string]registryauth.Token
+}
+
+// NewTransport creates a Transport sending requests with base, or with
+// http.DefaultTransport if base is nil.
+func NewTransport(base http.RoundTripper, creds CredentialsFunc) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		base:        base,
+		credentials: creds,
+		challenges:  map[string]registryauth.Challenge{},
+		tokens:      map[string]registryauth.Token{},
+	}
+}
+
+// RoundTrip sends the request, authenticating it if the registry requires
+// it. Requests that already have an Authorization header are sent as they
+// are.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	host := req.URL.Host
+	scope := requestScope(req)
+
+	authorization, err := t.cachedAuthorization(req.Context(), req.URL, scope)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req, authorization)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	c, ok := registryauth.PreferredChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+	// The request can only be sent again if its body can be read again.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	t.mu.Lock()
+	t.challenges[host] = c
+	t.mu.Unlock()
+
+	if s := c.Params["scope"]; s != "" {
+		scope = s
+	}
+	retry, err := t.authorize(req.Context(), req.URL, c, scope)
+	if err != nil {
+		registryauth.CloseResponse(resp)
+		return nil, err
+	}
+	if retry == "" || retry == authorization {
+		return resp, nil
+	}
+	registryauth.CloseResponse(resp)
+	return t.send(req, retry)
+}
+
+// send sends a copy of req with the given Authorization header, if any.
+func (t *Transport) send(req *http.Request, authorization string) (*http.Response, error) {
+	if authorization == "" {
+		return t.base.RoundTrip(req)
+	}
+	r := req.Clone(req.Context())
+	if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	r.Header.Set("Authorization", authorization)
+	return t.base.RoundTrip(r)
+}
+
+// cachedAuthorization returns the Authorization header for a request to
+// u, if the scheme of the registry is known and, for bearer tokens, a
+// token for scope is cached.
+func (t *Transport) cachedAuthorization(ctx context.Context, u *url.URL, scope string) (string, error) {
+	host := u.Host
+	t.mu.Lock()
+	c, ok := t.challenges[host]
+	token, cached := t.tokens[tokenKey(host, scope)]
+	t.mu.Unlock()
+	switch {
+	case !ok:
+		return "", nil
+	case c.Scheme == "basic":
+		return t.authorize(ctx, u, c, scope)
+	case cached && time.Now().Before(token.Expires):
+		return "Bearer " + token.Token, nil
+	}
+	return "", nil
+}
+
+// authorize returns the Authorization header answering challenge c for a
+// request to u, or an empty string if it cannot be answered.
+func (t *Transport) authorize(ctx context.Context, u *url.URL, c registryauth.Challenge, scope string) (string, error) {
+	host := u.Host
+	creds, err := t.credentials(ctx, host)
+	if err != nil {
+		if !credentials.IsErrCredentialsNotFound(err) {
+			return "", fmt.Errorf("getting credentials for %s: %w", host, err)
+		}
+		creds = nil
+	}
+	switch c.Scheme {
+	case "basic":
+		if creds == nil || creds.Username == tokenUsername {
+			return "", nil
+		}
+		r := &http.Request{Header: http.Header{}}
+		r.SetBasicAuth(creds.Username, creds.Secret)
+		return r.Header.Get("Authorization"), nil
+	case "bearer":
+		r := registryauth.TokenRequest{Challenge: c, Scopes: strings.Fields(scope), ClientID: tokenClientID, RegistryScheme: u.Scheme}
+		switch {
+		case creds == nil:
+		case creds.Username == tokenUsername:
+			r.RefreshToken = creds.Secret
+		default:
+			r.Username, r.Password = creds.Username, creds.Secret
+		}
+		token, err := registryauth.FetchToken(ctx, t.base, r)
+		if err != nil {
+			return "", fmt.Errorf("getting token for %s: %w", host, err)
+		}
+		t.mu.Lock()
+		t.tokens[tokenKey(host, scope)] = token
+		t.mu.Unlock()
+		return "Bearer " + token.Token, nil
+	}
+	return "", nil
+}
+
+// tokenKey is the key of the bearer token cached for scope on host.
+func tokenKey(host, scope string) string {
+	return host + " " + scope
+}
+
+// requestScope returns the scope a request needs, from its path, such as
+// repository:library/alpine:pull for a request to
+// /v2/library/alpine/manifests/latest.
+func requestScope(req *http.Request) string {
+	name, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		return ""
+	}
+	for _, endpoint := range []string{"/manifests/", "/blobs/", "/tags/", "/referrers/"} {
+		if i := strings.LastIndex(name, endpoint); i > 0 {
+			action := "pull"
+			switch req.Method {
+			case http.MethodGet, http.MethodHead:
+			case http.MethodDelete:
+				action = "delete"
+			default:
+				action = "pull,push"
+			}
+			return "repository:" + name[:i] + ":" + action
+		}
+	}
+	return ""
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// fakeRegistry is a registry requiring bearer tokens from its token
+// service, which issues tokens named after their scope.
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	tokenRequests []string
+	uploads       []string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		var scope, auth string
+		if req.Method == http.MethodPost {
+			if err := req.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.PostForm.Get("grant_type") != "refresh_token" || req.PostForm.Get("client_id") == "" {
+				http.Error(w, "invalid grant", http.StatusBadRequest)
+				return
+			}
+			scope, auth = req.PostForm.Get("scope"), "refresh:"+req.PostForm.Get("refresh_token")
+		} else {
+			scope = strings.Join(req.URL.Query()["scope"], " ")
+			if username, password, ok := req.BasicAuth(); ok {
+				auth = username + ":" + password
+			}
+		}
+		if req.FormValue("service") != "registry.example.com" {
+			http.Error(w, "invalid service", http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.tokenRequests = append(r.tokenRequests, auth+" "+scope)
+		r.mu.Unlock()
+		_, _ = fmt.Fprintf(w, `{"token": %q, "expires_in": 300}`, "token:"+scope)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		scope := requestScope(req)
+		if req.Header.Get("Authorization") != "Bearer token:"+scope {
+			challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registry.example.com"`, r.URL)
+			if scope != "" {
+				challenge += fmt.Sprintf(`,scope="%s"`, scope)
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if req.Method == http.MethodPut {
+			b, _ := io.ReadAll(req.Body)
+			r.mu.Lock()
+			r.uploads = append(r.uploads, string(b))
+			r.mu.Unlock()
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func staticCredentials(creds map[string]credentials.Credentials) CredentialsFunc {
+	return func(_ context.Context, registry string) (*credentials.Credentials, error) {
+		c, ok := creds[registry]
+		if !ok {
+			return nil, credentials.NewErrCredentialsNotFound()
+		}
+		return &c, nil
+	}
+}
+
+func doRequest(t *testing.T, client *http.Client, method, url, body string) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp
+}
+
+func TestTransportBearer(t *testing.T) {
+	registry := newFakeRegistry(t)
+	host := strings.TrimPrefix(registry.URL, "http://")
+	client := &http.Client{Transport: NewTransport(nil, staticCredentials(map[string]credentials.Credentials{
+		host: {Username: "foo", Secret: "bar"},
+	}))}
+
+	for _, r := range []struct{ method, path, body string }{
+		{http.MethodGet, "/v2/", ""},
+		{http.MethodGet, "/v2/library/alpine/manifests/latest", ""},
+		{http.MethodHead, "/v2/library/alpine/blobs/sha256:1234", ""},
+		{http.MethodGet, "/v2/library/alpine/manifests/latest", ""},
+		{http.MethodPut, "/v2/team/app/manifests/v1", "manifest"},
+		{http.MethodPut, "/v2/team/app/manifests/v2", "manifest"},
+	} {
+		if resp := doRequest(t, client, r.method, registry.URL+r.path, r.body); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s %s: expected 200, got %s", r.method, r.path, resp.Status)
+		}
+	}
+
+	expected := []string{
+		"foo:bar ",
+		"foo:bar repository:library/alpine:pull",
+		"foo:bar repository:team/app:pull,push",
+	}
+	if !reflect.DeepEqual(registry.tokenRequests, expected) {
+		t.Errorf("expected token requests %q, got %q", expected, registry.tokenRequests)
+	}
+	if expected := []string{"manifest", "manifest"}; !reflect.DeepEqual(registry.uploads, expected) {
+		t.Errorf("expected request bodies to be sent again, got %q", registry.uploads)
+	}
+}
+
+func TestTransportBearerTokenExpiry(t *testing.T) {
+	registry := newFakeRegistry(t)
+	host := strings.TrimPrefix(registry.URL, "http://")
+	transport := NewTransport(nil, staticCredentials(nil))
+	client := &http.Client{Transport: transport}
+
+	path := "/v2/library/alpine/manifests/latest"
+	doRequest(t, client, http.MethodGet, registry.URL+path, "")
+	transport.mu.Lock()
+	token := transport.tokens[tokenKey(host, "repository:library/alpine:pull")]
+	token.Expires = time.Now().Add(-time.Second)
+	transport.tokens[tokenKey(host, "repository:library/alpine:pull")] = token
+	transport.mu.Unlock()
+	if resp := doRequest(t, client, http.MethodGet, registry.URL+path, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %s", resp.Status)
+	}
+
+	expected := []string{" repository:library/alpine:pull", " repository:library/alpine:pull"}
+	if !reflect.DeepEqual(registry.tokenRequests, expected) {
+		t.Errorf("expected anonymous token requests %q, got %q", expected, registry.tokenRequests)
+	}
+}
+
+func TestTransportRefreshToken(t *testing.T) {
+	registry := newFakeRegistry(t)
+	host := strings.TrimPrefix(registry.URL, "http://")
+	client := &http.Client{Transport: NewTransport(nil, staticCredentials(map[string]credentials.Credentials{
+		host: {Username: tokenUsername, Secret: "refresh-token"},
+	}))}
+
+	if resp := doRequest(t, client, http.MethodGet, registry.URL+"/v2/library/alpine/tags/list", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %s", resp.Status)
+	}
+	expected := []string{"refresh:refresh-token repository:library/alpine:pull"}
+	if !reflect.DeepEqual(registry.tokenRequests, expected) {
+		t.Errorf("expected token requests %q, got %q", expected, registry.tokenRequests)
+	}
+}
+
+func TestTransportBasic(t *testing.T) {
+	var mu sync.Mutex
+	var challenged int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if username, password, ok := req.BasicAuth(); !ok || username != "foo" || password != "bar" {
+			mu.Lock()
+			challenged++
+			mu.Unlock()
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	client := &http.Client{Transport: NewTransport(nil, staticCredentials(map[string]credentials.Credentials{
+		host: {Username: "foo", Secret: "bar"},
+	}))}
+	for i := 0; i < 3; i++ {
+		if resp := doRequest(t, client, http.MethodGet, server.URL+"/v2/", ""); resp.StatusCode != http.StatusOK {
+			t.Errorf("expected 200, got %s", resp.Status)
+		}
+	}
+	mu.Lock()
+	if challenged != 1 {
+		t.Errorf("expected a single challenge, got %d", challenged)
+	}
+	mu.Unlock()
+
+	client = &http.Client{Transport: NewTransport(nil, staticCredentials(nil))}
+	if resp := doRequest(t, client, http.MethodGet, server.URL+"/v2/", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %s", resp.Status)
+	}
+}
+
+func TestTransportCredentialsError(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := &http.Client{Transport: NewTransport(nil, func(context.Context, string) (*credentials.Credentials, error) {
+		return nil, errProgramExited
+	})}
+	_, err := client.Get(registry.URL + "/v2/")
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || !strings.Contains(err.Error(), errProgramExited.Error()) {
+		t.Errorf("expected credentials error, got %v", err)
+	}
+}
+
+func TestHelperCredentials(t *testing.T) {
+	var calls []string
+	program := func(args ...string) Program {
+		return &fakeHelperProgram{
+			creds: map[string]credentials.Credentials{dockerHubServerURL: {Username: "whale", Secret: "hub-token"}},
+			calls: &calls,
+			arg:   args[0],
+		}
+	}
+	creds, err := HelperCredentials(program)(context.Background(), "registry-1.docker.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "whale" || creds.Secret != "hub-token" {
+		t.Errorf("expected whale:hub-token, got %s:%s", creds.Username, creds.Secret)
+	}
+	if _, err := HelperCredentials(program)(context.Background(), "ghcr.io"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+}
+
+func TestRequestScope(t *testing.T) {
+	tests := []struct {
+		method, path, scope string
+	}{
+		{http.MethodGet, "/v2/", ""},
+		{http.MethodGet, "/v2/_catalog", ""},
+		{http.MethodGet, "/v2/alpine/manifests/latest", "repository:alpine:pull"},
+		{http.MethodHead, "/v2/org/team/app/blobs/sha256:1234", "repository:org/team/app:pull"},
+		{http.MethodPost, "/v2/org/app/blobs/uploads/", "repository:org/app:pull,push"},
+		{http.MethodDelete, "/v2/org/app/manifests/sha256:1234", "repository:org/app:delete"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if scope := requestScope(req); scope != tc.scope {
+			t.Errorf("%s %s: expected %q, got %q", tc.method, tc.path, tc.scope, scope)
+		}
+	}
+}
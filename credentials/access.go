@@ -0,0 +1,225 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Access scopes of credentials, and actions credentials are requested for.
+const (
+	// AccessPull is the scope of read-only credentials, which can only pull
+	// images.
+	AccessPull = "pull"
+	// AccessPush is the scope of read-write credentials. Credentials
+	// without an access scope are read-write.
+	AccessPush = "push"
+)
+
+// envAccess is the environment variable holding the action credentials
+// are requested for when get does not tell, see SetDefaultAccess.
+const envAccess = "DOCKER_CREDENTIAL_HELPERS_ACCESS"
+
+// accessSecretPrefix marks the secrets of pull-only credentials in helpers,
+// which hold an accessSecret. The version in the marker allows changing
+// the format. The secrets of pull-only OAuth2 credentials are marked in
+// their OAuth2Token instead.
+const accessSecretPrefix = "docker-credential-helpers/v1:"
+
+// accessSecret is the secret of pull-only credentials stored in helpers,
+// along with its access scope.
+type accessSecret struct {
+	Access string `json:"access"`
+	Secret string `json:"secret"`
+}
+
+// defaultAccess is the action credentials are requested for when get does
+// not tell.
+var defaultAccess string
+
+// SetDefaultAccess sets the action, AccessPull or AccessPush, Get requests
+// credentials for when its input does not tell. Build jobs that only pull
+// set it to AccessPull, so they are returned pull-only credentials where
+// there are some.
+func SetDefaultAccess(access string) {
+	defaultAccess = access
+}
+
+// validateAccess checks that access is a known access scope, or empty.
+func validateAccess(access string) error {
+	switch access {
+	case "", AccessPull, AccessPush:
+		return nil
+	default:
+		return fmt.Errorf("unknown access %q: must be %s or %s", access, AccessPull, AccessPush)
+	}
+}
+
+// accessFromEnv returns the default access set in the environment, if any.
+func accessFromEnv() (string, error) {
+	access := os.Getenv(envAccess)
+	if err := validateAccess(access); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", envAccess, err)
+	}
+	return access, nil
+}
+
+// withAccess returns secret as stored in helpers for credentials with the
+// given access scope.
+func withAccess(secret, access string) string {
+	if access != AccessPull {
+		return secret
+	}
+	if _, a := splitAccess(secret); a == AccessPull {
+		return secret
+	}
+	if token, ok := parseOAuth2Secret(secret); ok {
+		token.Access = AccessPull
+		if b, err := json.Marshal(token); err == nil {
+			return oauth2SecretPrefix + string(b)
+		}
+	}
+	b, err := json.Marshal(accessSecret{Access: access, Secret: secret})
+	if err != nil {
+		return secret
+	}
+	return accessSecretPrefix + string(b)
+}
+
+// SecretWithAccess returns secret as a Helper returns it for credentials
+// with the given access scope. Helpers returning credentials retrieved as a
+// whole, such as those of another credentials-helper program, use it so
+// that pull-only credentials are not taken for read-write ones.
+func SecretWithAccess(secret, access string) string {
+	return withAccess(secret, access)
+}
+
+// SplitSecretAccess returns the secret and the access scope of a secret
+// returned by SecretWithAccess, or by a Helper. The access scope is empty
+// for read-write credentials.
+func SplitSecretAccess(secret string) (string, string) {
+	return splitAccess(secret)
+}
+
+// splitAccess returns the secret and the access scope of a secret stored
+// with withAccess. The access scope is empty for read-write credentials.
+func splitAccess(secret string) (string, string) {
+	if data, ok := strings.CutPrefix(secret, accessSecretPrefix); ok {
+		var s accessSecret
+		if err := json.Unmarshal([]byte(data), &s); err == nil && validateAccess(s.Access) == nil {
+			return s.Secret, s.Access
+		}
+	}
+	if token, ok := parseOAuth2Secret(secret); ok && token.Access == AccessPull {
+		return secret, AccessPull
+	}
+	return secret, ""
+}
+
+// validateSecret refuses secrets that would be taken for the secrets of
+// pull-only credentials once stored.
+func validateSecret(secret string) error {
+	if strings.HasPrefix(secret, accessSecretPrefix) {
+		return fmt.Errorf("secret must not start with %q", accessSecretPrefix)
+	}
+	return nil
+}
+
+// matchesAccess returns whether credentials with the scope credsAccess are
+// the least privileged credentials for access.
+func matchesAccess(credsAccess, access string) bool {
+	if access == AccessPull {
+		return credsAccess == AccessPull
+	}
+	return credsAccess != AccessPull
+}
+
+// resolveCredentials returns the key helper keeps the credentials Get
+// returns under: the account of username for serverURL, or its default
+// account if username is empty. If access is set, it selects the least
+// privileged credentials for it among the accounts of serverURL: pull-only
+// credentials to pull, falling back to read-write credentials if there are
+// none, and read-write credentials to push.
+//
+// If there are no credentials for serverURL, the credentials kept for the
+// closest registry namespace are selected, such as those of ghcr.io/org
+// for ghcr.io/org/app, or those of ghcr.io, or else those of the server
+// URL serverURL approximately matches, see lookupServerURL. The key is the
+// one listed by helper, so that wrappers such as OAuth2 store renewed
+// tokens back under it. If helper does not list the credentials, serverURL
+// is returned as it is.
+//
+// Credentials are only read from helper to learn their access scope, so
+// that the caller retrieves the selected credentials once.
+func resolveCredentials(helper Helper, serverURL, username, access string) (string, error) {
+	accts, err := listAll(helper)
+	if err != nil {
+		Logger().Debug("cannot list credentials to resolve server URL", "serverURL", serverURL, "error", err)
+	}
+	entry := serverURL
+	key, ok := accountKey(accts, entry, username)
+	if !ok {
+		if entry, ok = lookupServerURL(accts, serverURL, username); ok {
+			key, ok = accountKey(accts, entry, username)
+		}
+	}
+	if !ok {
+		return serverURL, nil
+	}
+	if access == "" {
+		return key, nil
+	}
+
+	credsAccess, err := accessOf(helper, key)
+	if err != nil || matchesAccess(credsAccess, access) {
+		return key, err
+	}
+	if username != "" {
+		if access == AccessPull {
+			return key, nil
+		}
+		Logger().Debug("credentials are pull-only", "serverURL", serverURL, "username", username)
+		return "", NewErrCredentialsNotFound()
+	}
+
+	var keys []string
+	for k, u := range accts {
+		if s, accountUsername := SplitAccountServerURL(k); s == entry && accountUsername != "" && u != accts[key] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		a, err := accessOf(helper, k)
+		if err != nil {
+			if IsErrCredentialsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		if matchesAccess(a, access) {
+			Logger().Debug("selected credentials for access", "serverURL", serverURL, "username", accts[k], "access", access)
+			return k, nil
+		}
+	}
+
+	if access == AccessPull {
+		Logger().Debug("no pull-only credentials, using read-write credentials", "serverURL", serverURL, "username", accts[key])
+		return key, nil
+	}
+	Logger().Debug("no read-write credentials", "serverURL", serverURL)
+	return "", NewErrCredentialsNotFound()
+}
+
+// accessOf returns the access scope of the credentials helper keeps under
+// key.
+func accessOf(helper Helper, key string) (string, error) {
+	_, secret, err := helper.Get(key)
+	if err != nil {
+		return "", err
+	}
+	_, access := splitAccess(secret)
+	return access, nil
+}
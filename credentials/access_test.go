@@ -0,0 +1,145 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWithAccess(t *testing.T) {
+	oauth2Secret := oauth2SecretPrefix + `{"tokenURL":"https://auth.example.com/token","refreshToken":"refresh"}`
+	tests := []struct {
+		secret, access string
+	}{
+		{secret: "secret", access: AccessPull},
+		{secret: "secret", access: ""},
+		{secret: oauth2Secret, access: AccessPull},
+		{secret: oauth2Secret, access: ""},
+	}
+	for _, tc := range tests {
+		stored := withAccess(tc.secret, tc.access)
+		if withAccess(stored, tc.access) != stored {
+			t.Errorf("%s, %s: expected withAccess to be idempotent", tc.secret, tc.access)
+		}
+		secret, access := splitAccess(stored)
+		if access != tc.access {
+			t.Errorf("%s: expected access %q, got %q", stored, tc.access, access)
+		}
+		if _, ok := parseOAuth2Secret(tc.secret); ok {
+			if _, ok := parseOAuth2Secret(secret); !ok {
+				t.Errorf("%s: expected an OAuth2 secret, got %s", stored, secret)
+			}
+		} else if secret != tc.secret {
+			t.Errorf("%s: expected secret %s, got %s", stored, tc.secret, secret)
+		}
+	}
+	if withAccess("secret", AccessPush) != "secret" {
+		t.Error("expected read-write secrets to be stored as is")
+	}
+}
+
+func TestStoreAccess(t *testing.T) {
+	s := newMemoryStore()
+	if err := Store(s, strings.NewReader(`{"ServerURL": "ghcr.io", "Username": "reader", "Secret": "secret", "Access": "pull"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, secret, err := s.Get("ghcr.io"); err != nil || secret != accessSecretPrefix+`{"access":"pull","secret":"secret"}` {
+		t.Errorf("expected pull-only secret, got %s, %v", secret, err)
+	}
+	if err := Store(s, strings.NewReader(`{"ServerURL": "ghcr.io", "Username": "reader", "Secret": "secret", "Access": "admin"}`)); err == nil {
+		t.Error("expected error with unknown access")
+	}
+
+	// Only the secrets of pull-only credentials are marked.
+	if err := Store(s, strings.NewReader(`{"ServerURL": "quay.io", "Username": "bot", "Secret": "pull-only:secret"}`)); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := getForAccess(t, s, "quay.io")
+	if err != nil || creds.Secret != "pull-only:secret" || creds.Access != "" {
+		t.Errorf("expected secret to be returned as stored, got %+v, %v", creds, err)
+	}
+	stored := withAccess("secret", AccessPull)
+	if err := Store(s, strings.NewReader(`{"ServerURL": "quay.io", "Username": "bot", "Secret": `+strconv.Quote(stored)+`}`)); err == nil {
+		t.Error("expected error storing a secret starting with the pull-only marker")
+	}
+}
+
+func getForAccess(t *testing.T, helper Helper, input string) (*Credentials, error) {
+	t.Helper()
+	out := new(bytes.Buffer)
+	if err := Get(helper, strings.NewReader(input), out); err != nil {
+		return nil, err
+	}
+	var creds Credentials
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+		t.Fatal(err)
+	}
+	return &creds, nil
+}
+
+func TestGetAccess(t *testing.T) {
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "ghcr.io", Username: "admin", Secret: "admin-secret"})
+	_ = s.Add(&Credentials{ServerURL: "ghcr.io", Username: "reader", Secret: withAccess("reader-secret", AccessPull)})
+	_ = s.Add(&Credentials{ServerURL: "docker.io", Username: "jane", Secret: "jane-secret"})
+	_ = s.Add(&Credentials{ServerURL: "quay.io", Username: "bot", Secret: withAccess("bot-secret", AccessPull)})
+
+	tests := []struct {
+		input    string
+		expected Credentials
+	}{
+		{input: "ghcr.io", expected: Credentials{ServerURL: "ghcr.io", Username: "admin", Secret: "admin-secret"}},
+		{input: `{"ServerURL": "ghcr.io", "Access": "pull"}`, expected: Credentials{ServerURL: "ghcr.io", Username: "reader", Secret: "reader-secret", Access: AccessPull}},
+		{input: `{"ServerURL": "ghcr.io", "Access": "push"}`, expected: Credentials{ServerURL: "ghcr.io", Username: "admin", Secret: "admin-secret"}},
+		{input: `{"ServerURL": "ghcr.io", "Username": "admin", "Access": "pull"}`, expected: Credentials{ServerURL: "ghcr.io", Username: "admin", Secret: "admin-secret"}},
+		// Read-write credentials are returned to pull when there are no
+		// pull-only credentials.
+		{input: `{"ServerURL": "docker.io", "Access": "pull"}`, expected: Credentials{ServerURL: "docker.io", Username: "jane", Secret: "jane-secret"}},
+		{input: "quay.io", expected: Credentials{ServerURL: "quay.io", Username: "bot", Secret: "bot-secret", Access: AccessPull}},
+	}
+	for _, tc := range tests {
+		creds, err := getForAccess(t, s, tc.input)
+		if err != nil {
+			t.Errorf("%s: %v", tc.input, err)
+			continue
+		}
+		if *creds != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.input, tc.expected, *creds)
+		}
+	}
+
+	for _, input := range []string{`{"ServerURL": "quay.io", "Access": "push"}`, `{"ServerURL": "ghcr.io", "Username": "reader", "Access": "push"}`} {
+		if _, err := getForAccess(t, s, input); !IsErrCredentialsNotFound(err) {
+			t.Errorf("%s: expected not found error, got %v", input, err)
+		}
+	}
+	if _, err := getForAccess(t, s, `{"ServerURL": "ghcr.io", "Access": "admin"}`); err == nil || IsErrCredentialsNotFound(err) {
+		t.Errorf("expected error with unknown access, got %v", err)
+	}
+
+	SetDefaultAccess(AccessPull)
+	defer SetDefaultAccess("")
+	if creds, err := getForAccess(t, s, "ghcr.io"); err != nil || creds.Username != "reader" {
+		t.Errorf("expected reader account with default access, got %+v, %v", creds, err)
+	}
+	if creds, err := getForAccess(t, s, `{"ServerURL": "ghcr.io", "Access": "push"}`); err != nil || creds.Username != "admin" {
+		t.Errorf("expected admin account to push, got %+v, %v", creds, err)
+	}
+}
+
+func TestAccessFromEnv(t *testing.T) {
+	t.Setenv(envAccess, "")
+	if access, err := accessFromEnv(); err != nil || access != "" {
+		t.Errorf("expected no access, got %q, %v", access, err)
+	}
+	t.Setenv(envAccess, "pull")
+	if access, err := accessFromEnv(); err != nil || access != AccessPull {
+		t.Errorf("expected pull access, got %q, %v", access, err)
+	}
+	t.Setenv(envAccess, "read")
+	if _, err := accessFromEnv(); err == nil {
+		t.Error("expected error with unknown access")
+	}
+}
@@ -0,0 +1,236 @@
+package credentials
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/registryurl"
+)
+
+// envDefaultAccount is the environment variable selecting the account Get
+// returns for server URLs with several accounts.
+const envDefaultAccount = "DOCKER_CREDENTIAL_HELPERS_DEFAULT_ACCOUNT"
+
+// AccountServerURL returns the server URL designating the account of
+// username among the accounts kept for serverURL, with the username as the
+// user information of the URL, such as https://robot@ghcr.io.
+//
+// Helpers keeping several accounts per server URL, such as pass, return the
+// account of the username for such server URLs, and their default account
+// for server URLs without user information. Other helpers report them as
+// not found. In List, helpers return the default account of a server URL
+// under the server URL, and the other accounts under their account server
+// URL.
+func AccountServerURL(serverURL, username string) string {
+	if username == "" {
+		return serverURL
+	}
+	scheme, rest := "", serverURL
+	if i := strings.Index(serverURL, "://"); i >= 0 {
+		scheme, rest = serverURL[:i+3], serverURL[i+3:]
+	}
+	return scheme + url.User(username).String() + "@" + rest
+}
+
+// SplitAccountServerURL returns the server URL and the username of an
+// account server URL returned by AccountServerURL. The username is empty
+// if serverURL does not designate an account.
+func SplitAccountServerURL(serverURL string) (string, string) {
+	scheme, rest := "", serverURL
+	if i := strings.Index(serverURL, "://"); i >= 0 {
+		scheme, rest = serverURL[:i+3], serverURL[i+3:]
+	}
+	host := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host = rest[:i]
+	}
+	i := strings.LastIndex(host, "@")
+	if i < 0 {
+		return serverURL, ""
+	}
+	username, err := url.PathUnescape(host[:i])
+	if err != nil || username == "" {
+		return serverURL, ""
+	}
+	return scheme + rest[i+1:], username
+}
+
+// Account is one of the accounts kept for a server URL.
+type Account struct {
+	ServerURL string
+	Username  string
+	// Default is whether Get returns this account for the server URL.
+	Default bool `json:",omitempty"`
+}
+
+// ListAccounts returns all the accounts kept by helper, sorted by server
+// URL, with the default account of each server URL first.
+func ListAccounts(helper Helper) ([]Account, error) {
+	accts, err := listAll(helper)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]Account, 0, len(accts))
+	for key, username := range accts {
+		serverURL, accountUsername := SplitAccountServerURL(key)
+		if accountUsername != "" {
+			username = accountUsername
+		}
+		accounts = append(accounts, Account{ServerURL: serverURL, Username: username, Default: accountUsername == ""})
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := accounts[i], accounts[j]
+		if a.ServerURL != b.ServerURL {
+			return a.ServerURL < b.ServerURL
+		}
+		if a.Default != b.Default {
+			return a.Default
+		}
+		return a.Username < b.Username
+	})
+	return accounts, nil
+}
+
+type defaultAccount struct {
+	// pattern is nil for the account used for all server URLs.
+	pattern  *registryurl.Pattern
+	username string
+}
+
+// DefaultAccount is a Helper selecting which of the accounts kept by
+// another helper for a server URL is its default account.
+type DefaultAccount struct {
+	helper   Helper
+	accounts []defaultAccount
+}
+
+// NewDefaultAccount wraps helper to make the account of a configured
+// username the default account of the server URLs it has one for. The
+// configuration is a comma-separated list of usernames, each preceded by
+// the registry pattern it applies to and an equal sign, such as
+// "ghcr.io=robot,*.example.com=jane". A username without a pattern applies
+// to all server URLs. The first entry matching a server URL is used.
+func NewDefaultAccount(helper Helper, config string) (*DefaultAccount, error) {
+	d := &DefaultAccount{helper: helper}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, username, hasPattern := strings.Cut(entry, "=")
+		if !hasPattern {
+			pattern, username = "", entry
+		}
+		if username == "" {
+			return nil, fmt.Errorf("no username for %q", pattern)
+		}
+		a := defaultAccount{username: username}
+		if hasPattern {
+			p, err := registryurl.ParsePattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			a.pattern = p
+		}
+		d.accounts = append(d.accounts, a)
+	}
+	return d, nil
+}
+
+// defaultUsername returns the username of the default account configured
+// for serverURL, if any.
+func (d *DefaultAccount) defaultUsername(serverURL string) string {
+	for _, a := range d.accounts {
+		if a.pattern == nil || a.pattern.MatchString(serverURL) {
+			return a.username
+		}
+	}
+	return ""
+}
+
+// Add stores credentials in the wrapped helper.
+func (d *DefaultAccount) Add(creds *Credentials) error {
+	return d.helper.Add(creds)
+}
+
+// Delete removes credentials from the wrapped helper.
+func (d *DefaultAccount) Delete(serverURL string) error {
+	return d.helper.Delete(serverURL)
+}
+
+// Get returns the configured default account for server URLs without
+// user information, if the wrapped helper has it, and its default
+// account otherwise.
+func (d *DefaultAccount) Get(serverURL string) (string, string, error) {
+	if _, username := SplitAccountServerURL(serverURL); username == "" {
+		if username = d.defaultUsername(serverURL); username != "" {
+			u, secret, err := d.helper.Get(AccountServerURL(serverURL, username))
+			if err == nil || !IsErrCredentialsNotFound(err) {
+				return u, secret, err
+			}
+		}
+	}
+	return d.helper.Get(serverURL)
+}
+
+// List returns the credentials of the wrapped helper, with the username of
+// the configured default accounts for their server URL.
+func (d *DefaultAccount) List() (map[string]string, error) {
+	if _, ok := d.helper.(MultiAccountHelper); !ok {
+		return d.helper.List()
+	}
+	accts, err := d.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	for key := range accts {
+		if _, username := SplitAccountServerURL(key); username != "" {
+			delete(accts, key)
+		}
+	}
+	return accts, nil
+}
+
+// ListAll returns all the accounts of the wrapped helper, with the
+// configured default accounts listed under their server URL.
+func (d *DefaultAccount) ListAll() (map[string]string, error) {
+	accts, err := listAll(d.helper)
+	if err != nil {
+		return nil, err
+	}
+	resp := make(map[string]string, len(accts))
+	for key, username := range accts {
+		resp[key] = username
+	}
+	for key, username := range accts {
+		serverURL, accountUsername := SplitAccountServerURL(key)
+		if accountUsername == "" || accountUsername != d.defaultUsername(serverURL) {
+			continue
+		}
+		previous, ok := accts[serverURL]
+		if !ok {
+			continue
+		}
+		delete(resp, key)
+		resp[serverURL] = username
+		resp[AccountServerURL(serverURL, previous)] = previous
+	}
+	return resp, nil
+}
+
+// defaultAccountFromEnv wraps helper to select the default accounts
+// configured in the environment, if any.
+func defaultAccountFromEnv(helper Helper) (Helper, error) {
+	v := os.Getenv(envDefaultAccount)
+	if v == "" {
+		return helper, nil
+	}
+	d, err := NewDefaultAccount(helper, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envDefaultAccount, err)
+	}
+	return d, nil
+}
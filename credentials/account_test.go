@@ -0,0 +1,291 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// accountStore keeps several accounts per server URL, and returns the
+// first username in lexicographic order as the default account.
+type accountStore struct {
+	secrets map[string]map[string]string
+}
+
+func newAccountStore() *accountStore {
+	return &accountStore{secrets: make(map[string]map[string]string)}
+}
+
+func (s *accountStore) usernames(serverURL string) []string {
+	var usernames []string
+	for username := range s.secrets[serverURL] {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func (s *accountStore) Add(creds *Credentials) error {
+	serverURL, _ := SplitAccountServerURL(creds.ServerURL)
+	if s.secrets[serverURL] == nil {
+		s.secrets[serverURL] = make(map[string]string)
+	}
+	s.secrets[serverURL][creds.Username] = creds.Secret
+	return nil
+}
+
+func (s *accountStore) Delete(serverURL string) error {
+	serverURL, username := SplitAccountServerURL(serverURL)
+	if username == "" {
+		delete(s.secrets, serverURL)
+		return nil
+	}
+	delete(s.secrets[serverURL], username)
+	return nil
+}
+
+func (s *accountStore) Get(serverURL string) (string, string, error) {
+	serverURL, username := SplitAccountServerURL(serverURL)
+	for _, u := range s.usernames(serverURL) {
+		if username == "" || u == username {
+			return u, s.secrets[serverURL][u], nil
+		}
+	}
+	return "", "", NewErrCredentialsNotFound()
+}
+
+func (s *accountStore) List() (map[string]string, error) {
+	return s.list(false), nil
+}
+
+func (s *accountStore) ListAll() (map[string]string, error) {
+	return s.list(true), nil
+}
+
+func (s *accountStore) list(all bool) map[string]string {
+	resp := make(map[string]string)
+	for serverURL := range s.secrets {
+		for i, username := range s.usernames(serverURL) {
+			if i == 0 {
+				resp[serverURL] = username
+			} else if all {
+				resp[AccountServerURL(serverURL, username)] = username
+			}
+		}
+	}
+	return resp
+}
+
+func TestAccountServerURL(t *testing.T) {
+	tests := []struct {
+		serverURL, username, expected string
+	}{
+		{serverURL: "https://ghcr.io", username: "robot", expected: "https://robot@ghcr.io"},
+		{serverURL: "ghcr.io/org", username: "robot", expected: "robot@ghcr.io/org"},
+		{serverURL: "https://registry.example.com:5000/v1/", username: "jane@example.com", expected: "https://jane%40example.com@registry.example.com:5000/v1/"},
+		{serverURL: "https://ghcr.io", username: "", expected: "https://ghcr.io"},
+	}
+	for _, tc := range tests {
+		actual := AccountServerURL(tc.serverURL, tc.username)
+		if actual != tc.expected {
+			t.Errorf("%s, %s: expected %s, got %s", tc.serverURL, tc.username, tc.expected, actual)
+		}
+		if serverURL, username := SplitAccountServerURL(actual); serverURL != tc.serverURL || username != tc.username {
+			t.Errorf("%s: expected %s, %s, got %s, %s", actual, tc.serverURL, tc.username, serverURL, username)
+		}
+	}
+
+	if serverURL, username := SplitAccountServerURL("https://ghcr.io/org@name"); serverURL != "https://ghcr.io/org@name" || username != "" {
+		t.Errorf("expected no account in path, got %s, %s", serverURL, username)
+	}
+}
+
+func TestGetAccount(t *testing.T) {
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"})
+	single := newMemoryStore()
+	_ = single.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+
+	tests := []struct {
+		helper   Helper
+		input    string
+		expected Credentials
+	}{
+		{helper: s, input: "https://ghcr.io", expected: Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"}},
+		{helper: s, input: `{"ServerURL": "https://ghcr.io", "Username": "robot"}`, expected: Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"}},
+		{helper: s, input: "https://robot@ghcr.io", expected: Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"}},
+		{helper: single, input: `{"ServerURL": "https://ghcr.io", "Username": "robot"}`, expected: Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"}},
+	}
+	for _, tc := range tests {
+		out := new(bytes.Buffer)
+		if err := Get(tc.helper, strings.NewReader(tc.input), out); err != nil {
+			t.Errorf("%s: %v", tc.input, err)
+			continue
+		}
+		var actual Credentials
+		if err := json.Unmarshal(out.Bytes(), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.input, tc.expected, actual)
+		}
+	}
+
+	for _, h := range []Helper{s, single} {
+		err := Get(h, strings.NewReader(`{"ServerURL": "https://ghcr.io", "Username": "john"}`), new(bytes.Buffer))
+		if !IsErrCredentialsNotFound(err) {
+			t.Errorf("expected not found error, got %v", err)
+		}
+	}
+	for _, input := range []string{`{"Username": "robot"}`, `{"ServerURL": "https://robot@ghcr.io"}`, `{"ServerURL": `} {
+		if err := Get(s, strings.NewReader(input), new(bytes.Buffer)); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestEraseAccount(t *testing.T) {
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"})
+	if err := Erase(s, strings.NewReader(`{"ServerURL": "https://ghcr.io", "Username": "jane"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if username, _, err := s.Get("https://ghcr.io"); err != nil || username != "robot" {
+		t.Errorf("expected robot account to be left, got %s, %v", username, err)
+	}
+
+	single := newMemoryStore()
+	_ = single.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	if err := Erase(single, strings.NewReader(`{"ServerURL": "https://ghcr.io", "Username": "jane"}`)); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if err := Erase(single, strings.NewReader(`{"ServerURL": "https://ghcr.io", "Username": "robot"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := single.Get("https://ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected robot account to be removed, got %v", err)
+	}
+}
+
+func TestListAll(t *testing.T) {
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"})
+
+	tests := []struct {
+		action   Action
+		expected map[string]string
+	}{
+		{action: ActionList, expected: map[string]string{"https://ghcr.io": "jane"}},
+		{action: ActionListAll, expected: map[string]string{"https://ghcr.io": "jane", "https://robot@ghcr.io": "robot"}},
+	}
+	for _, tc := range tests {
+		out := new(bytes.Buffer)
+		if err := HandleCommand(s, tc.action, nil, out); err != nil {
+			t.Fatalf("%s: %v", tc.action, err)
+		}
+		var actual map[string]string
+		if err := json.Unmarshal(out.Bytes(), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.action, tc.expected, actual)
+		}
+	}
+}
+
+func TestListAccounts(t *testing.T) {
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"})
+	_ = s.Add(&Credentials{ServerURL: "docker.io", Username: "jane", Secret: "hub-secret"})
+
+	accounts, err := ListAccounts(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Account{
+		{ServerURL: "docker.io", Username: "jane", Default: true},
+		{ServerURL: "https://ghcr.io", Username: "jane", Default: true},
+		{ServerURL: "https://ghcr.io", Username: "robot"},
+	}
+	if !reflect.DeepEqual(accounts, expected) {
+		t.Errorf("expected %v, got %v", expected, accounts)
+	}
+}
+
+func TestDefaultAccount(t *testing.T) {
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = s.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"})
+	_ = s.Add(&Credentials{ServerURL: "registry.example.com", Username: "jane", Secret: "example-secret"})
+
+	d, err := NewDefaultAccount(s, "ghcr.io=robot, john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username, secret, err := d.Get("https://ghcr.io"); err != nil || username != "robot" || secret != "robot-secret" {
+		t.Errorf("expected robot account, got %s:%s, %v", username, secret, err)
+	}
+	if username, _, err := d.Get("https://jane@ghcr.io"); err != nil || username != "jane" {
+		t.Errorf("expected jane account, got %s, %v", username, err)
+	}
+	// Server URLs without an account of the default username return the
+	// default account of the helper.
+	if username, _, err := d.Get("registry.example.com"); err != nil || username != "jane" {
+		t.Errorf("expected jane account, got %s, %v", username, err)
+	}
+
+	accts, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"https://ghcr.io":      "robot",
+		"registry.example.com": "jane",
+	}
+	if !reflect.DeepEqual(accts, expected) {
+		t.Errorf("expected %v, got %v", expected, accts)
+	}
+	accts, err = d.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]string{
+		"https://ghcr.io":      "robot",
+		"https://jane@ghcr.io": "jane",
+		"registry.example.com": "jane",
+	}
+	if !reflect.DeepEqual(accts, expected) {
+		t.Errorf("expected %v, got %v", expected, accts)
+	}
+
+	for _, config := range []string{"ghcr.io=", "ftp://ghcr.io=robot"} {
+		if _, err := NewDefaultAccount(s, config); err == nil {
+			t.Errorf("%s: expected error", config)
+		}
+	}
+}
+
+func TestDefaultAccountFromEnv(t *testing.T) {
+	s := newAccountStore()
+	t.Setenv(envDefaultAccount, "")
+	if h, err := defaultAccountFromEnv(s); err != nil || h != Helper(s) {
+		t.Errorf("expected helper to be returned as is, got %v, %v", h, err)
+	}
+	t.Setenv(envDefaultAccount, "robot")
+	if h, err := defaultAccountFromEnv(s); err != nil {
+		t.Error(err)
+	} else if _, ok := h.(*DefaultAccount); !ok {
+		t.Errorf("expected DefaultAccount, got %T", h)
+	}
+	t.Setenv(envDefaultAccount, "=robot")
+	if _, err := defaultAccountFromEnv(s); err == nil {
+		t.Error("expected error with invalid configuration")
+	}
+}
@@ -0,0 +1,339 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envAuditLog is the environment variable holding the path of the audit
+// log written by Serve.
+const envAuditLog = "DOCKER_CREDENTIAL_HELPERS_AUDIT_LOG"
+
+// Outcomes of an audited action.
+const (
+	AuditOutcomeSuccess  = "success"
+	AuditOutcomeNotFound = "not found"
+	AuditOutcomeDenied   = "denied"
+	AuditOutcomeError    = "error"
+)
+
+// auditLog is the audit log written by HandleCommand, if any.
+var auditLog *AuditLog
+
+// SetAuditLog sets the audit log HandleCommand records actions in. A nil
+// audit log disables auditing.
+func SetAuditLog(l *AuditLog) {
+	auditLog = l
+}
+
+// AuditRecord is a record of an action handled by a helper. It never
+// contains secrets. ResolvedServerURL is the server URL of the credentials
+// get or erase acted on, when it differs from the server URL requested,
+// such as the account server URL of the account requested, or the server
+// URL of the closest registry namespace.
+//
+// Records are hash-chained: Hash is the SHA-256 of the record with an empty
+// Hash, and Prev is the Hash of the previous record, so that records
+// removed or altered by accident break the chain. The hashes are not
+// keyed: anyone who can write the log can recompute them, so the chain
+// does not protect against deliberate tampering.
+type AuditRecord struct {
+	Seq               uint64    `json:"seq"`
+	Time              time.Time `json:"time"`
+	Helper            string    `json:"helper,omitempty"`
+	Action            Action    `json:"action"`
+	ServerURL         string    `json:"serverURL,omitempty"`
+	ResolvedServerURL string    `json:"resolvedServerURL,omitempty"`
+	Username          string    `json:"username,omitempty"`
+	Outcome           string    `json:"outcome"`
+	Error             string    `json:"error,omitempty"`
+	PID               int       `json:"pid,omitempty"`
+	Executable        string    `json:"executable,omitempty"`
+	Cgroup            string    `json:"cgroup,omitempty"`
+	Prev              string    `json:"prev"`
+	Hash              string    `json:"hash,omitempty"`
+}
+
+// SetCaller records the process that requested the action.
+func (r *AuditRecord) SetCaller(caller *Caller) {
+	if caller != nil {
+		r.PID, r.Executable, r.Cgroup = caller.PID, caller.Executable, caller.Cgroup
+	}
+}
+
+func (r AuditRecord) hash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditLog is an append-only log of audit records in JSON-lines format.
+//
+// The sequence number and hash of the last record are also kept in a
+// separate file, with a ".head" suffix, so that a truncated log can be
+// detected by VerifyAuditLog. As the head file is as writable as the log,
+// it does not protect against deliberate truncation.
+type AuditLog struct {
+	path string
+}
+
+// NewAuditLog creates an AuditLog writing to path.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Append adds a record to the log, filling in its sequence number and the
+// hashes chaining it to the previous record.
+func (l *AuditLog) Append(rec AuditRecord) error {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	last, err := readLastRecord(f)
+	if err != nil {
+		return err
+	}
+	rec.Seq, rec.Prev = 1, ""
+	if last != nil {
+		rec.Seq, rec.Prev = last.Seq+1, last.Hash
+	}
+	if rec.Hash, err = rec.hash(); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return writeAuditHead(l.path, rec)
+}
+
+// readLastRecord returns the last record of the log, or nil if the log is
+// empty.
+func readLastRecord(f *os.File) (*AuditRecord, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	// Records are much smaller than this, so the last one is bound to be
+	// in the tail of the file.
+	const tail = 64 * 1024
+	offset := size - tail
+	if offset < 0 {
+		offset = 0
+	}
+	buf := make([]byte, size-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	}
+
+	var rec AuditRecord
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return nil, fmt.Errorf("corrupt audit log %s: %w", f.Name(), err)
+	}
+	return &rec, nil
+}
+
+func writeAuditHead(path string, rec AuditRecord) error {
+	head := strconv.FormatUint(rec.Seq, 10) + " " + rec.Hash + "\n"
+	return os.WriteFile(path+".head", []byte(head), 0o600)
+}
+
+// VerifyAuditLog checks the audit log at path for accidental corruption. It
+// returns the number of records in the log, and an error if records have
+// been altered, inserted, reordered or removed without recomputing the
+// hash chain. It cannot detect a log rewritten with a consistent chain.
+func VerifyAuditLog(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var last AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return last.Seq, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Seq != last.Seq+1 {
+			return last.Seq, fmt.Errorf("line %d: expected record %d, got %d", line, last.Seq+1, rec.Seq)
+		}
+		if rec.Prev != last.Hash {
+			return last.Seq, fmt.Errorf("line %d: record %d is not chained to the previous record", line, rec.Seq)
+		}
+		if h, err := rec.hash(); err != nil || h != rec.Hash {
+			return last.Seq, fmt.Errorf("line %d: record %d has been modified", line, rec.Seq)
+		}
+		last = rec
+	}
+	if err := scanner.Err(); err != nil {
+		return last.Seq, err
+	}
+
+	head, err := os.ReadFile(path + ".head")
+	if err != nil {
+		if os.IsNotExist(err) && last.Seq == 0 {
+			return 0, nil
+		}
+		return last.Seq, fmt.Errorf("cannot verify the end of the audit log: %w", err)
+	}
+	if strings.TrimSpace(string(head)) != strconv.FormatUint(last.Seq, 10)+" "+last.Hash {
+		return last.Seq, fmt.Errorf("audit log has been truncated after record %d", last.Seq)
+	}
+	return last.Seq, nil
+}
+
+// auditOutcome classifies the error returned by an action.
+func auditOutcome(err error) string {
+	switch {
+	case err == nil:
+		return AuditOutcomeSuccess
+	case IsErrCredentialsNotFound(err):
+		return AuditOutcomeNotFound
+	case IsErrPolicyViolation(err), IsErrCallerDenied(err), IsErrReadOnly(err):
+		return AuditOutcomeDenied
+	default:
+		return AuditOutcomeError
+	}
+}
+
+// auditHelper is a Helper that records the server URL and username of
+// the action it executes in an audit record. The server URL requested by
+// get and erase is recorded by handleAudited, and the server URL of the
+// credentials they act on as the resolved server URL.
+type auditHelper struct {
+	helper Helper
+	rec    *AuditRecord
+}
+
+func (h auditHelper) Add(creds *Credentials) error {
+	if creds != nil {
+		h.rec.ServerURL, h.rec.Username = creds.ServerURL, creds.Username
+	}
+	return h.helper.Add(creds)
+}
+
+func (h auditHelper) Delete(serverURL string) error {
+	h.rec.ResolvedServerURL = serverURL
+	return h.helper.Delete(serverURL)
+}
+
+func (h auditHelper) Get(serverURL string) (string, string, error) {
+	h.rec.ResolvedServerURL = serverURL
+	username, secret, err := h.helper.Get(serverURL)
+	h.rec.Username = username
+	return username, secret, err
+}
+
+func (h auditHelper) List() (map[string]string, error) {
+	return h.helper.List()
+}
+
+func (h auditHelper) ListAll() (map[string]string, error) {
+	return listAll(h.helper)
+}
+
+// handleAudited runs an action requested by caller and records it in the
+// audit log. Output is only written to out once the record has been
+// written, so that no secret is handed out without being audited.
+func handleAudited(l *AuditLog, helper, lookup Helper, action Action, caller *Caller, in io.Reader, out io.Writer) error {
+	rec := &AuditRecord{
+		Time:   time.Now().UTC(),
+		Helper: Name,
+		Action: action,
+	}
+	rec.SetCaller(caller)
+
+	if action == ActionGet || action == ActionErase {
+		input, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		if r, err := parseRequest(strings.TrimSpace(string(input))); err == nil {
+			rec.ServerURL, rec.Username = r.ServerURL, r.Username
+		}
+		in = bytes.NewReader(input)
+	}
+
+	buf := new(bytes.Buffer)
+	err := handleCommand(auditHelper{helper: helper, rec: rec}, lookup, action, in, buf)
+	if rec.ResolvedServerURL == rec.ServerURL {
+		rec.ResolvedServerURL = ""
+	}
+
+	rec.Outcome = auditOutcome(err)
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if auditErr := l.Append(*rec); auditErr != nil {
+		return errors.Join(err, fmt.Errorf("writing audit log: %w", auditErr))
+	}
+	if _, writeErr := buf.WriteTo(out); writeErr != nil && err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+// DefaultAuditLog returns the audit log set in the
+// DOCKER_CREDENTIAL_HELPERS_AUDIT_LOG environment variable, or nil if it
+// is not set.
+func DefaultAuditLog() *AuditLog {
+	if path := os.Getenv(envAuditLog); path != "" {
+		return NewAuditLog(path)
+	}
+	return nil
+}
+
+// verifyAuditLogCommand verifies the audit log set in the environment, or
+// the one given as argument, and reports the result to out.
+func verifyAuditLogCommand(args []string, out io.Writer) error {
+	path := os.Getenv(envAuditLog)
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		return fmt.Errorf("no audit log: set %s or pass the path of the audit log", envAuditLog)
+	}
+	n, err := VerifyAuditLog(path)
+	if err != nil {
+		return fmt.Errorf("audit log %s failed verification: %w", path, err)
+	}
+	_, _ = fmt.Fprintf(out, "audit log %s: %d records verified\n", path, n)
+	return nil
+}
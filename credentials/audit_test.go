@@ -0,0 +1,217 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeAuditLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	SetAuditLog(NewAuditLog(path))
+	t.Cleanup(func() { SetAuditLog(nil) })
+
+	h := newMemoryStore()
+	b, err := json.Marshal(Credentials{ServerURL: "https://registry.example.com", Username: "foo", Secret: "topsecret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := HandleCommand(h, ActionStore, bytes.NewReader(b), new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err := HandleCommand(h, ActionGet, strings.NewReader("https://registry.example.com"), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "topsecret") {
+		t.Errorf("expected secret in output, got %s", out.String())
+	}
+	if err := HandleCommand(h, ActionGet, strings.NewReader("https://missing.example.com"), new(bytes.Buffer)); !IsErrCredentialsNotFound(err) {
+		t.Fatalf("expected credentials not found, got %v", err)
+	}
+	if err := HandleCommand(h, ActionList, nil, new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec AuditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestAuditLog(t *testing.T) {
+	path := writeAuditLog(t)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("topsecret")) {
+		t.Error("expected audit log not to contain secrets")
+	}
+
+	recs := readAuditRecords(t, path)
+	expected := []struct {
+		action   Action
+		username string
+		outcome  string
+	}{
+		{action: ActionStore, username: "foo", outcome: AuditOutcomeSuccess},
+		{action: ActionGet, username: "foo", outcome: AuditOutcomeSuccess},
+		{action: ActionGet, outcome: AuditOutcomeNotFound},
+		{action: ActionList, outcome: AuditOutcomeSuccess},
+	}
+	if len(recs) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(recs))
+	}
+	for i, e := range expected {
+		if recs[i].Action != e.action || recs[i].Username != e.username || recs[i].Outcome != e.outcome {
+			t.Errorf("record %d: expected %s/%q/%s, got %s/%q/%s", i+1, e.action, e.username, e.outcome, recs[i].Action, recs[i].Username, recs[i].Outcome)
+		}
+		if recs[i].PID != os.Getppid() {
+			t.Errorf("record %d: expected pid %d, got %d", i+1, os.Getppid(), recs[i].PID)
+		}
+	}
+
+	n, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != uint64(len(expected)) {
+		t.Errorf("expected %d verified records, got %d", len(expected), n)
+	}
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{
+			name: "edit",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"username":"foo"`, `"username":"bar"`, 1)
+				return lines
+			},
+		},
+		{
+			name: "remove first",
+			tamper: func(lines []string) []string {
+				return lines[1:]
+			},
+		},
+		{
+			name: "remove middle",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+		},
+		{
+			name: "truncate",
+			tamper: func(lines []string) []string {
+				return lines[:len(lines)-1]
+			},
+		},
+		{
+			name: "reorder",
+			tamper: func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeAuditLog(t)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tc.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := VerifyAuditLog(path); err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestAuditLogDenied(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	SetAuditLog(NewAuditLog(path))
+	t.Cleanup(func() { SetAuditLog(nil) })
+
+	err := HandleCommand(ReadOnly(newMemoryStore()), ActionErase, strings.NewReader("https://registry.example.com"), new(bytes.Buffer))
+	if !IsErrReadOnly(err) {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	recs := readAuditRecords(t, path)
+	if len(recs) != 1 || recs[0].Outcome != AuditOutcomeDenied || recs[0].ServerURL != "https://registry.example.com" {
+		t.Errorf("expected denied erase to be audited, got %+v", recs)
+	}
+}
+
+func TestAuditLogResolved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	SetAuditLog(NewAuditLog(path))
+	t.Cleanup(func() { SetAuditLog(nil) })
+	f := setFakeNotifications(t)
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"notify": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	h := newAccountStore()
+	_ = h.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "rw"})
+	_ = h.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: withAccess("ro", AccessPull)})
+
+	out := new(bytes.Buffer)
+	for _, input := range []string{
+		`{"ServerURL": "https://ghcr.io/org/app", "Username": "robot"}`,
+		`{"ServerURL": "https://ghcr.io/org/app", "Access": "pull"}`,
+	} {
+		out.Reset()
+		if err := HandleCommand(h, ActionGet, strings.NewReader(input), out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), `"Secret":"ro"`) {
+			t.Errorf("expected the credentials of robot for %s, got %s", input, out)
+		}
+	}
+
+	if n := len(f.received()); n != 2 {
+		t.Errorf("expected a notification per get, got %d", n)
+	}
+	recs := readAuditRecords(t, path)
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+	for _, rec := range recs {
+		if rec.ServerURL != "https://ghcr.io/org/app" || rec.ResolvedServerURL != "https://robot@ghcr.io" || rec.Username != "robot" {
+			t.Errorf("expected requested and resolved server URLs to be audited, got %+v", rec)
+		}
+	}
+}
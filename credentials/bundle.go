@@ -0,0 +1,184 @@
+package credentials
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// BundleVersion is the version of the bundles written by ExportBundle.
+const BundleVersion = 1
+
+// Bundle holds all the credentials of a helper, to restore them into
+// another helper. Bundles are only ever written to disk encrypted, see
+// SealBundle.
+type Bundle struct {
+	Version     int           `json:"version"`
+	Helper      string        `json:"helper,omitempty"`
+	Label       string        `json:"label,omitempty"`
+	Created     time.Time     `json:"created"`
+	Credentials []Credentials `json:"credentials"`
+}
+
+// ExportBundle reads all the credentials from helper into a bundle.
+func ExportBundle(helper Helper) (*Bundle, error) {
+	accts, err := listAll(helper)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{
+		Version:     BundleVersion,
+		Helper:      Name,
+		Label:       CredsLabel,
+		Created:     time.Now().UTC(),
+		Credentials: make([]Credentials, 0, len(accts)),
+	}
+	for serverURL := range accts {
+		username, secret, err := helper.Get(serverURL)
+		if err != nil {
+			// The credentials may have been removed in the meantime.
+			if IsErrCredentialsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("exporting credentials for %s: %w", serverURL, err)
+		}
+		creds := Credentials{ServerURL: serverURL, Username: username}
+		creds.Secret, creds.Access = splitAccess(secret)
+		b.Credentials = append(b.Credentials, creds)
+	}
+	sort.Slice(b.Credentials, func(i, j int) bool {
+		return b.Credentials[i].ServerURL < b.Credentials[j].ServerURL
+	})
+	return b, nil
+}
+
+// ConflictMode defines how ImportBundle handles credentials that are
+// already in the helper.
+type ConflictMode = string
+
+// List of conflict modes supported by ImportBundle.
+const (
+	// ConflictMerge adds the credentials of the bundle and replaces the
+	// conflicting ones, keeping the credentials not in the bundle.
+	ConflictMerge ConflictMode = "merge"
+	// ConflictOverwrite makes the helper hold exactly the credentials of the
+	// bundle, removing the credentials not in the bundle.
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictSkip only adds the credentials of the bundle that are not in
+	// the helper, keeping the conflicting ones.
+	ConflictSkip ConflictMode = "skip"
+)
+
+// Operations of an ImportChange.
+const (
+	ImportAdd       = "add"
+	ImportUpdate    = "update"
+	ImportRemove    = "remove"
+	ImportSkip      = "skip"
+	ImportUnchanged = "unchanged"
+)
+
+// ImportChange is a change made, or to be made, to a helper by ImportBundle.
+type ImportChange struct {
+	Op        string
+	ServerURL string
+	Username  string
+	// OldUsername is the username of the credentials replaced or kept, if
+	// any.
+	OldUsername string
+}
+
+func (c ImportChange) String() string {
+	switch c.Op {
+	case ImportAdd:
+		return fmt.Sprintf("+ %s (%s)", c.ServerURL, c.Username)
+	case ImportUpdate:
+		if c.OldUsername != c.Username {
+			return fmt.Sprintf("~ %s (%s -> %s)", c.ServerURL, c.OldUsername, c.Username)
+		}
+		return fmt.Sprintf("~ %s (%s, new secret)", c.ServerURL, c.Username)
+	case ImportRemove:
+		return fmt.Sprintf("- %s (%s)", c.ServerURL, c.OldUsername)
+	case ImportSkip:
+		return fmt.Sprintf("! %s (keeping %s)", c.ServerURL, c.OldUsername)
+	default:
+		return fmt.Sprintf("= %s (%s)", c.ServerURL, c.Username)
+	}
+}
+
+// ImportBundle restores the credentials of a bundle into helper, handling
+// the credentials already in helper according to mode. It returns the
+// changes, sorted by server URL. If dryRun is true, the changes are only
+// computed, and helper is left untouched.
+func ImportBundle(helper Helper, b *Bundle, mode ConflictMode, dryRun bool) ([]ImportChange, error) {
+	switch mode {
+	case ConflictMerge, ConflictOverwrite, ConflictSkip:
+	default:
+		return nil, fmt.Errorf("unknown conflict mode: %s", mode)
+	}
+	if b.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	existing, err := listAll(helper)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []ImportChange
+	inBundle := make(map[string]bool, len(b.Credentials))
+	for _, creds := range b.Credentials {
+		creds := creds
+		if ok, err := creds.isValid(); !ok {
+			return nil, err
+		}
+		if err := validateAccess(creds.Access); err != nil {
+			return nil, err
+		}
+		creds.Secret, creds.Access = withAccess(creds.Secret, creds.Access), ""
+		inBundle[creds.ServerURL] = true
+
+		change := ImportChange{Op: ImportAdd, ServerURL: creds.ServerURL, Username: creds.Username}
+		if oldUsername, ok := existing[creds.ServerURL]; ok {
+			change.OldUsername = oldUsername
+			_, oldSecret, err := helper.Get(creds.ServerURL)
+			if err != nil && !IsErrCredentialsNotFound(err) {
+				return nil, err
+			}
+			switch {
+			case err != nil:
+			case oldUsername == creds.Username && oldSecret == creds.Secret:
+				change.Op = ImportUnchanged
+			case mode == ConflictSkip:
+				change.Op = ImportSkip
+			default:
+				change.Op = ImportUpdate
+			}
+		}
+		if !dryRun && (change.Op == ImportAdd || change.Op == ImportUpdate) {
+			if err := helper.Add(&creds); err != nil {
+				return changes, fmt.Errorf("importing credentials for %s: %w", creds.ServerURL, err)
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	if mode == ConflictOverwrite {
+		for serverURL, username := range existing {
+			if inBundle[serverURL] {
+				continue
+			}
+			if !dryRun {
+				if err := helper.Delete(serverURL); err != nil && !IsErrCredentialsNotFound(err) {
+					return changes, fmt.Errorf("removing credentials for %s: %w", serverURL, err)
+				}
+			}
+			changes = append(changes, ImportChange{Op: ImportRemove, ServerURL: serverURL, OldUsername: username})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ServerURL < changes[j].ServerURL
+	})
+	return changes, nil
+}
@@ -0,0 +1,177 @@
+package credentials
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportBundle(t *testing.T) {
+	h := newMemoryStore()
+	for _, creds := range testBundle().Credentials {
+		creds := creds
+		_ = h.Add(&creds)
+	}
+
+	b, err := ExportBundle(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != BundleVersion || b.Label != CredsLabel {
+		t.Errorf("unexpected bundle %+v", b)
+	}
+	if expected := testBundle().Credentials; !reflect.DeepEqual(b.Credentials, expected) {
+		t.Errorf("expected %+v, got %+v", expected, b.Credentials)
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	b := &Bundle{
+		Version: BundleVersion,
+		Credentials: []Credentials{
+			{ServerURL: "new.example.com", Username: "new", Secret: "secret"},
+			{ServerURL: "same.example.com", Username: "same", Secret: "secret"},
+			{ServerURL: "user.example.com", Username: "bundle", Secret: "secret"},
+			{ServerURL: "secret.example.com", Username: "secret", Secret: "bundle"},
+		},
+	}
+	newStore := func() *memoryStore {
+		h := newMemoryStore()
+		_ = h.Add(&Credentials{ServerURL: "same.example.com", Username: "same", Secret: "secret"})
+		_ = h.Add(&Credentials{ServerURL: "user.example.com", Username: "store", Secret: "secret"})
+		_ = h.Add(&Credentials{ServerURL: "secret.example.com", Username: "secret", Secret: "store"})
+		_ = h.Add(&Credentials{ServerURL: "old.example.com", Username: "old", Secret: "secret"})
+		return h
+	}
+
+	tests := []struct {
+		mode     ConflictMode
+		changes  []string
+		expected map[string]string
+	}{
+		{
+			mode: ConflictMerge,
+			changes: []string{
+				"+ new.example.com (new)",
+				"= same.example.com (same)",
+				"~ secret.example.com (secret, new secret)",
+				"~ user.example.com (store -> bundle)",
+			},
+			expected: map[string]string{
+				"new.example.com":    "new:secret",
+				"same.example.com":   "same:secret",
+				"user.example.com":   "bundle:secret",
+				"secret.example.com": "secret:bundle",
+				"old.example.com":    "old:secret",
+			},
+		},
+		{
+			mode: ConflictOverwrite,
+			changes: []string{
+				"+ new.example.com (new)",
+				"- old.example.com (old)",
+				"= same.example.com (same)",
+				"~ secret.example.com (secret, new secret)",
+				"~ user.example.com (store -> bundle)",
+			},
+			expected: map[string]string{
+				"new.example.com":    "new:secret",
+				"same.example.com":   "same:secret",
+				"user.example.com":   "bundle:secret",
+				"secret.example.com": "secret:bundle",
+			},
+		},
+		{
+			mode: ConflictSkip,
+			changes: []string{
+				"+ new.example.com (new)",
+				"= same.example.com (same)",
+				"! secret.example.com (keeping secret)",
+				"! user.example.com (keeping store)",
+			},
+			expected: map[string]string{
+				"new.example.com":    "new:secret",
+				"same.example.com":   "same:secret",
+				"user.example.com":   "store:secret",
+				"secret.example.com": "secret:store",
+				"old.example.com":    "old:secret",
+			},
+		},
+	}
+	for _, tc := range tests {
+		for _, dryRun := range []bool{true, false} {
+			h := newStore()
+			before := storeContents(t, h)
+			changes, err := ImportBundle(h, b, tc.mode, dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, change := range changes {
+				actual = append(actual, change.String())
+			}
+			if !reflect.DeepEqual(actual, tc.changes) {
+				t.Errorf("%s: expected changes %q, got %q", tc.mode, tc.changes, actual)
+			}
+
+			expected := tc.expected
+			if dryRun {
+				expected = before
+			}
+			if contents := storeContents(t, h); !reflect.DeepEqual(contents, expected) {
+				t.Errorf("%s (dry run: %t): expected %v, got %v", tc.mode, dryRun, expected, contents)
+			}
+		}
+	}
+
+	if _, err := ImportBundle(newStore(), b, "replace", false); err == nil {
+		t.Error("expected error with unknown mode")
+	}
+	if _, err := ImportBundle(newStore(), &Bundle{Version: BundleVersion + 1}, ConflictMerge, false); err == nil {
+		t.Error("expected error with unsupported version")
+	}
+	invalid := &Bundle{Version: BundleVersion, Credentials: []Credentials{{ServerURL: "registry.example.com"}}}
+	if _, err := ImportBundle(newStore(), invalid, ConflictMerge, false); !IsCredentialsMissingUsername(err) {
+		t.Errorf("expected missing username, got %v", err)
+	}
+}
+
+// storeContents returns the username and secret of all the credentials in
+// h, keyed by server URL.
+func storeContents(t *testing.T, h Helper) map[string]string {
+	t.Helper()
+	accts, err := h.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string, len(accts))
+	for serverURL := range accts {
+		username, secret, err := h.Get(serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[serverURL] = username + ":" + secret
+	}
+	return contents
+}
+
+func TestBundleAccess(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "reader", Secret: withAccess("secret", AccessPull)})
+	b, err := ExportBundle(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Credentials{{ServerURL: "ghcr.io", Username: "reader", Secret: "secret", Access: AccessPull}}
+	if !reflect.DeepEqual(b.Credentials, expected) {
+		t.Errorf("expected %+v, got %+v", expected, b.Credentials)
+	}
+
+	imported := newMemoryStore()
+	if _, err := ImportBundle(imported, b, ConflictOverwrite, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, secret, err := imported.Get("ghcr.io"); err != nil || !strings.HasPrefix(secret, accessSecretPrefix) {
+		t.Errorf("expected pull-only secret, got %s, %v", secret, err)
+	}
+}
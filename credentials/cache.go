@@ -0,0 +1,314 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/internal/fsutil"
+	"github.com/docker/docker-credential-helpers/registryurl"
+)
+
+// Environment variables to enable the on-disk cache in Serve.
+const (
+	envCacheKey         = "DOCKER_CREDENTIAL_HELPERS_CACHE_KEY"
+	envCacheFile        = "DOCKER_CREDENTIAL_HELPERS_CACHE_FILE"
+	envCacheTTL         = "DOCKER_CREDENTIAL_HELPERS_CACHE_TTL"
+	envCacheNegativeTTL = "DOCKER_CREDENTIAL_HELPERS_CACHE_NEGATIVE_TTL"
+
+	defaultCacheTTL = 5 * time.Minute
+)
+
+// cacheFileHeader is authenticated along with the contents of the cache
+// file, so a file written by an incompatible version is discarded.
+var cacheFileHeader = []byte("docker-credential-helpers cache v1\n")
+
+// CacheOptions configures a [Cache].
+type CacheOptions struct {
+	// TTL is how long credentials returned by the helper are cached.
+	TTL time.Duration
+
+	// NegativeTTL is how long "credentials not found" results are cached.
+	// Not found results are not cached if it is zero.
+	NegativeTTL time.Duration
+
+	// File, if set, is where the cache is persisted, so it can be shared
+	// by short-lived processes. The file is encrypted with Key.
+	File string
+
+	// Key is the AES key used to encrypt File. It must be 16, 24 or 32
+	// bytes long.
+	Key []byte
+}
+
+type cacheEntry struct {
+	Username string    `json:"username,omitempty"`
+	Secret   string    `json:"secret,omitempty"`
+	NotFound bool      `json:"notFound,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Cache is a Helper that caches the results of Get of another helper.
+// Cached entries are invalidated when credentials for the same registry
+// host are stored or erased through the cache, as they may have been
+// returned for the account, namespace or alias of the server URL of the
+// credentials. List is never cached.
+//
+// Entries are cached for the TTL, whatever the lifetime of the secrets, so
+// a Cache should wrap the helper storing OAuth2 tokens rather than OAuth2,
+// which then renews the cached tokens when their access tokens expire.
+type Cache struct {
+	helper Helper
+	opts   CacheOptions
+	aead   cipher.AEAD
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewCache wraps helper with a cache.
+func NewCache(helper Helper, opts CacheOptions) (*Cache, error) {
+	c := &Cache{
+		helper:  helper,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]cacheEntry),
+	}
+	if opts.File != "" {
+		block, err := aes.NewCipher(opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache key: %w", err)
+		}
+		c.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Add stores credentials in the wrapped helper and invalidates the cached
+// entries for the registry host of the server URL.
+func (c *Cache) Add(creds *Credentials) error {
+	if creds == nil {
+		return errors.New("missing credentials")
+	}
+	if err := c.Invalidate(creds.ServerURL); err != nil {
+		return err
+	}
+	return c.helper.Add(creds)
+}
+
+// Delete removes credentials from the wrapped helper and invalidates the
+// cached entries for the registry host of the server URL.
+func (c *Cache) Delete(serverURL string) error {
+	if err := c.Invalidate(serverURL); err != nil {
+		return err
+	}
+	return c.helper.Delete(serverURL)
+}
+
+// Get returns cached credentials for the server URL if they have not
+// expired, and otherwise retrieves and caches them from the wrapped helper.
+func (c *Cache) Get(serverURL string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return "", "", err
+	}
+	if e, ok := c.entries[serverURL]; ok && c.now().Before(e.Expires) {
+		Logger().Debug("cache hit", "serverURL", serverURL, "notFound", e.NotFound)
+		if e.NotFound {
+			return "", "", NewErrCredentialsNotFound()
+		}
+		return e.Username, e.Secret, nil
+	}
+
+	Logger().Debug("cache miss", "serverURL", serverURL)
+	username, secret, err := c.helper.Get(serverURL)
+	switch {
+	case err == nil && c.opts.TTL > 0:
+		c.entries[serverURL] = cacheEntry{
+			Username: username,
+			Secret:   secret,
+			Expires:  c.now().Add(c.opts.TTL),
+		}
+	case IsErrCredentialsNotFound(err) && c.opts.NegativeTTL > 0:
+		c.entries[serverURL] = cacheEntry{
+			NotFound: true,
+			Expires:  c.now().Add(c.opts.NegativeTTL),
+		}
+	default:
+		return username, secret, err
+	}
+	if saveErr := c.save(); saveErr != nil {
+		return "", "", saveErr
+	}
+	return username, secret, err
+}
+
+// List returns the credentials of the wrapped helper.
+func (c *Cache) List() (map[string]string, error) {
+	return c.helper.List()
+}
+
+// ListAll returns all the accounts of the wrapped helper, see
+// MultiAccountHelper.
+func (c *Cache) ListAll() (map[string]string, error) {
+	return listAll(c.helper)
+}
+
+// Invalidate removes the cached entries for the registry host of the
+// server URL, whatever their scheme, account or path.
+func (c *Cache) Invalidate(serverURL string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.load(); err != nil {
+		return err
+	}
+	host := cacheHost(serverURL)
+	var invalidated bool
+	for key := range c.entries {
+		if cacheHost(key) == host {
+			delete(c.entries, key)
+			invalidated = true
+		}
+	}
+	if !invalidated {
+		return nil
+	}
+	return c.save()
+}
+
+// cacheHost returns the registry host, with its port, entries cached for
+// serverURL are invalidated by, or serverURL if it cannot be parsed.
+func cacheHost(serverURL string) string {
+	serverURL, _ = SplitAccountServerURL(serverURL)
+	u, err := registryurl.Parse(serverURL)
+	if err != nil {
+		return serverURL
+	}
+	return strings.ToLower(u.Host)
+}
+
+// Flush removes all cached entries.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cacheEntry)
+	if c.opts.File == "" {
+		return nil
+	}
+	if err := os.Remove(c.opts.File); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// load replaces the in-memory entries with those of the cache file, if
+// any. A cache file that cannot be decrypted is ignored.
+func (c *Cache) load() error {
+	if c.opts.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.opts.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.entries = make(map[string]cacheEntry)
+			return nil
+		}
+		return err
+	}
+
+	entries := make(map[string]cacheEntry)
+	nonceSize := c.aead.NonceSize()
+	if len(data) > nonceSize {
+		plaintext, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], cacheFileHeader)
+		if err == nil {
+			_ = json.Unmarshal(plaintext, &entries)
+		}
+	}
+	c.entries = entries
+	return nil
+}
+
+// save drops expired entries and writes the remaining ones to the cache
+// file, if any.
+func (c *Cache) save() error {
+	now := c.now()
+	for serverURL, e := range c.entries {
+		if !now.Before(e.Expires) {
+			delete(c.entries, serverURL)
+		}
+	}
+	if c.opts.File == "" {
+		return nil
+	}
+
+	plaintext, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := c.aead.Seal(nonce, nonce, plaintext, cacheFileHeader)
+
+	if err := os.MkdirAll(filepath.Dir(c.opts.File), 0o700); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(c.opts.File, data)
+}
+
+// cacheFromEnv wraps helper with an on-disk cache if a cache key is set in
+// the environment. The key is a base64-encoded AES key, which is typically
+// generated once per build session.
+func cacheFromEnv(helper Helper) (Helper, error) {
+	encodedKey := os.Getenv(envCacheKey)
+	if encodedKey == "" {
+		return helper, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envCacheKey, err)
+	}
+
+	opts := CacheOptions{TTL: defaultCacheTTL, Key: key, File: os.Getenv(envCacheFile)}
+	if v := os.Getenv(envCacheTTL); v != "" {
+		if opts.TTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envCacheTTL, err)
+		}
+	}
+	if v := os.Getenv(envCacheNegativeTTL); v != "" {
+		if opts.NegativeTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envCacheNegativeTTL, err)
+		}
+	}
+	if opts.File == "" {
+		dir, err := fsutil.RuntimeDir()
+		if err != nil {
+			return nil, err
+		}
+		name := Name
+		if name == "" {
+			name = "docker-credential-helper"
+		}
+		opts.File = filepath.Join(dir, name+".cache")
+	}
+	return NewCache(helper, opts)
+}
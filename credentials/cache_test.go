@@ -0,0 +1,171 @@
+package credentials
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingStore is a memoryStore that counts calls to Get.
+type countingStore struct {
+	*memoryStore
+	gets int
+}
+
+func (c *countingStore) Get(serverURL string) (string, string, error) {
+	c.gets++
+	return c.memoryStore.Get(serverURL)
+}
+
+func TestCache(t *testing.T) {
+	const serverURL = "https://registry.example.com"
+	store := &countingStore{memoryStore: newMemoryStore()}
+	_ = store.memoryStore.Add(&Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"})
+
+	c, err := NewCache(store, CacheOptions{TTL: time.Minute, NegativeTTL: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		username, secret, err := c.Get(serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "foo" || secret != "bar" {
+			t.Errorf("expected foo:bar, got %s:%s", username, secret)
+		}
+	}
+	if store.gets != 1 {
+		t.Errorf("expected 1 lookup in wrapped helper, got %d", store.gets)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.Get("https://missing.example.com"); !IsErrCredentialsNotFound(err) {
+			t.Errorf("expected credentials not found, got %v", err)
+		}
+	}
+	if store.gets != 2 {
+		t.Errorf("expected 2 lookups in wrapped helper, got %d", store.gets)
+	}
+
+	now = now.Add(2 * time.Second)
+	_, _, _ = c.Get("https://missing.example.com")
+	_, _, _ = c.Get(serverURL)
+	if store.gets != 3 {
+		t.Errorf("expected negative entry to expire before positive entry, got %d lookups", store.gets)
+	}
+
+	now = now.Add(time.Minute)
+	_, _, _ = c.Get(serverURL)
+	if store.gets != 4 {
+		t.Errorf("expected positive entry to expire, got %d lookups", store.gets)
+	}
+
+	if err := c.Add(&Credentials{ServerURL: serverURL, Username: "foo", Secret: "baz"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, secret, _ := c.Get(serverURL); secret != "baz" {
+		t.Errorf("expected cached entry to be invalidated on store, got secret %s", secret)
+	}
+
+	if err := c.Delete(serverURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(serverURL); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected cached entry to be invalidated on erase, got %v", err)
+	}
+}
+
+func TestCacheInvalidateHost(t *testing.T) {
+	store := newAccountStore()
+	_ = store.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "jane", Secret: "jane-secret"})
+	_ = store.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = store.Add(&Credentials{ServerURL: "quay.io", Username: "jane", Secret: "quay-secret"})
+
+	c, err := NewCache(store, CacheOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, serverURL := range []string{"https://ghcr.io", "https://robot@ghcr.io", "ghcr.io/org/app", "quay.io"} {
+		_, _, _ = c.Get(serverURL)
+	}
+
+	// Entries cached for the accounts, namespaces and aliases of a
+	// registry are invalidated along with its server URL.
+	if err := c.Add(&Credentials{ServerURL: "https://ghcr.io", Username: "robot", Secret: "new-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("expected only the quay.io entry to be left, got %v", c.entries)
+	}
+	if _, secret, err := c.Get("https://robot@ghcr.io"); err != nil || secret != "new-secret" {
+		t.Errorf("expected new secret, got %q, %v", secret, err)
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	const serverURL = "https://registry.example.com"
+	file := filepath.Join(t.TempDir(), "helper.cache")
+	key := bytes.Repeat([]byte{1}, 32)
+
+	store := &countingStore{memoryStore: newMemoryStore()}
+	_ = store.memoryStore.Add(&Credentials{ServerURL: serverURL, Username: "foo", Secret: "topsecret"})
+
+	first, err := NewCache(store, CacheOptions{TTL: time.Minute, File: file, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := first.Get(serverURL); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("topsecret")) {
+		t.Error("expected cache file to be encrypted")
+	}
+
+	// A second process sharing the cache file does not hit the helper.
+	second, err := NewCache(store, CacheOptions{TTL: time.Minute, File: file, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, secret, err := second.Get(serverURL); err != nil || secret != "topsecret" {
+		t.Errorf("expected cached secret, got %q, %v", secret, err)
+	}
+	if store.gets != 1 {
+		t.Errorf("expected 1 lookup in wrapped helper, got %d", store.gets)
+	}
+
+	// A cache file encrypted with another key is ignored.
+	other, err := NewCache(store, CacheOptions{TTL: time.Minute, File: file, Key: bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, secret, err := other.Get(serverURL); err != nil || secret != "topsecret" {
+		t.Errorf("expected secret from helper, got %q, %v", secret, err)
+	}
+	if store.gets != 2 {
+		t.Errorf("expected 2 lookups in wrapped helper, got %d", store.gets)
+	}
+
+	if err := other.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expected cache file to be removed, got %v", err)
+	}
+}
+
+func TestNewCacheInvalidKey(t *testing.T) {
+	if _, err := NewCache(newMemoryStore(), CacheOptions{File: "unused", Key: []byte("short")}); err == nil {
+		t.Error("expected error for invalid key, got nil")
+	}
+}
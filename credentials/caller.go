@@ -0,0 +1,75 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Caller identifies the process that requested an action.
+type Caller struct {
+	// PID is the process ID of the caller.
+	PID int
+	// PPID is the process ID of the caller's parent, or 0 if unknown.
+	PPID int
+	// UID is the user ID the caller runs as, or -1 if unknown.
+	UID int
+	// Executable is the path of the caller's executable, if known.
+	Executable string
+	// Cgroup is the path of the caller's (unified hierarchy) cgroup, if
+	// known.
+	Cgroup string
+}
+
+// CallerFromPID resolves the parent, executable, cgroup and user of the
+// process with the given pid. They are only resolved on Linux, using /proc; on
+// other platforms, only the PID is set.
+func CallerFromPID(pid int) Caller {
+	c := Caller{PID: pid, UID: -1}
+	resolveCaller(&c)
+	return c
+}
+
+// parentCaller returns the caller of a helper, which is its parent
+// process, as helpers are executed by the program needing credentials.
+func parentCaller() *Caller {
+	c := CallerFromPID(os.Getppid())
+	return &c
+}
+
+// describeCaller returns a description of the caller for messages.
+func describeCaller(caller *Caller) string {
+	switch {
+	case caller == nil:
+		return "unknown caller"
+	case caller.Executable == "":
+		return fmt.Sprintf("process %d", caller.PID)
+	default:
+		return fmt.Sprintf("process %d (%s)", caller.PID, caller.Executable)
+	}
+}
+
+// matchExecutable reports whether executable matches pattern, an absolute
+// path.
+func matchExecutable(pattern, executable string) bool {
+	if executable == "" {
+		return false
+	}
+	ok, _ := filepath.Match(pattern, executable)
+	return ok
+}
+
+// matchCgroup reports whether cgroup matches pattern, or is below a cgroup
+// matching pattern.
+func matchCgroup(pattern, cgroup string) bool {
+	if cgroup == "" {
+		return false
+	}
+	for c := cgroup; c != "/" && c != "."; c = path.Dir(c) {
+		if ok, _ := path.Match(pattern, c); ok {
+			return true
+		}
+	}
+	return false
+}
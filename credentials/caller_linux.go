@@ -0,0 +1,73 @@
+package credentials
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// resolveCaller fills in the details of the caller from /proc.
+func resolveCaller(c *Caller) {
+	procDir := "/proc/" + strconv.Itoa(c.PID)
+
+	if info, err := os.Stat(procDir); err == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			c.UID = int(st.Uid)
+		}
+	}
+	c.Executable, _ = os.Readlink(procDir + "/exe")
+	c.Cgroup = readCgroup(procDir + "/cgroup")
+	c.PPID = readPPID(procDir + "/stat")
+}
+
+// readPPID returns the parent process ID from a /proc/<pid>/stat file, or
+// 0 if it cannot be read.
+func readPPID(file string) int {
+	stat, err := os.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	// The command name in the second field is enclosed in parentheses, and
+	// may contain spaces and parentheses itself. The parent process ID is
+	// the second field after it.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// readCgroup returns the cgroup of the unified (v2) hierarchy from a
+// /proc/<pid>/cgroup file, or that of the first hierarchy if there is no
+// unified hierarchy.
+func readCgroup(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var first string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are formatted as "hierarchy-ID:controller-list:cgroup-path".
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			return fields[2]
+		}
+		if first == "" {
+			first = fields[2]
+		}
+	}
+	return first
+}
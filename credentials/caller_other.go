@@ -0,0 +1,7 @@
+//go:build !linux
+
+package credentials
+
+// resolveCaller fills in the details of the caller. It is only implemented
+// on Linux.
+func resolveCaller(*Caller) {}
@@ -0,0 +1,66 @@
+package credentials
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestMatchExecutable(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		executable string
+		match      bool
+	}{
+		{pattern: "/usr/bin/docker", executable: "/usr/bin/docker", match: true},
+		{pattern: "/usr/bin/docker", executable: "/tmp/docker", match: false},
+		{pattern: "/usr/local/bin/buildctl", executable: "/usr/local/bin/buildctl", match: true},
+		{pattern: "/usr/local/bin/buildctl", executable: "/tmp/x/buildctl", match: false},
+		{pattern: "/usr/libexec/docker/cli-plugins/*", executable: "/usr/libexec/docker/cli-plugins/docker-buildx", match: true},
+		{pattern: "/usr/bin/docker", executable: "", match: false},
+	}
+	for _, tc := range tests {
+		if actual := matchExecutable(tc.pattern, tc.executable); actual != tc.match {
+			t.Errorf("expected %q to match %q: %t, got %t", tc.pattern, tc.executable, tc.match, actual)
+		}
+	}
+}
+
+func TestMatchCgroup(t *testing.T) {
+	tests := []struct {
+		pattern string
+		cgroup  string
+		match   bool
+	}{
+		{pattern: "/user.slice", cgroup: "/user.slice/user-1000.slice/session-2.scope", match: true},
+		{pattern: "/user.slice/*", cgroup: "/user.slice/user-1000.slice/session-2.scope", match: true},
+		{pattern: "/system.slice/docker.service", cgroup: "/system.slice/docker.service", match: true},
+		{pattern: "/system.slice/docker.service", cgroup: "/system.slice/containerd.service", match: false},
+		{pattern: "/user.slice", cgroup: "", match: false},
+	}
+	for _, tc := range tests {
+		if actual := matchCgroup(tc.pattern, tc.cgroup); actual != tc.match {
+			t.Errorf("expected %q to match %q: %t, got %t", tc.pattern, tc.cgroup, tc.match, actual)
+		}
+	}
+}
+
+func TestCallerFromPID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("callers are only resolved on Linux")
+	}
+	c := CallerFromPID(os.Getpid())
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Executable != exe {
+		t.Errorf("expected executable %s, got %s", exe, c.Executable)
+	}
+	if c.PPID != os.Getppid() {
+		t.Errorf("expected parent %d, got %d", os.Getppid(), c.PPID)
+	}
+	if c.UID != os.Getuid() {
+		t.Errorf("expected uid %d, got %d", os.Getuid(), c.UID)
+	}
+}
@@ -0,0 +1,98 @@
+package credentials
+
+// Chain is a Helper that looks up credentials in several helpers in turn,
+// for example a read-only source of mounted secrets in front of the user's
+// keychain. Credentials are stored in, and erased from, the primary helper
+// only.
+type Chain struct {
+	// Helpers are queried in order by Get; the first helper that has
+	// credentials for a server URL wins. List merges the credentials of
+	// all helpers, with earlier helpers taking precedence.
+	Helpers []Helper
+
+	// Primary is the helper used by Add and Delete. It does not need to be
+	// part of Helpers. If Primary is nil, the chain is read-only, and Add
+	// and Delete fail with an error for which IsErrReadOnly returns true.
+	Primary Helper
+
+	// ContinueOnError makes Get and List carry on with the next helper when
+	// a helper fails with an error other than "credentials not found". By
+	// default, such errors are returned immediately.
+	ContinueOnError bool
+}
+
+// Add stores credentials in the primary helper.
+func (c Chain) Add(creds *Credentials) error {
+	if c.Primary == nil {
+		return NewErrReadOnly()
+	}
+	return c.Primary.Add(creds)
+}
+
+// Delete removes credentials from the primary helper. Credentials for the
+// same server URL in other helpers are left untouched.
+func (c Chain) Delete(serverURL string) error {
+	if c.Primary == nil {
+		return NewErrReadOnly()
+	}
+	return c.Primary.Delete(serverURL)
+}
+
+// Get returns the credentials of the first helper that has credentials for
+// the given server URL. If no helper has them and ContinueOnError is set,
+// the first error other than "credentials not found" is returned.
+func (c Chain) Get(serverURL string) (string, string, error) {
+	var firstErr error
+	for i, h := range c.Helpers {
+		username, secret, err := h.Get(serverURL)
+		if err == nil {
+			Logger().Debug("found credentials in chain", "serverURL", serverURL, "helper", i)
+			return username, secret, nil
+		}
+		if IsErrCredentialsNotFound(err) {
+			continue
+		}
+		Logger().Debug("chained helper failed", "serverURL", serverURL, "helper", i, "error", err)
+		if !c.ContinueOnError {
+			return "", "", err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return "", "", firstErr
+	}
+	return "", "", NewErrCredentialsNotFound()
+}
+
+// List merges the credentials of all helpers. Usernames from earlier
+// helpers take precedence.
+func (c Chain) List() (map[string]string, error) {
+	return c.list(Helper.List)
+}
+
+// ListAll merges all the accounts of all helpers, see MultiAccountHelper.
+func (c Chain) ListAll() (map[string]string, error) {
+	return c.list(listAll)
+}
+
+// list merges the credentials listed by list for all helpers.
+func (c Chain) list(list func(Helper) (map[string]string, error)) (map[string]string, error) {
+	resp := make(map[string]string)
+	for _, h := range c.Helpers {
+		accts, err := list(h)
+		if err != nil {
+			if c.ContinueOnError {
+				continue
+			}
+			return nil, err
+		}
+		for serverURL, username := range accts {
+			if _, exists := resp[serverURL]; !exists {
+				resp[serverURL] = username
+			}
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,104 @@
+package credentials
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingStore is a Helper whose operations all fail.
+type failingStore struct {
+	err error
+}
+
+func (f failingStore) Add(*Credentials) error             { return f.err }
+func (f failingStore) Delete(string) error                { return f.err }
+func (f failingStore) Get(string) (string, string, error) { return "", "", f.err }
+func (f failingStore) List() (map[string]string, error)   { return nil, f.err }
+
+func TestChain(t *testing.T) {
+	mounted, keychain := newMemoryStore(), newMemoryStore()
+	_ = mounted.Add(&Credentials{ServerURL: "registry.example.com", Username: "ci", Secret: "mounted"})
+	_ = keychain.Add(&Credentials{ServerURL: "registry.example.com", Username: "dev", Secret: "keychain"})
+	_ = keychain.Add(&Credentials{ServerURL: "ghcr.io", Username: "dev", Secret: "ghcr"})
+
+	c := Chain{Helpers: []Helper{mounted, keychain}, Primary: keychain}
+
+	tests := []struct {
+		serverURL string
+		username  string
+		secret    string
+	}{
+		{serverURL: "registry.example.com", username: "ci", secret: "mounted"},
+		{serverURL: "ghcr.io", username: "dev", secret: "ghcr"},
+	}
+	for _, tc := range tests {
+		username, secret, err := c.Get(tc.serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != tc.username || secret != tc.secret {
+			t.Errorf("expected %s:%s for %s, got %s:%s", tc.username, tc.secret, tc.serverURL, username, secret)
+		}
+	}
+
+	if _, _, err := c.Get("missing.example.com"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+
+	accts, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accts) != 2 || accts["registry.example.com"] != "ci" {
+		t.Errorf("unexpected credentials list: %v", accts)
+	}
+
+	if err := c.Add(&Credentials{ServerURL: "docker.io", Username: "dev", Secret: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keychain.creds["docker.io"]; !ok {
+		t.Error("expected credentials to be stored in primary helper")
+	}
+	if _, ok := mounted.creds["docker.io"]; ok {
+		t.Error("expected credentials not to be stored in secondary helper")
+	}
+
+	if err := c.Delete("registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mounted.creds["registry.example.com"]; !ok {
+		t.Error("expected credentials not to be erased from secondary helper")
+	}
+}
+
+func TestChainErrors(t *testing.T) {
+	errBroken := errors.New("broken")
+	keychain := newMemoryStore()
+	_ = keychain.Add(&Credentials{ServerURL: "registry.example.com", Username: "dev", Secret: "keychain"})
+
+	c := Chain{Helpers: []Helper{failingStore{err: errBroken}, keychain}}
+	if _, _, err := c.Get("registry.example.com"); !errors.Is(err, errBroken) {
+		t.Errorf("expected error %v, got %v", errBroken, err)
+	}
+	if _, err := c.List(); !errors.Is(err, errBroken) {
+		t.Errorf("expected error %v, got %v", errBroken, err)
+	}
+
+	c.ContinueOnError = true
+	if _, secret, err := c.Get("registry.example.com"); err != nil || secret != "keychain" {
+		t.Errorf("expected secret from second helper, got %q, %v", secret, err)
+	}
+	if _, _, err := c.Get("missing.example.com"); !errors.Is(err, errBroken) {
+		t.Errorf("expected error %v, got %v", errBroken, err)
+	}
+	if accts, err := c.List(); err != nil || len(accts) != 1 {
+		t.Errorf("expected credentials from second helper, got %v, %v", accts, err)
+	}
+
+	if err := c.Add(&Credentials{ServerURL: "docker.io", Username: "dev"}); !IsErrReadOnly(err) {
+		t.Errorf("expected read-only error storing credentials without primary helper, got %v", err)
+	}
+	if err := c.Delete("docker.io"); !IsErrReadOnly(err) {
+		t.Errorf("expected read-only error erasing credentials without primary helper, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ApprovalStore remembers the approvals given by the user through pinentry,
+// so that the user is not asked again for a while.
+type ApprovalStore interface {
+	// IsApproved reports whether the user approved releasing the
+	// credentials for serverURL to executable, and the approval has not
+	// expired.
+	IsApproved(executable, serverURL string) (bool, error)
+	// Approve remembers that the user approved releasing the credentials
+	// for serverURL to executable for d.
+	Approve(executable, serverURL string, d time.Duration) error
+}
+
+// approvalStore is where Confirm remembers approvals, if anywhere.
+var approvalStore ApprovalStore
+
+// SetApprovalStore sets where Confirm remembers approvals. The store must be
+// out of reach of the callers, which run as the same user as the helper, so
+// approvals cannot be kept in a file: the credential agent keeps them in
+// memory, and only accepts them from helpers. Without a store, the user is
+// not offered to remember approvals.
+func SetApprovalStore(s ApprovalStore) {
+	approvalStore = s
+}
+
+// Confirm asks the user to approve releasing the credentials for serverURL
+// to caller, if a rule applying to get requires confirmation. It returns
+// nil if no confirmation is required, or if the user approves, either now
+// or in the remembered past. If the user denies the request, the error is
+// one for which IsErrCallerDenied returns true.
+//
+// The pinentry program is the one set in the policy, or the first pinentry
+// found in the system's binary directories.
+func (p *Policy) Confirm(serverURL string, caller *Caller) error {
+	required, remember := p.confirmation(serverURL)
+	if !required {
+		return nil
+	}
+	// Approvals are remembered per executable, so they cannot be
+	// remembered for unidentified callers.
+	store := approvalStore
+	if caller == nil || caller.Executable == "" || store == nil {
+		remember = 0
+	}
+	if remember > 0 {
+		approved, err := store.IsApproved(caller.Executable, serverURL)
+		switch {
+		case err != nil:
+			Logger().Debug("cannot look up approvals", "error", err)
+			remember = 0
+		case approved:
+			Logger().Debug("remembered approval", "serverURL", serverURL, "caller", caller)
+			return nil
+		}
+	}
+
+	program, err := p.pinentry()
+	if err != nil {
+		return err
+	}
+	answer, err := askApproval(program, serverURL, caller, remember)
+	if err != nil {
+		return err
+	}
+	Logger().Debug("asked for approval", "serverURL", serverURL, "caller", caller, "approval", answer)
+	switch answer {
+	case approvalRemember:
+		if err := store.Approve(caller.Executable, serverURL, remember); err != nil {
+			Logger().Debug("cannot remember approval", "error", err)
+		}
+		return nil
+	case approvalOnce:
+		return nil
+	default:
+		return errCallerDenied{action: ActionGet, serverURL: serverURL, caller: describeCaller(caller)}
+	}
+}
+
+// pinentry returns the pinentry program to ask for approvals with. It is
+// not looked up in $PATH, which callers control.
+func (p *Policy) pinentry() (string, error) {
+	if p.Pinentry != "" {
+		return p.Pinentry, nil
+	}
+	for _, path := range defaultPinentries {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("cannot ask for approval: no pinentry program found, set one in the policy")
+}
+
+// confirmation returns whether a get for serverURL must be confirmed, and
+// for how long the approval may be remembered. If several rules require
+// confirmation, the shortest duration applies.
+func (p *Policy) confirmation(serverURL string) (required bool, remember time.Duration) {
+	for _, rule := range p.Rules {
+		if !rule.Confirm || !rule.appliesTo(ActionGet, serverURL) {
+			continue
+		}
+		d := time.Duration(rule.Remember) * time.Minute
+		if !required || d < remember {
+			remember = d
+		}
+		required = true
+	}
+	return required, remember
+}
@@ -0,0 +1,178 @@
+package credentials
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryApprovals is an ApprovalStore keeping approvals in memory.
+type memoryApprovals map[[2]string]time.Time
+
+func (m memoryApprovals) IsApproved(executable, serverURL string) (bool, error) {
+	return time.Now().Before(m[[2]string{executable, serverURL}]), nil
+}
+
+func (m memoryApprovals) Approve(executable, serverURL string, d time.Duration) error {
+	m[[2]string{executable, serverURL}] = time.Now().Add(d)
+	return nil
+}
+
+// setApprovalStore sets the approval store for the duration of the test.
+func setApprovalStore(t *testing.T, s ApprovalStore) {
+	t.Helper()
+	SetApprovalStore(s)
+	t.Cleanup(func() { SetApprovalStore(nil) })
+}
+
+func TestPolicyConfirm(t *testing.T) {
+	approvals := memoryApprovals{}
+	setApprovalStore(t, approvals)
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"registries": ["*.prod.example.com"], "confirm": true, "remember": 10}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker := &Caller{PID: 1, Executable: "/usr/bin/docker"}
+	curl := &Caller{PID: 2, Executable: "/usr/bin/curl"}
+
+	log := setFakePinentry(t, p, pinentryOK)
+	if err := p.Confirm("registry.dev.example.com", docker); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pinentryCommands(t, log); len(cmds) != 0 {
+		t.Errorf("expected no confirmation outside of the rule, got %v", cmds)
+	}
+	if err := p.Confirm("registry.prod.example.com", docker); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pinentryCommands(t, log); len(cmds) == 0 {
+		t.Error("expected confirmation")
+	}
+
+	// Approving once is not remembered.
+	log = setFakePinentry(t, p, pinentryCancelled)
+	if err := p.Confirm("registry.prod.example.com", docker); !IsErrCallerDenied(err) {
+		t.Fatalf("expected denied request, got %v", err)
+	}
+
+	log = setFakePinentry(t, p, pinentryNotOK)
+	if err := p.Confirm("registry.prod.example.com", docker); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := pinentryCommands(t, log); len(cmds) == 0 {
+		t.Error("expected confirmation")
+	}
+
+	// The approval is remembered for the same executable and registry
+	// only.
+	log = setFakePinentry(t, p, pinentryCancelled)
+	if err := p.Confirm("registry.prod.example.com", docker); err != nil {
+		t.Errorf("expected remembered approval, got %v", err)
+	}
+	if cmds := pinentryCommands(t, log); len(cmds) != 0 {
+		t.Errorf("expected no confirmation, got %v", cmds)
+	}
+	if err := p.Confirm("registry.prod.example.com", curl); !IsErrCallerDenied(err) {
+		t.Errorf("expected denied request for another executable, got %v", err)
+	}
+	if err := p.Confirm("other.prod.example.com", docker); !IsErrCallerDenied(err) {
+		t.Errorf("expected denied request for another registry, got %v", err)
+	}
+}
+
+func TestPolicyConfirmWithoutApprovalStore(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"confirm": true, "remember": 10}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker := &Caller{PID: 1, Executable: "/usr/bin/docker"}
+
+	// Without an approval store, the user is not offered to remember the
+	// approval, and answering "not OK" denies the request.
+	log := setFakePinentry(t, p, pinentryNotOK)
+	if err := p.Confirm("registry.example.com", docker); !IsErrCallerDenied(err) {
+		t.Fatalf("expected denied request, got %v", err)
+	}
+	if cmds := strings.Join(pinentryCommands(t, log), "\n"); strings.Contains(cmds, "SETNOTOK") {
+		t.Errorf("expected no remember option, got:\n%s", cmds)
+	}
+}
+
+func TestPolicyPinentry(t *testing.T) {
+	if _, err := ParsePolicy(strings.NewReader(`{"rules": [], "pinentry": "pinentry-curses"}`)); err == nil {
+		t.Error("expected error for a relative pinentry")
+	}
+
+	system := &Policy{Pinentry: "/usr/bin/pinentry-gnome3"}
+	user := &Policy{Pinentry: "/home/user/bin/pinentry"}
+	if p := mergePolicies(system, user); p.Pinentry != system.Pinentry {
+		t.Errorf("expected the pinentry of the system policy, got %s", p.Pinentry)
+	}
+	if p := mergePolicies(&Policy{}, user); p.Pinentry != "" {
+		t.Errorf("expected the pinentry of the user to be ignored, got %s", p.Pinentry)
+	}
+	if p := mergePolicies(nil, user); p.Pinentry != user.Pinentry {
+		t.Errorf("expected the pinentry of the user policy, got %s", p.Pinentry)
+	}
+}
+
+func TestPolicyConfirmation(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(`{
+  "rules": [
+    {"actions": ["get"], "confirm": true, "remember": 30},
+    {"registries": ["*.prod.example.com"], "confirm": true, "remember": 5},
+    {"actions": ["store"], "registries": ["store.example.com"], "confirm": true}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if required, remember := p.confirmation("registry.example.com"); !required || remember != 30*time.Minute {
+		t.Errorf("expected confirmation remembered for 30 minutes, got %t, %s", required, remember)
+	}
+	if required, remember := p.confirmation("registry.prod.example.com"); !required || remember != 5*time.Minute {
+		t.Errorf("expected confirmation remembered for 5 minutes, got %t, %s", required, remember)
+	}
+
+	p.Rules = p.Rules[2:]
+	if required, _ := p.confirmation("store.example.com"); required {
+		t.Error("expected no confirmation for rules not applying to get")
+	}
+}
+
+func TestHandleCommandConfirm(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"confirm": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "registry.example.com", Username: "foo", Secret: "bar"})
+
+	// Looking up missing credentials does not ask for approval.
+	log := setFakePinentry(t, p, pinentryCancelled)
+	out := new(strings.Builder)
+	if err := HandleCommand(h, ActionGet, strings.NewReader("missing.example.com"), out); !IsErrCredentialsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if cmds := pinentryCommands(t, log); len(cmds) != 0 {
+		t.Errorf("expected no confirmation, got %v", cmds)
+	}
+
+	if err := HandleCommand(h, ActionGet, strings.NewReader("registry.example.com"), out); !IsErrCallerDenied(err) {
+		t.Fatalf("expected denied request, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %s", out.String())
+	}
+
+	setFakePinentry(t, p, pinentryOK)
+	if err := HandleCommand(h, ActionGet, strings.NewReader("registry.example.com"), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Secret":"bar"`) {
+		t.Errorf("expected credentials, got %s", out.String())
+	}
+}
@@ -0,0 +1,252 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/internal/fsutil"
+)
+
+// envContainersAuth is the environment variable enabling the read-through
+// of the containers auth file in Serve.
+const envContainersAuth = "DOCKER_CREDENTIAL_HELPERS_CONTAINERS_AUTH"
+
+// dockerHubServerURL is the server URL docker uses for Docker Hub, which is
+// docker.io in the containers auth file.
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+// ContainersAuthFile returns the path of the auth file of the containers
+// tools (podman, skopeo, buildah): the file set in the REGISTRY_AUTH_FILE
+// environment variable or, if not set, containers/auth.json in
+// $XDG_RUNTIME_DIR on Linux, or in ~/.config on other platforms.
+func ContainersAuthFile() (string, error) {
+	if path := os.Getenv("REGISTRY_AUTH_FILE"); path != "" {
+		return path, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && runtime.GOOS == "linux" {
+		return filepath.Join(dir, "containers", "auth.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "containers", "auth.json"), nil
+}
+
+// ContainersAuth is a Helper storing credentials in plain text in a
+// containers auth file, as used by podman, skopeo and buildah.
+//
+// Server URLs are translated between the forms docker and the containers
+// tools use: https://index.docker.io/v1/ is docker.io in the file, and
+// schemes are dropped. The file may hold credentials scoped to a namespace
+// or repository, such as quay.io/org/repo; Get returns the credentials of
+// the longest such scope that contains the server URL.
+type ContainersAuth struct {
+	path string
+}
+
+// NewContainersAuth creates a ContainersAuth for the file at path.
+func NewContainersAuth(path string) *ContainersAuth {
+	return &ContainersAuth{path: path}
+}
+
+// containersAuthFile is the content of a containers auth file. Unknown
+// fields are kept when the file is rewritten.
+type containersAuthFile struct {
+	fields map[string]json.RawMessage
+	auths  map[string]map[string]json.RawMessage
+}
+
+func (c *ContainersAuth) load() (*containersAuthFile, error) {
+	f := &containersAuthFile{fields: map[string]json.RawMessage{}, auths: map[string]map[string]json.RawMessage{}}
+	b, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &f.fields); err != nil {
+		return nil, fmt.Errorf("invalid containers auth file %s: %w", c.path, err)
+	}
+	if raw, ok := f.fields["auths"]; ok {
+		if err := json.Unmarshal(raw, &f.auths); err != nil {
+			return nil, fmt.Errorf("invalid containers auth file %s: %w", c.path, err)
+		}
+		if f.auths == nil {
+			f.auths = map[string]map[string]json.RawMessage{}
+		}
+	}
+	return f, nil
+}
+
+func (c *ContainersAuth) save(f *containersAuthFile) error {
+	var err error
+	if f.fields["auths"], err = json.Marshal(f.auths); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(f.fields, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(c.path, append(b, '\n'))
+}
+
+// Add stores credentials in the auth file. Auth files have no access
+// scopes, so pull-only credentials are stored as any others, and OAuth2
+// credentials are refused, as their refresh token would be stored.
+func (c *ContainersAuth) Add(creds *Credentials) error {
+	if creds == nil {
+		return NewErrCredentialsMissingServerURL()
+	}
+	secret, err := containersAuthSecret(creds)
+	if err != nil {
+		return err
+	}
+	f, err := c.load()
+	if err != nil {
+		return err
+	}
+	key := containersAuthKey(creds.ServerURL)
+	entry := f.auths[key]
+	if entry == nil {
+		entry = map[string]json.RawMessage{}
+	}
+	for _, field := range []string{"auth", "identitytoken", "username", "password"} {
+		delete(entry, field)
+	}
+	if creds.Username == tokenUsername {
+		entry["identitytoken"], err = json.Marshal(secret)
+	} else {
+		entry["auth"], err = json.Marshal(base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + secret)))
+	}
+	if err != nil {
+		return err
+	}
+	f.auths[key] = entry
+	return c.save(f)
+}
+
+// containersAuthSecret returns the secret of creds to store in an auth
+// file, without the marker of pull-only credentials.
+func containersAuthSecret(creds *Credentials) (string, error) {
+	secret, _ := splitAccess(creds.Secret)
+	if _, ok := parseOAuth2Secret(secret); ok {
+		return "", fmt.Errorf("cannot store the OAuth2 credentials of %s in a containers auth file: it would hold their refresh token", creds.ServerURL)
+	}
+	return secret, nil
+}
+
+// Delete removes credentials from the auth file.
+func (c *ContainersAuth) Delete(serverURL string) error {
+	f, err := c.load()
+	if err != nil {
+		return err
+	}
+	key := containersAuthKey(serverURL)
+	if _, ok := f.auths[key]; !ok {
+		return NewErrCredentialsNotFound()
+	}
+	delete(f.auths, key)
+	return c.save(f)
+}
+
+// Get returns the credentials for serverURL, or for the longest namespace
+// or repository scope containing it.
+func (c *ContainersAuth) Get(serverURL string) (string, string, error) {
+	f, err := c.load()
+	if err != nil {
+		return "", "", err
+	}
+	for key := containersAuthKey(serverURL); key != ""; {
+		if entry, ok := f.auths[key]; ok {
+			creds, err := plaintextCredentials(key, entry)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid credentials for %s in %s: %w", key, c.path, err)
+			}
+			if creds != nil {
+				return creds.Username, creds.Secret, nil
+			}
+		}
+		i := strings.LastIndexByte(key, '/')
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return "", "", NewErrCredentialsNotFound()
+}
+
+// List returns the server URLs and usernames of the credentials in the
+// auth file.
+func (c *ContainersAuth) List() (map[string]string, error) {
+	f, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	accts := make(map[string]string, len(f.auths))
+	for key, entry := range f.auths {
+		creds, err := plaintextCredentials(key, entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credentials for %s in %s: %w", key, c.path, err)
+		}
+		if creds != nil {
+			accts[dockerServerURL(key)] = creds.Username
+		}
+	}
+	return accts, nil
+}
+
+// containersAuthKey returns the key of the credentials for a docker server
+// URL in a containers auth file.
+func containersAuthKey(serverURL string) string {
+	key := serverURL
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	}
+	key = strings.TrimRight(key, "/")
+	switch key {
+	case "index.docker.io/v1", "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return key
+}
+
+// dockerServerURL returns the docker server URL for a key of a containers
+// auth file.
+func dockerServerURL(key string) string {
+	if key == "docker.io" {
+		return dockerHubServerURL
+	}
+	return key
+}
+
+// containersAuthFromEnv chains the containers auth file after helper, for
+// Get and List, if enabled in the environment.
+func containersAuthFromEnv(helper Helper) (Helper, error) {
+	v := os.Getenv(envContainersAuth)
+	if v == "" {
+		return helper, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envContainersAuth, err)
+	}
+	if !enabled {
+		return helper, nil
+	}
+	path, err := ContainersAuthFile()
+	if err != nil {
+		return nil, err
+	}
+	return Chain{Helpers: []Helper{helper, NewContainersAuth(path)}, Primary: helper}, nil
+}
@@ -0,0 +1,200 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const containersAuthJSON = `{
+	"auths": {
+		"docker.io": {"auth": "Zm9vOmJhcg=="},
+		"quay.io": {"auth": "cXVheTpzZWNyZXQ="},
+		"quay.io/org/repo": {"auth": "cm9ib3Q6cmVwby1zZWNyZXQ="},
+		"registry.example.com": {"identitytoken": "refresh-token"}
+	},
+	"credHelpers": {"gcr.io": "gcloud"}
+}`
+
+func TestContainersAuthGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(containersAuthJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewContainersAuth(path)
+
+	tests := []struct {
+		serverURL string
+		username  string
+		secret    string
+	}{
+		{serverURL: "https://index.docker.io/v1/", username: "foo", secret: "bar"},
+		{serverURL: "docker.io", username: "foo", secret: "bar"},
+		{serverURL: "quay.io", username: "quay", secret: "secret"},
+		{serverURL: "https://quay.io/", username: "quay", secret: "secret"},
+		{serverURL: "quay.io/org/repo", username: "robot", secret: "repo-secret"},
+		{serverURL: "quay.io/org/repo/image", username: "robot", secret: "repo-secret"},
+		{serverURL: "quay.io/org/other", username: "quay", secret: "secret"},
+		{serverURL: "quay.io/org/repository", username: "quay", secret: "secret"},
+		{serverURL: "registry.example.com", username: tokenUsername, secret: "refresh-token"},
+	}
+	for _, tc := range tests {
+		username, secret, err := c.Get(tc.serverURL)
+		if err != nil {
+			t.Errorf("%s: %v", tc.serverURL, err)
+			continue
+		}
+		if username != tc.username || secret != tc.secret {
+			t.Errorf("%s: expected %s:%s, got %s:%s", tc.serverURL, tc.username, tc.secret, username, secret)
+		}
+	}
+	if _, _, err := c.Get("ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+
+	accts, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		dockerHubServerURL:     "foo",
+		"quay.io":              "quay",
+		"quay.io/org/repo":     "robot",
+		"registry.example.com": tokenUsername,
+	}
+	if !reflect.DeepEqual(accts, expected) {
+		t.Errorf("expected %v, got %v", expected, accts)
+	}
+}
+
+func TestContainersAuthAddDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "containers", "auth.json")
+	c := NewContainersAuth(path)
+
+	if _, _, err := c.Get("ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found without file, got %v", err)
+	}
+	for _, creds := range []*Credentials{
+		{ServerURL: dockerHubServerURL, Username: "foo", Secret: "bar"},
+		{ServerURL: "https://ghcr.io", Username: "octocat", Secret: "hunter2"},
+		{ServerURL: "registry.example.com", Username: tokenUsername, Secret: "refresh-token"},
+	} {
+		if err := c.Add(creds); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Auths map[string]map[string]string `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]string{
+		"docker.io":            {"auth": "Zm9vOmJhcg=="},
+		"ghcr.io":              {"auth": "b2N0b2NhdDpodW50ZXIy"},
+		"registry.example.com": {"identitytoken": "refresh-token"},
+	}
+	if !reflect.DeepEqual(file.Auths, expected) {
+		t.Errorf("expected %v, got %v", expected, file.Auths)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("expected private auth file, got %v", info.Mode())
+	}
+
+	if err := c.Delete("ghcr.io"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get("ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials to be deleted, got %v", err)
+	}
+	if err := c.Delete("ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+}
+
+func TestContainersAuthKeepsFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(containersAuthJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewContainersAuth(path).Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"gcr.io": "gcloud"}; !reflect.DeepEqual(file.CredHelpers, expected) {
+		t.Errorf("expected credHelpers to be kept, got %v", file.CredHelpers)
+	}
+}
+
+func TestContainersAuthFile(t *testing.T) {
+	t.Setenv("REGISTRY_AUTH_FILE", "/etc/containers/auth.json")
+	if path, err := ContainersAuthFile(); err != nil || path != "/etc/containers/auth.json" {
+		t.Errorf("expected REGISTRY_AUTH_FILE, got %s, %v", path, err)
+	}
+
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	expected := filepath.Join("/run/user/1000", "containers", "auth.json")
+	if runtime.GOOS != "linux" {
+		home, _ := os.UserHomeDir()
+		expected = filepath.Join(home, ".config", "containers", "auth.json")
+	}
+	if path, err := ContainersAuthFile(); err != nil || path != expected {
+		t.Errorf("expected %s, got %s, %v", expected, path, err)
+	}
+}
+
+func TestContainersAuthFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(containersAuthJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_AUTH_FILE", path)
+
+	native := newMemoryStore()
+	if h, err := containersAuthFromEnv(native); err != nil || h != Helper(native) {
+		t.Errorf("expected helper to be unchanged, got %v, %v", h, err)
+	}
+
+	t.Setenv(envContainersAuth, "1")
+	h, err := containersAuthFromEnv(native)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username, _, err := h.Get("quay.io"); err != nil || username != "quay" {
+		t.Errorf("expected credentials from the containers auth file, got %s, %v", username, err)
+	}
+	if err := h.Add(&Credentials{ServerURL: "quay.io", Username: "native", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if username, _, err := h.Get("quay.io"); err != nil || username != "native" {
+		t.Errorf("expected credentials from the native helper first, got %s, %v", username, err)
+	}
+	if username, _, err := NewContainersAuth(path).Get("quay.io"); err != nil || username != "quay" {
+		t.Errorf("expected containers auth file to be left untouched, got %s, %v", username, err)
+	}
+
+	t.Setenv(envContainersAuth, "maybe")
+	if _, err := containersAuthFromEnv(native); err == nil {
+		t.Error("expected error with invalid value")
+	}
+}
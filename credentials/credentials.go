@@ -4,10 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+
+	"github.com/docker/docker-credential-helpers/registryurl"
 )
 
 // Action defines the name of an action (sub-command) supported by a
@@ -21,6 +25,7 @@ const (
 	ActionGet     Action = "get"
 	ActionErase   Action = "erase"
 	ActionList    Action = "list"
+	ActionListAll Action = "list-all"
 	ActionVersion Action = "version"
 )
 
@@ -29,6 +34,43 @@ type Credentials struct {
 	ServerURL string
 	Username  string
 	Secret    string
+	// Access is the access scope of the credentials: AccessPull for
+	// read-only credentials, or AccessPush or empty for read-write ones.
+	Access string `json:",omitempty"`
+}
+
+// request is the input of Get and Erase when it is not just a server URL.
+type request struct {
+	ServerURL string
+	// Username selects one of the accounts kept for the server URL.
+	Username string
+	// Access is the action, AccessPull or AccessPush, credentials are
+	// requested for. It is ignored by Erase.
+	Access string
+}
+
+// parseRequest parses the input of Get and Erase, which is either a server
+// URL, possibly designating an account, or the JSON serialization of a
+// request.
+func parseRequest(input string) (request, error) {
+	if !strings.HasPrefix(input, "{") {
+		serverURL, username := SplitAccountServerURL(input)
+		return request{ServerURL: serverURL, Username: username}, nil
+	}
+	var r request
+	if err := json.Unmarshal([]byte(input), &r); err != nil {
+		return request{}, err
+	}
+	if r.ServerURL == "" {
+		return request{}, NewErrCredentialsMissingServerURL()
+	}
+	if _, username := SplitAccountServerURL(r.ServerURL); username != "" {
+		return request{}, errors.New("server URL must not have user information")
+	}
+	if err := validateAccess(r.Access); err != nil {
+		return request{}, err
+	}
+	return r, nil
 }
 
 // isValid checks the integrity of Credentials object such that no credentials lack
@@ -59,45 +101,189 @@ func SetCredsLabel(label string) {
 
 // Serve initializes the credentials-helper and parses the action argument.
 // This function is designed to be called from a command line interface.
-// It uses os.Args[1] as the key for the action.
+// It uses the last command line argument as the key for the action.
 // It uses os.Stdin as input and os.Stdout as output.
 // This function terminates the program with os.Exit(1) if there is an error.
+//
+// Passing --read-only before the action, or setting the
+// DOCKER_CREDENTIAL_HELPERS_READ_ONLY environment variable to a true value,
+// makes the store and erase actions fail. Passing --label before the
+// action overrides CredsLabel.
+//
+// Besides the actions used by docker, Serve also runs the ls, show, login,
+// rm, export, import, migrate and verify commands, which manage credentials
+// from a terminal.
+//
+// Credentials stored with NewOAuth2Credentials are returned with a renewed
+// access token, see OAuth2. The DOCKER_CREDENTIAL_HELPERS_DEFAULT_ACCOUNT
+// environment variable selects the default accounts of server URLs with
+// several accounts, see NewDefaultAccount, and the
+// DOCKER_CREDENTIAL_HELPERS_ACCESS environment variable the action
+// credentials are requested for, see SetDefaultAccess. The export and
+// import commands ignore them, and act on the credentials as stored.
 func Serve(helper Helper) {
-	if len(os.Args) != 2 {
+	args := os.Args[1:]
+	readOnly := readOnlyFromEnv()
+options:
+	for len(args) > 0 {
+		switch opt, label, hasValue := strings.Cut(args[0], "="); {
+		case opt == "--read-only" && !hasValue:
+			readOnly = true
+			args = args[1:]
+		case opt == "--label":
+			if !hasValue {
+				if len(args) < 2 {
+					_, _ = fmt.Fprintln(os.Stdout, usage())
+					os.Exit(1)
+				}
+				label, args = args[1], args[1:]
+			}
+			SetCredsLabel(label)
+			args = args[1:]
+		default:
+			break options
+		}
+	}
+
+	if len(args) == 0 {
+		_, _ = fmt.Fprintln(os.Stdout, usage())
+		os.Exit(1)
+	}
+	manage, isManageCommand := manageCommands[args[0]]
+	if !isManageCommand && ((len(args) > 1 && args[0] != "audit-verify") || len(args) > 2) {
 		_, _ = fmt.Fprintln(os.Stdout, usage())
 		os.Exit(1)
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "--version", "-v":
 		_ = PrintVersion(os.Stdout)
 		os.Exit(0)
 	case "--help", "-h":
 		_, _ = fmt.Fprintln(os.Stdout, usage())
 		os.Exit(0)
+	case "audit-verify":
+		if err := verifyAuditLogCommand(args[1:], os.Stdout); err != nil {
+			_, _ = fmt.Fprintln(os.Stdout, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// The cache holds the credentials as stored in the helper, so that
+	// OAuth2 renews cached tokens when they expire.
+	helper, err := cacheFromEnv(helper)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
+	}
+
+	// Bundles hold the credentials as stored in the helper: the refresh
+	// tokens of OAuth2 credentials, so they can be restored, rather than
+	// their short-lived access tokens, and every account, whatever the
+	// accounts and access selected in the environment.
+	if args[0] != "export" && args[0] != "import" {
+		o := NewOAuth2(helper)
+		o.readOnly = readOnly
+		helper = o
+
+		if helper, err = containersAuthFromEnv(helper); err != nil {
+			_, _ = fmt.Fprintln(os.Stdout, err)
+			os.Exit(1)
+		}
+
+		access, err := accessFromEnv()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stdout, err)
+			os.Exit(1)
+		}
+		SetDefaultAccess(access)
+
+		if helper, err = defaultAccountFromEnv(helper); err != nil {
+			_, _ = fmt.Fprintln(os.Stdout, err)
+			os.Exit(1)
+		}
+	}
+
+	p, err := LoadDefaultPolicy()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stdout, err)
+		os.Exit(1)
 	}
+	SetPolicy(p)
+	SetAuditLog(DefaultAuditLog())
 
-	if err := HandleCommand(helper, os.Args[1], os.Stdin, os.Stdout); err != nil {
+	if readOnly {
+		helper = ReadOnly(helper)
+	}
+
+	if isManageCommand {
+		if err := manage(newManager(helper), args[1:]); err != nil && err != flag.ErrHelp {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := HandleCommand(helper, args[0], os.Stdin, os.Stdout); err != nil {
 		_, _ = fmt.Fprintln(os.Stdout, err)
 		os.Exit(1)
 	}
 }
 
 func usage() string {
-	return fmt.Sprintf("Usage: %s <store|get|erase|list|version>", Name)
+	return fmt.Sprintf("Usage: %s [--read-only] [--label <label>] <store|get|erase|list|list-all|version>\n"+
+		"       %s [--read-only] [--label <label>] <ls|show|login|rm|export|import|migrate|verify> [<options>] [<argument>]\n"+
+		"       %s audit-verify [<path>]", Name, Name, Name)
 }
 
 // HandleCommand runs a helper to execute a credential action.
+// If a policy is set with SetPolicy, actions it refuses fail with an error
+// for which IsErrPolicyViolation returns true. If an audit log is set with
+// SetAuditLog, the action and its outcome are recorded in it.
+//
+// The process requesting the action is assumed to be the parent process, as
+// is the case for helpers executed by docker.
 func HandleCommand(helper Helper, action Action, in io.Reader, out io.Writer) error {
+	// The credentials get and erase act on are resolved with helper itself,
+	// so that the policy and the audit log only see the credentials
+	// actually read or removed.
+	lookup := helper
+	var caller *Caller
+	if policy != nil || auditLog != nil {
+		caller = parentCaller()
+	}
+	if policy != nil {
+		helper = policyHelper{helper: helper, policy: policy, caller: caller}
+	}
+	if auditLog != nil {
+		return handleAudited(auditLog, helper, lookup, action, caller, in, out)
+	}
+	return handleCommand(helper, lookup, action, in, out)
+}
+
+func handleCommand(helper, lookup Helper, action Action, in io.Reader, out io.Writer) error {
+	Logger().Debug("handling action", "helper", Name, "version", Version, "action", action)
+
+	err := dispatchCommand(helper, lookup, action, in, out)
+	if err != nil {
+		Logger().Debug("action failed", "action", action, "error", err)
+	}
+	return err
+}
+
+func dispatchCommand(helper, lookup Helper, action Action, in io.Reader, out io.Writer) error {
 	switch action {
 	case ActionStore:
 		return Store(helper, in)
 	case ActionGet:
-		return Get(helper, in, out)
+		return get(helper, lookup, in, out)
 	case ActionErase:
-		return Erase(helper, in)
+		return erase(helper, lookup, in)
 	case ActionList:
 		return List(helper, out)
+	case ActionListAll:
+		return ListAll(helper, out)
 	case ActionVersion:
 		return PrintVersion(out)
 	default:
@@ -127,42 +313,81 @@ func Store(helper Helper, reader io.Reader) error {
 	if ok, err := creds.isValid(); !ok {
 		return err
 	}
+	if err := validateAccess(creds.Access); err != nil {
+		return err
+	}
+	if err := validateSecret(creds.Secret); err != nil {
+		return err
+	}
+	creds.Secret = withAccess(creds.Secret, creds.Access)
 
 	return helper.Add(&creds)
 }
 
 // Get retrieves the credentials for a given server url.
-// The reader must contain the server URL to search.
+// The reader must contain the server URL to search or a JSON object with
+// the ServerURL field, and the Username field to select one of the
+// accounts kept for the server URL, or the Access field to select the
+// least privileged credentials for an action, see SetDefaultAccess.
 // The writer is used to write the JSON serialization of the credentials.
 func Get(helper Helper, reader io.Reader, writer io.Writer) error {
-	scanner := bufio.NewScanner(reader)
-
-	buffer := new(bytes.Buffer)
-	for scanner.Scan() {
-		buffer.Write(scanner.Bytes())
-	}
+	return get(helper, helper, reader, writer)
+}
 
-	if err := scanner.Err(); err != nil && err != io.EOF {
+// get is Get. It first retrieves the credentials kept under the exact
+// server URL, or account server URL, from helper, and only resolves them
+// with the credentials lookup lists when there are none, or when they do
+// not have the requested access, so that most requests do not list the
+// credentials.
+func get(helper, lookup Helper, reader io.Reader, writer io.Writer) error {
+	r, err := readRequest(reader)
+	if err != nil {
 		return err
 	}
-
-	serverURL := strings.TrimSpace(buffer.String())
-	if len(serverURL) == 0 {
-		return NewErrCredentialsMissingServerURL()
+	if r.Access == "" {
+		r.Access = defaultAccess
 	}
 
-	username, secret, err := helper.Get(serverURL)
-	if err != nil {
+	key := r.ServerURL
+	if r.Username != "" {
+		key = AccountServerURL(r.ServerURL, r.Username)
+	}
+	u, secret, err := helper.Get(key)
+	if err != nil && !IsErrCredentialsNotFound(err) {
 		return err
 	}
+	_, credsAccess := splitAccess(secret)
+	if err != nil || (r.Username != "" && u != r.Username) || (r.Access != "" && !matchesAccess(credsAccess, r.Access)) {
+		// The credentials are not kept under the exact key, or another
+		// account may suit the requested access better: resolve them from
+		// the listed credentials.
+		resolved, rerr := resolveCredentials(lookup, r.ServerURL, r.Username, r.Access)
+		if rerr != nil {
+			return rerr
+		}
+		if resolved != key {
+			key = resolved
+			u, secret, err = helper.Get(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// Helpers that cannot list their credentials may return another
+	// account than the one requested.
+	if r.Username != "" && u != r.Username {
+		return NewErrCredentialsNotFound()
+	}
+	creds := &Credentials{ServerURL: r.ServerURL, Username: u}
+	creds.Secret, creds.Access = splitAccess(secret)
+	if r.Access == AccessPush && creds.Access == AccessPull {
+		Logger().Debug("credentials are pull-only", "serverURL", r.ServerURL, "username", u)
+		return NewErrCredentialsNotFound()
+	}
+	Logger().Debug("found credentials", "serverURL", creds.ServerURL, "key", key, "username", creds.Username, "access", creds.Access)
 
-	buffer.Reset()
-	err = json.NewEncoder(buffer).Encode(Credentials{
-		ServerURL: serverURL,
-		Username:  username,
-		Secret:    secret,
-	})
-	if err != nil {
+	buffer := new(bytes.Buffer)
+	if err := json.NewEncoder(buffer).Encode(creds); err != nil {
 		return err
 	}
 
@@ -171,8 +396,49 @@ func Get(helper Helper, reader io.Reader, writer io.Writer) error {
 }
 
 // Erase removes credentials from the store.
-// The reader must contain the server URL to remove.
+// The reader must contain the server URL to remove or, to remove a single
+// account of the server URL, a JSON object with the ServerURL and Username
+// fields.
 func Erase(helper Helper, reader io.Reader) error {
+	return erase(helper, helper, reader)
+}
+
+// erase is Erase, resolving the credentials to remove with lookup. Server
+// URLs are matched with registryurl.FindSchemeMatch, so that credentials stored
+// for https://registry.example.com are removed for registry.example.com.
+func erase(helper, lookup Helper, reader io.Reader) error {
+	r, err := readRequest(reader)
+	if err != nil {
+		return err
+	}
+
+	accts, err := listAll(lookup)
+	if r.Username == "" {
+		key := r.ServerURL
+		if _, ok := accts[key]; !ok && err == nil {
+			if match, ok := registryurl.FindSchemeMatch(r.ServerURL, accountServerURLs(accts, "")); ok {
+				key = match
+			}
+		}
+		return helper.Delete(key)
+	}
+	if err != nil {
+		return err
+	}
+	key, ok := accountKey(accts, r.ServerURL, r.Username)
+	if !ok {
+		if match, found := registryurl.FindSchemeMatch(r.ServerURL, accountServerURLs(accts, r.Username)); found {
+			key, ok = accountKey(accts, match, r.Username)
+		}
+	}
+	if !ok {
+		return NewErrCredentialsNotFound()
+	}
+	return helper.Delete(key)
+}
+
+// readRequest reads the input of Get and Erase.
+func readRequest(reader io.Reader) (request, error) {
 	scanner := bufio.NewScanner(reader)
 
 	buffer := new(bytes.Buffer)
@@ -181,15 +447,80 @@ func Erase(helper Helper, reader io.Reader) error {
 	}
 
 	if err := scanner.Err(); err != nil && err != io.EOF {
-		return err
+		return request{}, err
+	}
+
+	r, err := parseRequest(strings.TrimSpace(buffer.String()))
+	if err != nil {
+		return request{}, err
+	}
+	if len(r.ServerURL) == 0 {
+		return request{}, NewErrCredentialsMissingServerURL()
+	}
+	return r, nil
+}
+
+// accountKey returns the key accts, as returned by List, holds the account
+// of username for serverURL under, or its default account if username is
+// empty. Helpers keeping a single account per server URL hold it under
+// serverURL, which is only the account of username if it is listed with
+// username. Helpers keeping several accounts hold them under their
+// account server URL, even when it is listed under serverURL because it is
+// the default account.
+func accountKey(accts map[string]string, serverURL, username string) (string, bool) {
+	if username == "" {
+		_, ok := accts[serverURL]
+		return serverURL, ok
+	}
+	accountURL := AccountServerURL(serverURL, username)
+	if _, ok := accts[accountURL]; ok {
+		return accountURL, true
 	}
+	if u, ok := accts[serverURL]; !ok || u != username {
+		return "", false
+	}
+	for key := range accts {
+		if s, u := SplitAccountServerURL(key); s == serverURL && u != "" {
+			return accountURL, true
+		}
+	}
+	return serverURL, true
+}
 
-	serverURL := strings.TrimSpace(buffer.String())
-	if len(serverURL) == 0 {
-		return NewErrCredentialsMissingServerURL()
+// lookupServerURL returns the server URL of the credentials kept for the
+// registry namespace closest to serverURL, among the server URLs accts has
+// an account of username for, or a default account if username is empty,
+// see registryurl.LongestPrefixMatch. If there are none, it returns the
+// server URL serverURL approximately matches, such as
+// https://registry.example.com:5000 for registry.example.com, see
+// registryurl.FindMatch.
+func lookupServerURL(accts map[string]string, serverURL, username string) (string, bool) {
+	candidates := accountServerURLs(accts, username)
+	if entry, ok := registryurl.LongestPrefixMatch(serverURL, candidates); ok {
+		Logger().Debug("using credentials of closest registry namespace", "serverURL", serverURL, "match", entry)
+		return entry, true
 	}
+	if entry, ok := registryurl.FindMatch(serverURL, candidates); ok {
+		Logger().Debug("using credentials of matching server URL", "serverURL", serverURL, "match", entry)
+		return entry, true
+	}
+	return "", false
+}
 
-	return helper.Delete(serverURL)
+// accountServerURLs returns the server URLs accts has an account of
+// username for, or a default account if username is empty.
+func accountServerURLs(accts map[string]string, username string) []string {
+	var serverURLs []string
+	for key, u := range accts {
+		s, accountUsername := SplitAccountServerURL(key)
+		if accountUsername != "" {
+			u = accountUsername
+		}
+		if (username == "" && accountUsername == "") || (username != "" && u == username) {
+			serverURLs = append(serverURLs, s)
+		}
+	}
+	return serverURLs
 }
 
 // List returns all the serverURLs of keys in
@@ -202,6 +533,17 @@ func List(helper Helper, writer io.Writer) error {
 	return json.NewEncoder(writer).Encode(accts)
 }
 
+// ListAll is like List, but also returns the accounts other than the
+// default account of each server URL, see MultiAccountHelper. It runs the
+// list-all action, which docker does not use.
+func ListAll(helper Helper, writer io.Writer) error {
+	accts, err := listAll(helper)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(writer).Encode(accts)
+}
+
 // PrintVersion outputs the current version.
 func PrintVersion(writer io.Writer) error {
 	_, _ = fmt.Fprintf(writer, "%s (%s) %s\n", Name, Package, Version)
@@ -3,7 +3,6 @@ package credentials
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"strings"
 	"testing"
 )
@@ -31,14 +30,17 @@ func (m *memoryStore) Delete(serverURL string) error {
 func (m *memoryStore) Get(serverURL string) (string, string, error) {
 	c, ok := m.creds[serverURL]
 	if !ok {
-		return "", "", fmt.Errorf("creds not found for %s", serverURL)
+		return "", "", NewErrCredentialsNotFound()
 	}
 	return c.Username, c.Secret, nil
 }
 
 func (m *memoryStore) List() (map[string]string, error) {
-	// Simply a placeholder to let memoryStore be a valid implementation of Helper interface
-	return nil, nil
+	resp := make(map[string]string, len(m.creds))
+	for serverURL, c := range m.creds {
+		resp[serverURL] = c.Username
+	}
+	return resp, nil
 }
 
 func TestStore(t *testing.T) {
@@ -155,6 +157,83 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetClosestNamespace(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "host", Secret: "host-secret"})
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io/org-a", Username: "a", Secret: "a-secret"})
+	_ = h.Add(&Credentials{ServerURL: "https://ghcr.io/org-b", Username: "b", Secret: "b-secret"})
+	s := newAccountStore()
+	_ = s.Add(&Credentials{ServerURL: "ghcr.io/org-a", Username: "a", Secret: "a-secret"})
+	_ = s.Add(&Credentials{ServerURL: "ghcr.io/org-a", Username: "robot", Secret: "robot-secret"})
+	_ = s.Add(&Credentials{ServerURL: "ghcr.io", Username: "robot", Secret: "host-secret"})
+
+	tests := []struct {
+		helper   Helper
+		input    string
+		expected Credentials
+	}{
+		{helper: h, input: "ghcr.io/org-a/app", expected: Credentials{ServerURL: "ghcr.io/org-a/app", Username: "a", Secret: "a-secret"}},
+		{helper: h, input: "ghcr.io/org-b/app", expected: Credentials{ServerURL: "ghcr.io/org-b/app", Username: "b", Secret: "b-secret"}},
+		{helper: h, input: "ghcr.io/org-c/app", expected: Credentials{ServerURL: "ghcr.io/org-c/app", Username: "host", Secret: "host-secret"}},
+		// Credentials for https are not sent over http.
+		{helper: h, input: "http://ghcr.io/org-b/app", expected: Credentials{ServerURL: "http://ghcr.io/org-b/app", Username: "host", Secret: "host-secret"}},
+		{helper: h, input: "ghcr.io/org-a", expected: Credentials{ServerURL: "ghcr.io/org-a", Username: "a", Secret: "a-secret"}},
+		{helper: s, input: `{"ServerURL": "ghcr.io/org-a/app", "Username": "robot"}`, expected: Credentials{ServerURL: "ghcr.io/org-a/app", Username: "robot", Secret: "robot-secret"}},
+		{helper: s, input: `{"ServerURL": "ghcr.io/org-b/app", "Username": "robot"}`, expected: Credentials{ServerURL: "ghcr.io/org-b/app", Username: "robot", Secret: "host-secret"}},
+	}
+	for _, tc := range tests {
+		out := new(bytes.Buffer)
+		if err := Get(tc.helper, strings.NewReader(tc.input), out); err != nil {
+			t.Errorf("%s: %v", tc.input, err)
+			continue
+		}
+		var actual Credentials
+		if err := json.Unmarshal(out.Bytes(), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.input, tc.expected, actual)
+		}
+	}
+
+	for _, input := range []string{"quay.io/org-a", `{"ServerURL": "ghcr.io/org-b/app", "Username": "a"}`} {
+		if err := Get(h, strings.NewReader(input), new(bytes.Buffer)); !IsErrCredentialsNotFound(err) {
+			t.Errorf("%s: expected not found error, got %v", input, err)
+		}
+	}
+}
+
+// listCounter is a Helper counting the calls to List.
+type listCounter struct {
+	*memoryStore
+	lists int
+}
+
+func (c *listCounter) List() (map[string]string, error) {
+	c.lists++
+	return c.memoryStore.List()
+}
+
+func TestGetExactKey(t *testing.T) {
+	h := &listCounter{memoryStore: newMemoryStore()}
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "host", Secret: "host-secret"})
+
+	if err := Get(h, strings.NewReader("ghcr.io"), new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	if h.lists != 0 {
+		t.Errorf("expected credentials to be retrieved without listing, got %d lists", h.lists)
+	}
+	// Credentials not kept under the server URL are resolved from the
+	// listed credentials.
+	if err := Get(h, strings.NewReader("ghcr.io/org/app"), new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	if h.lists != 1 {
+		t.Errorf("expected credentials to be listed once, got %d lists", h.lists)
+	}
+}
+
 func TestGetMissingServerURL(t *testing.T) {
 	const serverURL = "https://registry.example.com/v1/"
 	creds := &Credentials{
@@ -210,6 +289,38 @@ func TestErase(t *testing.T) {
 	}
 }
 
+func TestEraseSchemeMatch(t *testing.T) {
+	h := newAccountStore()
+	_ = h.Add(&Credentials{ServerURL: "https://registry.example.com", Username: "foo", Secret: "bar"})
+	_ = h.Add(&Credentials{ServerURL: "https://registry.example.com", Username: "robot", Secret: "bar"})
+	_ = h.Add(&Credentials{ServerURL: "https://registry.example.com:5000", Username: "foo", Secret: "bar"})
+	_ = h.Add(&Credentials{ServerURL: "http://ghcr.io", Username: "foo", Secret: "bar"})
+
+	if err := Erase(h, strings.NewReader(`{"ServerURL": "registry.example.com", "Username": "robot"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if usernames := h.usernames("https://registry.example.com"); len(usernames) != 1 || usernames[0] != "foo" {
+		t.Errorf("expected only the account of robot to be removed, got %v", usernames)
+	}
+	if err := Erase(h, strings.NewReader("registry.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if usernames := h.usernames("https://registry.example.com"); len(usernames) != 0 {
+		t.Errorf("expected credentials to be removed, got %v", usernames)
+	}
+	// Server URLs differing by more than the scheme are other registries.
+	if usernames := h.usernames("https://registry.example.com:5000"); len(usernames) != 1 {
+		t.Errorf("expected credentials of https://registry.example.com:5000 to be kept, got %v", usernames)
+	}
+	// A scheme is never substituted for another.
+	if err := Erase(h, strings.NewReader("https://ghcr.io")); err != nil {
+		t.Fatal(err)
+	}
+	if usernames := h.usernames("http://ghcr.io"); len(usernames) != 1 {
+		t.Errorf("expected credentials of http://ghcr.io to be kept, got %v", usernames)
+	}
+}
+
 func TestEraseMissingServerURL(t *testing.T) {
 	const serverURL = "https://registry.example.com/v1/"
 	creds := &Credentials{
@@ -0,0 +1,98 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/internal/oauth2"
+)
+
+// Environment variables configuring the authorization server of the
+// device login.
+const (
+	envOAuth2Issuer   = "DOCKER_CREDENTIAL_HELPERS_OAUTH2_ISSUER"
+	envOAuth2ClientID = "DOCKER_CREDENTIAL_HELPERS_OAUTH2_CLIENT_ID"
+)
+
+// deviceLogin logs in to serverURL with the OAuth2 device authorization
+// grant: the user authorizes the login in a browser, possibly on another
+// device, with the code it prints. The tokens obtained are stored as
+// OAuth2 credentials if they include a refresh token, so the access token
+// is renewed, or else the access token is stored as the secret.
+//
+// The token endpoint and the device authorization endpoint are discovered
+// from issuer, unless set in token and deviceURL.
+func (m *manager) deviceLogin(serverURL, username string, token OAuth2Token, issuer, deviceURL string) error {
+	if issuer == "" {
+		issuer = os.Getenv(envOAuth2Issuer)
+	}
+	if token.ClientID == "" {
+		token.ClientID = os.Getenv(envOAuth2ClientID)
+	}
+	if token.ClientID == "" {
+		return errors.New("--device requires --client-id")
+	}
+
+	client := &http.Client{Timeout: oauth2Timeout}
+	ctx := context.Background()
+	if issuer != "" && (token.TokenURL == "" || deviceURL == "") {
+		md, err := oauth2.Discover(ctx, client, issuer)
+		if err != nil {
+			return err
+		}
+		if token.TokenURL == "" {
+			token.TokenURL = md.TokenEndpoint
+		}
+		if deviceURL == "" {
+			deviceURL = md.DeviceAuthorizationEndpoint
+		}
+	}
+	if token.TokenURL == "" || deviceURL == "" {
+		return errors.New("--device requires --issuer, or --token-url and --device-url")
+	}
+
+	issued := time.Now()
+	auth, err := oauth2.RequestDeviceAuthorization(ctx, client, deviceURL, token.ClientID, token.Scope)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(m.errOut, "To log in to %s, open %s and enter the code %s\n", serverURL, auth.VerificationURI, auth.UserCode)
+	if auth.VerificationURIComplete != "" {
+		_, _ = fmt.Fprintf(m.errOut, "or open %s\n", auth.VerificationURIComplete)
+	}
+	_, _ = fmt.Fprintln(m.errOut, "Waiting for authorization...")
+
+	interval := auth.PollInterval()
+	if m.devicePollInterval > 0 {
+		interval = m.devicePollInterval
+	}
+	pollCtx, cancel := context.WithDeadline(ctx, auth.Expiry(issued))
+	defer cancel()
+	t, err := oauth2.PollDeviceToken(pollCtx, client, token.TokenURL, token.ClientID, auth.DeviceCode, interval)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errors.New("the code expired before the login was authorized")
+	}
+	if err != nil {
+		return err
+	}
+
+	creds := &Credentials{ServerURL: serverURL, Username: username, Secret: t.AccessToken}
+	if t.RefreshToken != "" {
+		token.RefreshToken = t.RefreshToken
+		token.AccessToken = t.AccessToken
+		token.Expiry = t.Expiry(time.Now())
+		if creds, err = NewOAuth2Credentials(serverURL, username, &token); err != nil {
+			return err
+		}
+	}
+	creds.Access = token.Access
+	if err := (commandHelper{m.helper}).Add(creds); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(m.out, "Stored credentials for %s\n", serverURL)
+	return nil
+}
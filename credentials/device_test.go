@@ -0,0 +1,108 @@
+package credentials
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAuthorizationServer starts an OpenID Connect provider supporting
+// the device authorization grant, which authorizes the device on the
+// second poll. With refresh unset, it issues no refresh token.
+func newTestAuthorizationServer(t *testing.T, refresh bool) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"issuer": %q, "token_endpoint": "%s/token", "device_authorization_endpoint": "%s/device"}`, server.URL, server.URL, server.URL)
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "cli" || r.FormValue("scope") != "openid offline_access" {
+			http.Error(w, `{"error": "invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"device_code": "device", "user_code": "ABCD-EFGH", "verification_uri": "https://sso.example.com/device", "verification_uri_complete": "https://sso.example.com/device?code=ABCD-EFGH", "expires_in": 600, "interval": 5}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("device_code") != "device" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		if polls++; polls < 2 {
+			http.Error(w, `{"error": "authorization_pending"}`, http.StatusBadRequest)
+			return
+		}
+		if refresh {
+			_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 300}`))
+		} else {
+			_, _ = w.Write([]byte(`{"access_token": "access"}`))
+		}
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestManageLoginDevice(t *testing.T) {
+	server := newTestAuthorizationServer(t, true)
+	h := newMemoryStore()
+
+	m, out, errOut := newTestManager(h, "", false, "")
+	m.devicePollInterval = time.Millisecond
+	if err := m.login([]string{"--device", "--issuer", server.URL, "--client-id", "cli", "--scope", "openid offline_access", "-u", "robot", "registry.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errOut.String(), "open https://sso.example.com/device and enter the code ABCD-EFGH") {
+		t.Errorf("expected user code to be printed, got:\n%s", errOut.String())
+	}
+	if out.String() != "Stored credentials for registry.example.com\n" {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+	username, secret, _ := h.Get("registry.example.com")
+	token, ok := parseOAuth2Secret(secret)
+	if username != "robot" || !ok {
+		t.Fatalf("expected OAuth2 credentials, got %s:%s", username, secret)
+	}
+	if token.TokenURL != server.URL+"/token" || token.ClientID != "cli" || token.RefreshToken != "refresh" || token.AccessToken != "access" || time.Until(token.Expiry) < 4*time.Minute {
+		t.Errorf("unexpected OAuth2 token: %+v", token)
+	}
+}
+
+func TestManageLoginDeviceWithoutRefreshToken(t *testing.T) {
+	server := newTestAuthorizationServer(t, false)
+	t.Setenv(envOAuth2Issuer, server.URL)
+	t.Setenv(envOAuth2ClientID, "cli")
+	h := newMemoryStore()
+
+	m, _, errOut := newTestManager(h, "robot\n", false, "")
+	m.devicePollInterval = time.Millisecond
+	if err := m.login([]string{"--device", "--scope", "openid offline_access", "registry.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(errOut.String(), "Username: ") {
+		t.Errorf("expected username prompt, got:\n%s", errOut.String())
+	}
+	if username, secret, _ := h.Get("registry.example.com"); username != "robot" || secret != "access" {
+		t.Errorf("expected access token to be stored, got %s:%s", username, secret)
+	}
+}
+
+func TestManageLoginDeviceErrors(t *testing.T) {
+	t.Setenv(envOAuth2Issuer, "")
+	t.Setenv(envOAuth2ClientID, "")
+	for _, args := range [][]string{
+		{"--device", "-u", "robot", "registry.example.com"},
+		{"--device", "--client-id", "cli", "-u", "robot", "registry.example.com"},
+		{"--device", "--password-stdin", "-u", "robot", "registry.example.com"},
+		{"--issuer", "https://sso.example.com", "-u", "robot", "--password-stdin", "registry.example.com"},
+	} {
+		m, _, _ := newTestManager(newMemoryStore(), "password\n", false, "")
+		if err := m.login(args); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
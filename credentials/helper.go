@@ -12,3 +12,23 @@ type Helper interface {
 	// List returns the stored serverURLs and their associated usernames.
 	List() (map[string]string, error)
 }
+
+// MultiAccountHelper is a Helper keeping several accounts per server URL.
+// Its List method returns the default account of each server URL, as
+// docker expects a single account per server URL.
+type MultiAccountHelper interface {
+	Helper
+	// ListAll is like List, but also returns the accounts other than the
+	// default account of each server URL, under their account server URL,
+	// see AccountServerURL.
+	ListAll() (map[string]string, error)
+}
+
+// listAll returns all the accounts kept by helper, with ListAll if it is a
+// MultiAccountHelper, or List otherwise.
+func listAll(helper Helper) (map[string]string, error) {
+	if h, ok := helper.(MultiAccountHelper); ok {
+		return h.ListAll()
+	}
+	return helper.List()
+}
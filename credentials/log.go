@@ -0,0 +1,58 @@
+package credentials
+
+import (
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envDebug is the environment variable enabling debug logging. It can be
+// set to "text" or "json" to select the output format, or to any true
+// value (see [strconv.ParseBool]) for text output.
+const envDebug = "DOCKER_CREDENTIAL_HELPERS_DEBUG"
+
+// logger is the logger used by the helpers for debug logging.
+var logger = loggerFromEnv(os.Stderr)
+
+// Logger returns the logger helpers use for debug logging. Unless debug
+// logging is enabled in the environment, or another logger is set with
+// SetLogger, it discards all messages.
+//
+// Messages must never include secrets.
+func Logger() *slog.Logger {
+	return logger
+}
+
+// SetLogger sets the logger returned by Logger. A nil logger discards all
+// messages.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = discardLogger()
+	}
+	logger = l
+}
+
+// loggerFromEnv creates a logger writing to w if debug logging is enabled
+// in the environment, or a logger discarding all messages otherwise. Debug
+// logging goes to stderr, as stdout is used by the protocol.
+func loggerFromEnv(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch v := strings.ToLower(os.Getenv(envDebug)); v {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts))
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts))
+	default:
+		if enabled, _ := strconv.ParseBool(v); enabled {
+			return slog.New(slog.NewTextHandler(w, opts))
+		}
+		return discardLogger()
+	}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt32)}))
+}
@@ -0,0 +1,64 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		enabled bool
+		json    bool
+	}{
+		{value: "", enabled: false},
+		{value: "0", enabled: false},
+		{value: "1", enabled: true},
+		{value: "true", enabled: true},
+		{value: "text", enabled: true},
+		{value: "JSON", enabled: true, json: true},
+	}
+
+	for _, tc := range tests {
+		t.Setenv(envDebug, tc.value)
+		buf := new(bytes.Buffer)
+		loggerFromEnv(buf).Debug("hello", "key", "value")
+
+		if !tc.enabled {
+			if buf.Len() != 0 {
+				t.Errorf("%q: expected no output, got %s", tc.value, buf.String())
+			}
+			continue
+		}
+		if tc.json {
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil || entry["key"] != "value" {
+				t.Errorf("%q: expected JSON output, got %s", tc.value, buf.String())
+			}
+		} else if !strings.Contains(buf.String(), "key=value") {
+			t.Errorf("%q: expected text output, got %s", tc.value, buf.String())
+		}
+	}
+}
+
+func TestLoggerOmitsSecrets(t *testing.T) {
+	buf := new(bytes.Buffer)
+	SetLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { SetLogger(nil) })
+
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "https://registry.example.com", Username: "foo", Secret: "topsecret"})
+	if err := HandleCommand(h, ActionGet, strings.NewReader("https://registry.example.com"), new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "registry.example.com") {
+		t.Errorf("expected server URL to be logged, got %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "topsecret") {
+		t.Errorf("expected secret not to be logged, got %s", buf.String())
+	}
+}
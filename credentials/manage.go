@@ -0,0 +1,673 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maskedSecret is shown in place of secrets, unless they are revealed.
+const maskedSecret = "********"
+
+// containersAuthFileUsage describes the default containers auth file in
+// usage messages.
+const containersAuthFileUsage = "$REGISTRY_AUTH_FILE or $XDG_RUNTIME_DIR/containers/auth.json"
+
+// manageCommands are the commands to manage credentials from a terminal,
+// served alongside the actions of the protocol used by docker. They run
+// the protocol actions through HandleCommand, so the policy and audit log
+// apply to them as well.
+var manageCommands = map[string]func(m *manager, args []string) error{
+	"ls":      (*manager).list,
+	"show":    (*manager).show,
+	"login":   (*manager).login,
+	"rm":      (*manager).remove,
+	"export":  (*manager).exportBundle,
+	"import":  (*manager).importBundle,
+	"migrate": (*manager).migrate,
+	"verify":  (*manager).verify,
+}
+
+// manager runs the management commands.
+type manager struct {
+	helper Helper
+	in     *bufio.Reader
+	out    io.Writer
+	errOut io.Writer
+
+	// terminal is whether in is a terminal, in which case readPassword
+	// reads from it without echo.
+	terminal     bool
+	readPassword func() (string, error)
+
+	// devicePollInterval, if set, overrides the interval at which the
+	// device login polls the authorization server.
+	devicePollInterval time.Duration
+}
+
+func newManager(helper Helper) *manager {
+	return &manager{
+		helper:       helper,
+		in:           bufio.NewReader(os.Stdin),
+		out:          os.Stdout,
+		errOut:       os.Stderr,
+		terminal:     isTerminal(os.Stdin),
+		readPassword: func() (string, error) { return readPassword(os.Stdin) },
+	}
+}
+
+// flags returns the flag set of a command. Errors are returned, not
+// printed, except for the usage requested with -h.
+func (m *manager) flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(m.errOut)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(m.errOut, strings.TrimSpace(fmt.Sprintf("Usage: %s %s [<options>] %s", Name, name, args)))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags anywhere in args, and returns the remaining
+// arguments if there are exactly n of them.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	return parseArgsRange(fs, args, n, n)
+}
+
+// parseArgsRange is like parseArgs, for commands taking between minArgs
+// and maxArgs arguments.
+func parseArgsRange(fs *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) < minArgs || len(positional) > maxArgs {
+		fs.Usage()
+		return nil, fmt.Errorf("%s: wrong number of arguments", fs.Name())
+	}
+	return positional, nil
+}
+
+// list prints the server URLs and usernames in the store, as a table or as
+// JSON. Every account of a server URL is listed, the default one first.
+func (m *manager) list(args []string) error {
+	fs := m.flags("ls", "")
+	jsonOutput := fs.Bool("json", false, "print as JSON")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	accounts, err := ListAccounts(commandHelper{m.helper})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		type entry struct {
+			ServerURL string
+			Username  string
+		}
+		entries := make([]entry, 0, len(accounts))
+		for _, a := range accounts {
+			entries = append(entries, entry{ServerURL: a.ServerURL, Username: a.Username})
+		}
+		enc := json.NewEncoder(m.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(m.out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SERVER URL\tUSERNAME")
+	for _, a := range accounts {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", a.ServerURL, a.Username)
+	}
+	return w.Flush()
+}
+
+// show prints the credentials for a server URL, with the secret masked
+// unless it is revealed.
+func (m *manager) show(args []string) error {
+	fs := m.flags("show", "<server-url>")
+	reveal := fs.Bool("reveal", false, "print the secret instead of masking it")
+	username := fs.String("username", "", "show the account of `username` instead of the default account")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	creds := Credentials{ServerURL: args[0]}
+	if creds.Username, creds.Secret, err = (commandHelper{m.helper}).Get(AccountServerURL(args[0], *username)); err != nil {
+		return err
+	}
+	creds.Secret, creds.Access = splitAccess(creds.Secret)
+	if !*reveal {
+		creds.Secret = maskedSecret
+	}
+
+	w := tabwriter.NewWriter(m.out, 0, 0, 1, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Server URL:\t%s\n", creds.ServerURL)
+	_, _ = fmt.Fprintf(w, "Username:\t%s\n", creds.Username)
+	if creds.Access != "" {
+		_, _ = fmt.Fprintf(w, "Access:\t%s\n", creds.Access)
+	}
+	_, _ = fmt.Fprintf(w, "Secret:\t%s\n", creds.Secret)
+	return w.Flush()
+}
+
+// login stores credentials for a server URL, prompting for the username
+// if not given, and for the password without echo unless it is read from
+// stdin.
+func (m *manager) login(args []string) error {
+	fs := m.flags("login", "<server-url>")
+	username := fs.String("username", "", "username, prompted for if not set")
+	fs.StringVar(username, "u", "", "shorthand for --username")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin")
+	var token OAuth2Token
+	fs.StringVar(&token.TokenURL, "token-url", "", "store the password as an OAuth2 refresh token, renewed at this token endpoint")
+	fs.StringVar(&token.ClientID, "client-id", "", "OAuth2 client ID to renew the access token with")
+	fs.StringVar(&token.Scope, "scope", "", "OAuth2 scope to renew the access token with")
+	device := fs.Bool("device", false, "log in with the OAuth2 device authorization grant instead of a password")
+	issuer := fs.String("issuer", "", "OpenID Connect issuer to log in with --device at (default $"+envOAuth2Issuer+")")
+	deviceURL := fs.String("device-url", "", "device authorization endpoint to log in with --device at, instead of the one of the issuer")
+	fs.StringVar(&token.Access, "access", "", "access scope of the credentials: "+AccessPull+" for read-only ones, or "+AccessPush)
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if err := validateAccess(token.Access); err != nil {
+		return err
+	}
+	if *device {
+		if *passwordStdin {
+			return errors.New("--device and --password-stdin cannot be used together")
+		}
+		if *username == "" {
+			if *username, err = m.promptUsername(); err != nil {
+				return err
+			}
+		}
+		return m.deviceLogin(args[0], *username, token, *issuer, *deviceURL)
+	}
+	if *issuer != "" || *deviceURL != "" {
+		return errors.New("--issuer and --device-url require --device")
+	}
+	if token.TokenURL == "" && (token.ClientID != "" || token.Scope != "") {
+		return errors.New("--client-id and --scope require --token-url or --device")
+	}
+	if !*passwordStdin && !m.terminal {
+		return errors.New("cannot prompt for the password: stdin is not a terminal, use --password-stdin")
+	}
+
+	if *username == "" {
+		if *passwordStdin {
+			return errors.New("--username is required with --password-stdin")
+		}
+		if *username, err = m.promptUsername(); err != nil {
+			return err
+		}
+	}
+
+	var password string
+	if *passwordStdin {
+		b, err := io.ReadAll(m.in)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	} else {
+		prompt := "Password: "
+		if token.TokenURL != "" {
+			prompt = "Refresh token: "
+		}
+		_, _ = fmt.Fprint(m.errOut, prompt)
+		password, err = m.readPassword()
+		_, _ = fmt.Fprintln(m.errOut)
+		if err != nil {
+			return err
+		}
+	}
+	if password == "" {
+		return errors.New("empty password")
+	}
+
+	creds := &Credentials{ServerURL: args[0], Username: *username, Secret: password}
+	if token.TokenURL != "" {
+		// Exchanging the refresh token right away reports invalid ones.
+		token.RefreshToken = password
+		if err := NewOAuth2(m.helper).refresh(&token); err != nil {
+			return err
+		}
+		if creds, err = NewOAuth2Credentials(args[0], *username, &token); err != nil {
+			return err
+		}
+	}
+	creds.Access = token.Access
+	if err := (commandHelper{m.helper}).Add(creds); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(m.out, "Stored credentials for %s\n", args[0])
+	return nil
+}
+
+// promptUsername prompts for a username on stdin.
+func (m *manager) promptUsername() (string, error) {
+	_, _ = fmt.Fprint(m.errOut, "Username: ")
+	line, err := m.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// remove erases the credentials for a server URL, after confirmation.
+func (m *manager) remove(args []string) error {
+	fs := m.flags("rm", "<server-url>")
+	force := fs.Bool("force", false, "do not ask for confirmation")
+	fs.BoolVar(force, "f", false, "shorthand for --force")
+	username := fs.String("username", "", "remove only the account of `username`")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	target := args[0]
+	if *username != "" {
+		target = *username + " on " + args[0]
+	}
+	if !*force {
+		_, _ = fmt.Fprintf(m.errOut, "Remove the credentials for %s? [y/N] ", target)
+		line, err := m.in.ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("aborted")
+		}
+		if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+			return errors.New("aborted")
+		}
+	}
+
+	if err := (commandHelper{m.helper}).Delete(AccountServerURL(args[0], *username)); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(m.out, "Removed credentials for %s\n", target)
+	return nil
+}
+
+// verify checks credentials against their registries, and prints the
+// outcome. It fails if any credentials are not valid.
+func (m *manager) verify(args []string) error {
+	fs := m.flags("verify", "[<server-url>]")
+	jsonOutput := fs.Bool("json", false, "print as JSON")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout to verify each credentials")
+	args, err := parseArgsRange(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	h := commandHelper{m.helper}
+	var serverURLs []string
+	if len(args) == 1 {
+		serverURLs = args
+	} else {
+		accts, err := h.ListAll()
+		if err != nil {
+			return err
+		}
+		for serverURL := range accts {
+			serverURLs = append(serverURLs, serverURL)
+		}
+		sort.Strings(serverURLs)
+	}
+
+	verifications := make([]Verification, 0, len(serverURLs))
+	failed := 0
+	for _, serverURL := range serverURLs {
+		creds := Credentials{ServerURL: serverURL}
+		if creds.Username, creds.Secret, err = h.Get(serverURL); err != nil {
+			// The credentials may have been removed in the meantime.
+			if IsErrCredentialsNotFound(err) && len(args) == 0 {
+				continue
+			}
+			return err
+		}
+		creds.Secret, creds.Access = splitAccess(creds.Secret)
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		v := VerifyCredentials(ctx, http.DefaultTransport, &creds)
+		cancel()
+		if v.Status != VerifyValid {
+			failed++
+		}
+		verifications = append(verifications, v)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(m.out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(verifications); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(m.out, 0, 0, 3, ' ', 0)
+		_, _ = fmt.Fprintln(w, "SERVER URL\tUSERNAME\tSTATUS\tDETAIL")
+		for _, v := range verifications {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.ServerURL, v.Username, v.Status, v.Detail)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d credentials failed verification", failed, len(verifications))
+	}
+	return nil
+}
+
+// exportBundle writes all the credentials to a bundle encrypted with a
+// passphrase or for age recipients, or to a containers auth file.
+func (m *manager) exportBundle(args []string) error {
+	fs := m.flags("export", "")
+	output := fs.String("output", "", "write the bundle to this file instead of stdout")
+	fs.StringVar(output, "o", "", "shorthand for --output")
+	var recipients stringList
+	fs.Var(&recipients, "recipient", "encrypt for this age recipient instead of a passphrase (can be repeated)")
+	passphraseFile := fs.String("passphrase-file", "", "read the passphrase from this file")
+	containersAuth := fs.Bool("containers-auth", false, "write to a containers auth file, such as podman's, instead of a bundle (default file "+containersAuthFileUsage+")")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *containersAuth {
+		if len(recipients) > 0 || *passphraseFile != "" {
+			return errors.New("containers auth files are not encrypted")
+		}
+		return m.exportContainersAuth(*output)
+	}
+
+	key := BundleKey{Recipients: recipients}
+	if len(recipients) == 0 {
+		var err error
+		if key.Passphrase, err = m.passphrase(*passphraseFile, true); err != nil {
+			return err
+		}
+	}
+	b, err := ExportBundle(commandHelper{m.helper})
+	if err != nil {
+		return err
+	}
+	data, err := SealBundle(b, key)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if *output == "" || *output == "-" {
+		_, err = m.out.Write(data)
+	} else {
+		err = os.WriteFile(*output, data, 0o600)
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(m.errOut, "Exported %d credentials\n", len(b.Credentials))
+	return nil
+}
+
+// exportContainersAuth adds all the credentials to the containers auth file
+// at path, or at the default location if path is empty.
+func (m *manager) exportContainersAuth(path string) error {
+	if path == "" {
+		var err error
+		if path, err = ContainersAuthFile(); err != nil {
+			return err
+		}
+	}
+	b, err := ExportBundle(commandHelper{m.helper})
+	if err != nil {
+		return err
+	}
+	// Refuse credentials the auth file cannot hold before writing any.
+	for _, creds := range b.Credentials {
+		creds := creds
+		if _, err := containersAuthSecret(&creds); err != nil {
+			return err
+		}
+	}
+	file := NewContainersAuth(path)
+	for _, creds := range b.Credentials {
+		creds := creds
+		if err := file.Add(&creds); err != nil {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(m.errOut, "Exported %d credentials to %s\n", len(b.Credentials), path)
+	return nil
+}
+
+// importBundle restores the credentials of a bundle, or of a containers
+// auth file, and prints the changes made, or to be made with --dry-run.
+func (m *manager) importBundle(args []string) error {
+	fs := m.flags("import", "<bundle>")
+	mode := fs.String("mode", ConflictMerge, "how to handle credentials already in the store: merge, overwrite or skip")
+	dryRun := fs.Bool("dry-run", false, "print the changes without making them")
+	var identities stringList
+	fs.Var(&identities, "identity", "decrypt with this age identity file (can be repeated)")
+	passphraseFile := fs.String("passphrase-file", "", "read the passphrase from this file")
+	containersAuth := fs.Bool("containers-auth", false, "import from a containers auth file, such as podman's, instead of a bundle (default file "+containersAuthFileUsage+")")
+	args, err := parseArgsRange(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	var b *Bundle
+	switch {
+	case *containersAuth:
+		path := ""
+		if len(args) > 0 {
+			path = args[0]
+		} else if path, err = ContainersAuthFile(); err != nil {
+			return err
+		}
+		b, err = ExportBundle(NewContainersAuth(path))
+	case len(args) == 0:
+		fs.Usage()
+		return fmt.Errorf("%s: wrong number of arguments", fs.Name())
+	default:
+		b, err = m.openBundle(args[0], BundleKey{Identities: identities}, *passphraseFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	changes, err := ImportBundle(commandHelper{m.helper}, b, *mode, *dryRun)
+	for _, change := range changes {
+		_, _ = fmt.Fprintln(m.out, change)
+	}
+	if err == nil && *dryRun {
+		_, _ = fmt.Fprintln(m.errOut, "Dry run: no changes made")
+	}
+	return err
+}
+
+// openBundle reads and decrypts the bundle at path, or on stdin if path is
+// "-", prompting for the passphrase if needed.
+func (m *manager) openBundle(path string, key BundleKey, passphraseFile string) (*Bundle, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(m.in)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	encryption, err := bundleEncryption(data)
+	if err != nil {
+		return nil, err
+	}
+	if encryption == sealPassphrase {
+		if key.Passphrase, err = m.passphrase(passphraseFile, false); err != nil {
+			return nil, err
+		}
+	}
+	return OpenBundle(data, key)
+}
+
+// migrate moves the plain text credentials of docker's config file into
+// the helper.
+func (m *manager) migrate(args []string) error {
+	fs := m.flags("migrate", "")
+	opts := MigrateOptions{HelperName: strings.TrimPrefix(Name, "docker-credential-")}
+	fs.StringVar(&opts.ConfigFile, "config", "", "path of docker's config file (default config.json in $DOCKER_CONFIG or ~/.docker)")
+	fs.BoolVar(&opts.CredHelpers, "cred-helpers", false, "use the helper for the migrated registries only, in credHelpers, instead of as credsStore")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the credentials to migrate without migrating them")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	result, err := MigrateDockerConfig(commandHelper{m.helper}, opts)
+	if err != nil {
+		return err
+	}
+	for _, creds := range result.Migrated {
+		_, _ = fmt.Fprintf(m.out, "%s (%s)\n", creds.ServerURL, creds.Username)
+	}
+	switch {
+	case len(result.Migrated) == 0:
+		_, _ = fmt.Fprintln(m.errOut, "No plain text credentials to migrate")
+	case opts.DryRun:
+		_, _ = fmt.Fprintln(m.errOut, "Dry run: no changes made")
+	default:
+		_, _ = fmt.Fprintf(m.errOut, "Migrated %d credentials, the previous config file is backed up in %s\n", len(result.Migrated), result.Backup)
+	}
+	return nil
+}
+
+// passphrase reads the passphrase from file, or prompts for it, twice if
+// confirm is true.
+func (m *manager) passphrase(file string, confirm bool) ([]byte, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if b = bytes.TrimRight(b, "\r\n"); len(b) == 0 {
+			return nil, errors.New("empty passphrase")
+		}
+		return b, nil
+	}
+	if !m.terminal {
+		return nil, errors.New("cannot prompt for the passphrase: stdin is not a terminal, use --passphrase-file")
+	}
+
+	_, _ = fmt.Fprint(m.errOut, "Passphrase: ")
+	passphrase, err := m.readPassword()
+	_, _ = fmt.Fprintln(m.errOut)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	if confirm {
+		_, _ = fmt.Fprint(m.errOut, "Confirm passphrase: ")
+		again, err := m.readPassword()
+		_, _ = fmt.Fprintln(m.errOut)
+		if err != nil {
+			return nil, err
+		}
+		if again != passphrase {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return []byte(passphrase), nil
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// commandHelper is a Helper running the actions through HandleCommand, so
+// that the policy and audit log apply to the management commands.
+type commandHelper struct {
+	helper Helper
+}
+
+func (h commandHelper) Add(creds *Credentials) error {
+	// Secrets are passed as stored by Get, with their access scope, while
+	// Store expects the access scope apart.
+	if creds.Access == "" {
+		c := *creds
+		c.Secret, c.Access = splitAccess(creds.Secret)
+		creds = &c
+	}
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	return HandleCommand(h.helper, ActionStore, bytes.NewReader(b), io.Discard)
+}
+
+func (h commandHelper) Delete(serverURL string) error {
+	return HandleCommand(h.helper, ActionErase, strings.NewReader(serverURL), io.Discard)
+}
+
+func (h commandHelper) Get(serverURL string) (string, string, error) {
+	buf := new(bytes.Buffer)
+	if err := HandleCommand(h.helper, ActionGet, strings.NewReader(serverURL), buf); err != nil {
+		return "", "", err
+	}
+	var creds Credentials
+	if err := json.Unmarshal(buf.Bytes(), &creds); err != nil {
+		return "", "", err
+	}
+	// The secret is returned as stored, so that the access scope is kept
+	// when the credentials are exported or migrated.
+	return creds.Username, withAccess(creds.Secret, creds.Access), nil
+}
+
+func (h commandHelper) List() (map[string]string, error) {
+	return h.list(ActionList)
+}
+
+func (h commandHelper) ListAll() (map[string]string, error) {
+	return h.list(ActionListAll)
+}
+
+// list runs action, ActionList or ActionListAll.
+func (h commandHelper) list(action Action) (map[string]string, error) {
+	buf := new(bytes.Buffer)
+	if err := HandleCommand(h.helper, action, strings.NewReader(""), buf); err != nil {
+		return nil, err
+	}
+	var accts map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &accts); err != nil {
+		return nil, err
+	}
+	return accts, nil
+}
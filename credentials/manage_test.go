@@ -0,0 +1,465 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestManager returns a manager reading input, and the buffers its
+// output and prompts are written to. If terminal is true, input is
+// assumed to be a terminal, from which password is read without echo.
+func newTestManager(helper Helper, input string, terminal bool, password string) (*manager, *bytes.Buffer, *bytes.Buffer) {
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	return &manager{
+		helper:       helper,
+		in:           bufio.NewReader(strings.NewReader(input)),
+		out:          out,
+		errOut:       errOut,
+		terminal:     terminal,
+		readPassword: func() (string, error) { return password, nil },
+	}, out, errOut
+}
+
+func TestManageList(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "https://registry.example.com", Username: "foo", Secret: "bar"})
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "baz"})
+
+	m, out, _ := newTestManager(h, "", true, "")
+	if err := m.list(nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "SERVER URL                     USERNAME\nghcr.io                        octocat\nhttps://registry.example.com   foo\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	m, out, _ = newTestManager(h, "", true, "")
+	if err := m.list([]string{"--json"}); err != nil {
+		t.Fatal(err)
+	}
+	var entries []map[string]string
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	expectedEntries := []map[string]string{
+		{"ServerURL": "ghcr.io", "Username": "octocat"},
+		{"ServerURL": "https://registry.example.com", "Username": "foo"},
+	}
+	if !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("expected %v, got %v", expectedEntries, entries)
+	}
+	if strings.Contains(out.String(), "bar") {
+		t.Error("expected no secret in list")
+	}
+
+	m, _, _ = newTestManager(h, "", true, "")
+	if err := m.list([]string{"extra"}); err == nil {
+		t.Error("expected error with extra argument")
+	}
+}
+
+func TestManageShow(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"})
+
+	m, out, _ := newTestManager(h, "", true, "")
+	if err := m.show([]string{"ghcr.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Server URL: ghcr.io\nUsername:   octocat\nSecret:     ********\n"; out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	m, out, _ = newTestManager(h, "", true, "")
+	if err := m.show([]string{"ghcr.io", "--reveal"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Secret:     hunter2\n") {
+		t.Errorf("expected revealed secret, got:\n%s", out.String())
+	}
+
+	m, _, _ = newTestManager(h, "", true, "")
+	if err := m.show([]string{"missing.example.com"}); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+}
+
+func TestManageLogin(t *testing.T) {
+	h := newMemoryStore()
+
+	m, out, errOut := newTestManager(h, "octocat\n", true, "hunter2")
+	if err := m.login([]string{"ghcr.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if username, secret, err := h.Get("ghcr.io"); err != nil || username != "octocat" || secret != "hunter2" {
+		t.Errorf("expected stored credentials, got %s:%s, %v", username, secret, err)
+	}
+	if errOut.String() != "Username: Password: \n" {
+		t.Errorf("unexpected prompts %q", errOut.String())
+	}
+	if strings.Contains(out.String()+errOut.String(), "hunter2") {
+		t.Error("expected password not to be printed")
+	}
+
+	m, _, _ = newTestManager(h, "s3cret\n", false, "")
+	if err := m.login([]string{"--username", "foo", "--password-stdin", "registry.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if username, secret, err := h.Get("registry.example.com"); err != nil || username != "foo" || secret != "s3cret" {
+		t.Errorf("expected stored credentials, got %s:%s, %v", username, secret, err)
+	}
+
+	m, _, _ = newTestManager(h, "foo\nbar\n", false, "")
+	if err := m.login([]string{"other.example.com"}); err == nil {
+		t.Error("expected error prompting without terminal")
+	}
+	m, _, _ = newTestManager(h, "bar\n", false, "")
+	if err := m.login([]string{"--password-stdin", "other.example.com"}); err == nil {
+		t.Error("expected error without username")
+	}
+	m, _, _ = newTestManager(h, "foo\n", true, "")
+	if err := m.login([]string{"other.example.com"}); err == nil {
+		t.Error("expected error with empty password")
+	}
+	if _, _, err := h.Get("other.example.com"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected no credentials stored, got %v", err)
+	}
+}
+
+func TestManageRemove(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"})
+
+	for _, input := range []string{"", "n\n", "nope\n"} {
+		m, _, errOut := newTestManager(h, input, true, "")
+		if err := m.remove([]string{"ghcr.io"}); err == nil {
+			t.Errorf("expected removal to be aborted with %q", input)
+		}
+		if !strings.Contains(errOut.String(), "Remove the credentials for ghcr.io? [y/N]") {
+			t.Errorf("expected confirmation prompt, got %q", errOut.String())
+		}
+	}
+	if _, _, err := h.Get("ghcr.io"); err != nil {
+		t.Fatalf("expected credentials to be kept, got %v", err)
+	}
+
+	m, _, _ := newTestManager(h, "y\n", true, "")
+	if err := m.remove([]string{"ghcr.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.Get("ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials to be removed, got %v", err)
+	}
+
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"})
+	m, _, errOut := newTestManager(h, "", false, "")
+	if err := m.remove([]string{"-f", "ghcr.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("expected no prompt with -f, got %q", errOut.String())
+	}
+	if _, _, err := h.Get("ghcr.io"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials to be removed, got %v", err)
+	}
+}
+
+func TestManageAccounts(t *testing.T) {
+	h := newAccountStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "robot", Secret: "robot-secret"})
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"})
+
+	m, out, _ := newTestManager(h, "", true, "")
+	if err := m.list(nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "SERVER URL   USERNAME\nghcr.io      octocat\nghcr.io      robot\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	m, out, _ = newTestManager(h, "", true, "")
+	if err := m.show([]string{"--username", "robot", "--reveal", "ghcr.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Username:   robot") || !strings.Contains(out.String(), "robot-secret") {
+		t.Errorf("expected robot account, got:\n%s", out.String())
+	}
+
+	m, out, errOut := newTestManager(h, "y\n", true, "")
+	if err := m.remove([]string{"--username", "octocat", "ghcr.io"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errOut.String(), "Remove the credentials for octocat on ghcr.io? [y/N]") {
+		t.Errorf("expected confirmation prompt, got %q", errOut.String())
+	}
+	if out.String() != "Removed credentials for octocat on ghcr.io\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if username, _, err := h.Get("ghcr.io"); err != nil || username != "robot" {
+		t.Errorf("expected robot account to be left, got %s, %v", username, err)
+	}
+}
+
+func TestManageReadOnly(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"})
+
+	m, _, _ := newTestManager(ReadOnly(h), "", false, "")
+	if err := m.remove([]string{"--force", "ghcr.io"}); !IsErrReadOnly(err) {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+}
+
+func TestManageExportImport(t *testing.T) {
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(dir, "bundle.json")
+
+	src := newMemoryStore()
+	_ = src.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"})
+	_ = src.Add(&Credentials{ServerURL: "registry.example.com", Username: "foo", Secret: "bar"})
+
+	m, _, errOut := newTestManager(src, "", false, "")
+	if err := m.exportBundle([]string{"--passphrase-file", passphraseFile, "-o", bundle}); err != nil {
+		t.Fatal(err)
+	}
+	if errOut.String() != "Exported 2 credentials\n" {
+		t.Errorf("unexpected output %q", errOut.String())
+	}
+
+	dst := newMemoryStore()
+	_ = dst.Add(&Credentials{ServerURL: "ghcr.io", Username: "other", Secret: "secret"})
+
+	// The passphrase is prompted for on a terminal.
+	m, out, errOut := newTestManager(dst, "", true, "correct horse")
+	if err := m.importBundle([]string{bundle, "--dry-run", "--mode", "skip"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "! ghcr.io (keeping other)\n+ registry.example.com (foo)\n"; out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+	if errOut.String() != "Passphrase: \nDry run: no changes made\n" {
+		t.Errorf("unexpected output %q", errOut.String())
+	}
+	if _, _, err := dst.Get("registry.example.com"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected no change with --dry-run, got %v", err)
+	}
+
+	m, out, _ = newTestManager(dst, "", false, "")
+	if err := m.importBundle([]string{"--passphrase-file", passphraseFile, bundle}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "~ ghcr.io (other -> octocat)\n+ registry.example.com (foo)\n"; out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+	if contents := storeContents(t, dst); !reflect.DeepEqual(contents, storeContents(t, src)) {
+		t.Errorf("expected imported credentials, got %v", contents)
+	}
+
+	m, _, _ = newTestManager(dst, "", true, "wrong horse")
+	if err := m.importBundle([]string{bundle}); err == nil {
+		t.Error("expected error with wrong passphrase")
+	}
+	m, _, _ = newTestManager(dst, "", false, "")
+	if err := m.importBundle([]string{bundle}); err == nil {
+		t.Error("expected error prompting for passphrase without terminal")
+	}
+}
+
+func TestManagePassphrase(t *testing.T) {
+	m, _, errOut := newTestManager(newMemoryStore(), "", true, "correct horse")
+	if passphrase, err := m.passphrase("", true); err != nil || string(passphrase) != "correct horse" {
+		t.Errorf("expected passphrase, got %q, %v", passphrase, err)
+	}
+	if errOut.String() != "Passphrase: \nConfirm passphrase: \n" {
+		t.Errorf("unexpected prompts %q", errOut.String())
+	}
+
+	answers := []string{"correct horse", "wrong horse"}
+	m.readPassword = func() (string, error) {
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+	if _, err := m.passphrase("", true); err == nil {
+		t.Error("expected error with mismatching passphrases")
+	}
+
+	m, _, _ = newTestManager(newMemoryStore(), "", true, "")
+	if _, err := m.passphrase("", false); err == nil {
+		t.Error("expected error with empty passphrase")
+	}
+}
+
+func TestManageMigrate(t *testing.T) {
+	path := writeDockerConfig(t, `{"auths": {"ghcr.io": {"auth": "b2N0b2NhdDpodW50ZXIy"}}}`)
+	t.Setenv("DOCKER_CONFIG", filepath.Dir(path))
+	h := newMemoryStore()
+
+	m, _, _ := newTestManager(h, "", false, "")
+	if err := m.migrate(nil); err == nil {
+		t.Error("expected error without helper name")
+	}
+
+	defer func(name string) { Name = name }(Name)
+	Name = "docker-credential-test"
+	m, out, errOut := newTestManager(h, "", false, "")
+	if err := m.migrate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "ghcr.io (octocat)\n" || !strings.HasPrefix(errOut.String(), "Migrated 1 credentials") {
+		t.Errorf("unexpected output %q, %q", out.String(), errOut.String())
+	}
+	if config := readDockerConfig(t, path); config["credsStore"] != "test" {
+		t.Errorf("expected helper to be set as credsStore, got %v", config)
+	}
+}
+
+func TestManageContainersAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(containersAuthJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_AUTH_FILE", path)
+
+	h := newMemoryStore()
+	m, out, _ := newTestManager(h, "", false, "")
+	if err := m.importBundle([]string{"--containers-auth", "--dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "+ " + dockerHubServerURL + " (foo)\n+ quay.io (quay)\n+ quay.io/org/repo (robot)\n+ registry.example.com (<token>)\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	m, _, _ = newTestManager(h, "", false, "")
+	if err := m.importBundle([]string{"--containers-auth", path}); err != nil {
+		t.Fatal(err)
+	}
+	if username, secret, err := h.Get("quay.io/org/repo"); err != nil || username != "robot" || secret != "repo-secret" {
+		t.Errorf("expected imported credentials, got %s:%s, %v", username, secret, err)
+	}
+
+	exported := filepath.Join(t.TempDir(), "exported.json")
+	m, _, _ = newTestManager(h, "", false, "")
+	if err := m.exportBundle([]string{"--containers-auth", "-o", exported}); err != nil {
+		t.Fatal(err)
+	}
+	if contents, expected := storeContents(t, NewContainersAuth(exported)), storeContents(t, NewContainersAuth(path)); !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected %v, got %v", expected, contents)
+	}
+
+	m, _, _ = newTestManager(h, "", false, "")
+	if err := m.exportBundle([]string{"--containers-auth", "--recipient", "age1alice"}); err == nil {
+		t.Error("expected error encrypting a containers auth file")
+	}
+}
+
+func TestManageExportContainersAuthAccess(t *testing.T) {
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "reader", Secret: withAccess("secret", AccessPull)})
+
+	exported := filepath.Join(t.TempDir(), "exported.json")
+	m, _, _ := newTestManager(h, "", false, "")
+	if err := m.exportBundle([]string{"--containers-auth", "-o", exported}); err != nil {
+		t.Fatal(err)
+	}
+	if username, secret, err := NewContainersAuth(exported).Get("ghcr.io"); err != nil || username != "reader" || secret != "secret" {
+		t.Errorf("expected pull-only secret without its marker, got %s:%s, %v", username, secret, err)
+	}
+
+	creds, err := NewOAuth2Credentials("registry.example.com", "robot", &OAuth2Token{TokenURL: "https://auth.example.com/token", RefreshToken: "refresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = h.Add(creds)
+	exported = filepath.Join(t.TempDir(), "exported.json")
+	m, _, _ = newTestManager(h, "", false, "")
+	if err := m.exportBundle([]string{"--containers-auth", "-o", exported}); err == nil || !strings.Contains(err.Error(), "refresh token") {
+		t.Errorf("expected OAuth2 credentials to be refused, got %v", err)
+	}
+	if _, err := os.Stat(exported); !os.IsNotExist(err) {
+		t.Errorf("expected no auth file to be written, got %v", err)
+	}
+}
+
+func TestManageVerify(t *testing.T) {
+	registry := newTestRegistry(t, false)
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: registry.URL, Username: "foo", Secret: "bar"})
+
+	m, out, _ := newTestManager(h, "", false, "")
+	if err := m.verify([]string{registry.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "foo        valid") {
+		t.Errorf("expected valid credentials, got:\n%s", out.String())
+	}
+
+	basic := newTestRegistry(t, true)
+	_ = h.Add(&Credentials{ServerURL: basic.URL, Username: "foo", Secret: "wrong"})
+	m, out, _ = newTestManager(h, "", false, "")
+	err := m.verify([]string{"--json"})
+	if err == nil || err.Error() != "1 of 2 credentials failed verification" {
+		t.Errorf("expected verification failure, got %v", err)
+	}
+	var verifications []Verification
+	if err := json.Unmarshal(out.Bytes(), &verifications); err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]string{}
+	for _, v := range verifications {
+		statuses[v.ServerURL] = v.Status
+	}
+	if expected := map[string]string{registry.URL: VerifyValid, basic.URL: VerifyInvalid}; !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected %v, got %v", expected, statuses)
+	}
+	if strings.Contains(out.String(), "wrong") {
+		t.Errorf("expected secrets not to be printed, got:\n%s", out.String())
+	}
+
+	m, _, _ = newTestManager(h, "", false, "")
+	if err := m.verify([]string{"missing.example.com"}); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+}
+
+func TestManageLoginOAuth2(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	server, _ := newTestTokenEndpoint(t)
+	h := newMemoryStore()
+
+	m, _, _ := newTestManager(h, "refresh-1\n", false, "")
+	if err := m.login([]string{"--token-url", server.URL, "--client-id", "cli", "-u", "robot", "--password-stdin", "registry.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	username, secret, _ := h.Get("registry.example.com")
+	token, ok := parseOAuth2Secret(secret)
+	if username != "robot" || !ok || token.RefreshToken != "refresh-2" || token.AccessToken != "access-1" {
+		t.Errorf("expected renewed OAuth2 token to be stored, got %s:%s", username, secret)
+	}
+
+	m, _, _ = newTestManager(h, "refresh-1\n", false, "")
+	if err := m.login([]string{"--token-url", server.URL, "--client-id", "cli", "-u", "robot", "--password-stdin", "other.example.com"}); err == nil {
+		t.Error("expected error with used refresh token")
+	}
+	if _, _, err := h.Get("other.example.com"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected invalid refresh token not to be stored, got %v", err)
+	}
+
+	m, _, _ = newTestManager(h, "password\n", false, "")
+	if err := m.login([]string{"--client-id", "cli", "-u", "robot", "--password-stdin", "other.example.com"}); err == nil {
+		t.Error("expected error with --client-id without --token-url")
+	}
+}
@@ -0,0 +1,212 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/internal/fsutil"
+)
+
+// tokenUsername is the username docker stores identity tokens with.
+const tokenUsername = "<token>"
+
+// MigrateOptions configures MigrateDockerConfig.
+type MigrateOptions struct {
+	// ConfigFile is the path of docker's config file. If empty, it is
+	// config.json in $DOCKER_CONFIG, or in ~/.docker.
+	ConfigFile string
+
+	// HelperName is the name docker knows the helper by, that is the name
+	// of its program without the docker-credential- prefix.
+	HelperName string
+
+	// CredHelpers sets the helper for the migrated registries in
+	// credHelpers, instead of setting it as credsStore for all registries.
+	CredHelpers bool
+
+	// DryRun only reports the credentials to migrate, without storing them
+	// or rewriting the config file.
+	DryRun bool
+}
+
+// MigrateResult is the outcome of MigrateDockerConfig.
+type MigrateResult struct {
+	// Migrated holds the server URLs and usernames of the migrated
+	// credentials, without their secrets.
+	Migrated []Credentials
+	// Backup is the path of the backup of the config file, if it was
+	// rewritten.
+	Backup string
+}
+
+// DockerConfigFile returns the path of docker's config file: config.json in
+// the directory set in the DOCKER_CONFIG environment variable or, if not
+// set, in ~/.docker.
+func DockerConfigFile() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// MigrateDockerConfig moves the credentials stored in plain text in the
+// auths of docker's config file into helper. It then configures docker to
+// use the helper, and strips the plain text credentials from the config
+// file, after backing it up.
+func MigrateDockerConfig(helper Helper, opts MigrateOptions) (*MigrateResult, error) {
+	if opts.HelperName == "" {
+		return nil, errors.New("missing helper name")
+	}
+	path := opts.ConfigFile
+	if path == "" {
+		var err error
+		if path, err = DockerConfigFile(); err != nil {
+			return nil, err
+		}
+	}
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(original, &config); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %w", path, err)
+	}
+	var auths map[string]map[string]json.RawMessage
+	var credsStore string
+	credHelpers := map[string]string{}
+	for key, v := range map[string]any{"auths": &auths, "credsStore": &credsStore, "credHelpers": &credHelpers} {
+		if raw, ok := config[key]; ok {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return nil, fmt.Errorf("invalid %s in docker config %s: %w", key, path, err)
+			}
+		}
+	}
+	if !opts.CredHelpers && credsStore != "" && credsStore != opts.HelperName {
+		return nil, fmt.Errorf("docker config %s already uses the %s credentials store", path, credsStore)
+	}
+
+	registries := make([]string, 0, len(auths))
+	for registry := range auths {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	result := &MigrateResult{}
+	for _, registry := range registries {
+		creds, err := plaintextCredentials(registry, auths[registry])
+		if err != nil {
+			return nil, fmt.Errorf("invalid credentials for %s in docker config %s: %w", registry, path, err)
+		}
+		if creds == nil {
+			continue
+		}
+		if h, ok := credHelpers[registry]; ok && h != opts.HelperName {
+			return nil, fmt.Errorf("docker config %s uses the %s credentials helper for %s", path, h, registry)
+		}
+		if !opts.DryRun {
+			if err := helper.Add(creds); err != nil {
+				return nil, fmt.Errorf("migrating credentials for %s: %w", registry, err)
+			}
+		}
+		result.Migrated = append(result.Migrated, Credentials{ServerURL: creds.ServerURL, Username: creds.Username})
+
+		for _, field := range []string{"auth", "identitytoken", "username", "password"} {
+			delete(auths[registry], field)
+		}
+		if opts.CredHelpers {
+			credHelpers[registry] = opts.HelperName
+		}
+	}
+	if opts.DryRun || len(result.Migrated) == 0 {
+		return result, nil
+	}
+
+	if opts.CredHelpers {
+		if config["credHelpers"], err = json.Marshal(credHelpers); err != nil {
+			return nil, err
+		}
+	} else if config["credsStore"], err = json.Marshal(opts.HelperName); err != nil {
+		return nil, err
+	}
+	if config["auths"], err = json.Marshal(auths); err != nil {
+		return nil, err
+	}
+	updated, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Backup, err = backupFile(path, original); err != nil {
+		return nil, fmt.Errorf("backing up docker config %s: %w", path, err)
+	}
+	if err := fsutil.WriteFileAtomic(path, append(updated, '\n')); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// plaintextCredentials returns the credentials held in plain text in an
+// entry of the auths of docker's config file, or nil if it has none.
+func plaintextCredentials(registry string, entry map[string]json.RawMessage) (*Credentials, error) {
+	fields := map[string]string{}
+	for _, field := range []string{"auth", "identitytoken", "username", "password"} {
+		if raw, ok := entry[field]; ok {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", field, err)
+			}
+			fields[field] = s
+		}
+	}
+
+	creds := &Credentials{ServerURL: registry, Username: fields["username"], Secret: fields["password"]}
+	if auth := fields["auth"]; auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth: %w", err)
+		}
+		var ok bool
+		if creds.Username, creds.Secret, ok = strings.Cut(string(decoded), ":"); !ok {
+			return nil, errors.New("invalid auth: missing password")
+		}
+	}
+	// Identity tokens take precedence, as docker does.
+	if token := fields["identitytoken"]; token != "" {
+		creds.Username, creds.Secret = tokenUsername, token
+	}
+	if creds.Username == "" || creds.Secret == "" {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// backupFile writes data to a backup of path, which does not overwrite any
+// existing backup, and returns the path of the backup.
+func backupFile(path string, data []byte) (string, error) {
+	backup := path + ".bak"
+	if _, err := os.Lstat(backup); err == nil {
+		backup = path + "." + time.Now().UTC().Format("20060102T150405Z") + ".bak"
+	}
+	f, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	return backup, f.Close()
+}
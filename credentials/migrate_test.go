@@ -0,0 +1,175 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const plaintextConfig = `{
+	"auths": {
+		"https://index.docker.io/v1/": {
+			"auth": "Zm9vOmJhcg=="
+		},
+		"ghcr.io": {
+			"auth": "b2N0b2NhdDpodW50ZXIy",
+			"email": "octocat@example.com"
+		},
+		"registry.example.com": {
+			"auth": "Og==",
+			"identitytoken": "refresh-token"
+		},
+		"stored.example.com": {}
+	},
+	"psFormat": "table {{.ID}}"
+}`
+
+func writeDockerConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readDockerConfig(t *testing.T, path string) map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestMigrateDockerConfig(t *testing.T) {
+	path := writeDockerConfig(t, plaintextConfig)
+	h := newMemoryStore()
+
+	result, err := MigrateDockerConfig(h, MigrateOptions{ConfigFile: path, HelperName: "pass", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Credentials{
+		{ServerURL: "ghcr.io", Username: "octocat"},
+		{ServerURL: "https://index.docker.io/v1/", Username: "foo"},
+		{ServerURL: "registry.example.com", Username: tokenUsername},
+	}
+	if !reflect.DeepEqual(result.Migrated, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result.Migrated)
+	}
+	if b, _ := os.ReadFile(path); string(b) != plaintextConfig {
+		t.Error("expected config to be left untouched by a dry run")
+	}
+	if accts, _ := h.List(); len(accts) != 0 {
+		t.Errorf("expected no credentials stored by a dry run, got %v", accts)
+	}
+
+	result, err = MigrateDockerConfig(h, MigrateOptions{ConfigFile: path, HelperName: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Migrated, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result.Migrated)
+	}
+	if b, err := os.ReadFile(result.Backup); err != nil || string(b) != plaintextConfig {
+		t.Errorf("expected backup of the original config, got %v", err)
+	}
+
+	expectedContents := map[string]string{
+		"ghcr.io":                     "octocat:hunter2",
+		"https://index.docker.io/v1/": "foo:bar",
+		"registry.example.com":        "<token>:refresh-token",
+	}
+	if contents := storeContents(t, h); !reflect.DeepEqual(contents, expectedContents) {
+		t.Errorf("expected %v, got %v", expectedContents, contents)
+	}
+
+	expectedConfig := map[string]any{
+		"auths": map[string]any{
+			"https://index.docker.io/v1/": map[string]any{},
+			"ghcr.io":                     map[string]any{"email": "octocat@example.com"},
+			"registry.example.com":        map[string]any{},
+			"stored.example.com":          map[string]any{},
+		},
+		"credsStore": "pass",
+		"psFormat":   "table {{.ID}}",
+	}
+	if config := readDockerConfig(t, path); !reflect.DeepEqual(config, expectedConfig) {
+		t.Errorf("expected %v, got %v", expectedConfig, config)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Errorf("expected private config file, got %v", info.Mode())
+	}
+
+	// Nothing is left to migrate.
+	result, err = MigrateDockerConfig(h, MigrateOptions{ConfigFile: path, HelperName: "pass"})
+	if err != nil || len(result.Migrated) != 0 || result.Backup != "" {
+		t.Errorf("expected nothing to migrate, got %+v, %v", result, err)
+	}
+}
+
+func TestMigrateDockerConfigCredHelpers(t *testing.T) {
+	path := writeDockerConfig(t, `{
+	"auths": {"ghcr.io": {"auth": "b2N0b2NhdDpodW50ZXIy"}},
+	"credsStore": "desktop",
+	"credHelpers": {"gcr.io": "gcloud"}
+}`)
+	if _, err := os.Create(path + ".bak"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newMemoryStore()
+	if _, err := MigrateDockerConfig(h, MigrateOptions{ConfigFile: path, HelperName: "pass"}); err == nil {
+		t.Fatal("expected error replacing another credentials store")
+	}
+	result, err := MigrateDockerConfig(h, MigrateOptions{ConfigFile: path, HelperName: "pass", CredHelpers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Backup == path+".bak" {
+		t.Error("expected existing backup to be kept")
+	}
+	expectedConfig := map[string]any{
+		"auths":       map[string]any{"ghcr.io": map[string]any{}},
+		"credsStore":  "desktop",
+		"credHelpers": map[string]any{"gcr.io": "gcloud", "ghcr.io": "pass"},
+	}
+	if config := readDockerConfig(t, path); !reflect.DeepEqual(config, expectedConfig) {
+		t.Errorf("expected %v, got %v", expectedConfig, config)
+	}
+}
+
+func TestMigrateDockerConfigInvalid(t *testing.T) {
+	for _, config := range []string{
+		`not json`,
+		`{"auths": {"ghcr.io": {"auth": "not base64"}}}`,
+		`{"auths": {"ghcr.io": {"auth": "bm9wYXNzd29yZA=="}}}`,
+		`{"auths": {"ghcr.io": {"auth": "b2N0b2NhdDpodW50ZXIy"}}, "credHelpers": {"ghcr.io": "gcloud"}}`,
+	} {
+		path := writeDockerConfig(t, config)
+		if _, err := MigrateDockerConfig(newMemoryStore(), MigrateOptions{ConfigFile: path, HelperName: "pass"}); err == nil {
+			t.Errorf("expected error migrating %s", config)
+		}
+		if b, _ := os.ReadFile(path); string(b) != config {
+			t.Errorf("expected config to be left untouched, got %s", b)
+		}
+	}
+}
+
+func TestDockerConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	if path, err := DockerConfigFile(); err != nil || path != filepath.Join(dir, "config.json") {
+		t.Errorf("expected config in DOCKER_CONFIG, got %s, %v", path, err)
+	}
+}
@@ -0,0 +1,80 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// notificationsName is the bus name and interface of the desktop
+	// notification service, see
+	// https://specifications.freedesktop.org/notification-spec/latest/.
+	notificationsName = "org.freedesktop.Notifications"
+	// notificationsPath is the object path of the notification service.
+	notificationsPath dbus.ObjectPath = "/org/freedesktop/Notifications"
+)
+
+// notificationTimeout bounds the time spent sending a notification, so that
+// an unresponsive notification service does not hold up get.
+const notificationTimeout = 2 * time.Second
+
+// notify sends a desktop notification that caller read the credentials of
+// username for serverURL, if a rule applying to get requests it. Failing to
+// send the notification does not fail the action, as notifications are
+// only meant to give visibility; failures are logged.
+func (p *Policy) notify(serverURL, username string, caller *Caller) {
+	if !p.notifies(serverURL) {
+		return
+	}
+	name := describeCaller(caller)
+	if caller != nil && caller.Executable != "" {
+		name = fmt.Sprintf("%s (%d)", filepath.Base(caller.Executable), caller.PID)
+	}
+	body := fmt.Sprintf("%s read the credentials for %s", name, serverURL)
+	if username != "" {
+		body = fmt.Sprintf("%s read the credentials of %s for %s", name, username, serverURL)
+	}
+	if err := sendNotification("Docker credentials read", body); err != nil {
+		Logger().Debug("cannot send notification", "serverURL", serverURL, "error", err)
+	}
+}
+
+// notifies reports whether a rule applying to get for serverURL requests a
+// notification.
+func (p *Policy) notifies(serverURL string) bool {
+	for _, rule := range p.Rules {
+		if rule.Notify && rule.appliesTo(ActionGet, serverURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendNotification sends a desktop notification to the
+// org.freedesktop.Notifications service on the session bus.
+func sendNotification(summary, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("cannot connect to the session bus: %w", err)
+	}
+	defer conn.Close()
+
+	appName := Name
+	if appName == "" {
+		appName = "docker-credential-helpers"
+	}
+	obj := conn.Object(notificationsName, notificationsPath)
+	call := obj.CallWithContext(ctx, notificationsName+".Notify", 0,
+		appName, uint32(0), "dialog-password", summary, body, []string{}, map[string]dbus.Variant{}, int32(-1))
+	if call.Err != nil {
+		return fmt.Errorf("cannot send notification: %w", call.Err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// startSessionBus starts a private session bus with dbus-daemon, and makes
+// it the session bus for the duration of the test.
+func startSessionBus(t *testing.T) {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	cmd := exec.Command(daemon, "--session", "--address=unix:dir="+t.TempDir(), "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read the address of the session bus: %v", err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+}
+
+// notification is a notification received by fakeNotifications.
+type notification struct {
+	AppName string
+	Summary string
+	Body    string
+}
+
+// fakeNotifications is a fake org.freedesktop.Notifications service
+// keeping the notifications it receives.
+type fakeNotifications struct {
+	mu            sync.Mutex
+	notifications []notification
+}
+
+func (f *fakeNotifications) Notify(appName string, replacesID uint32, appIcon, summary, body string, actions []string, hints map[string]dbus.Variant, expireTimeout int32) (uint32, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notifications = append(f.notifications, notification{AppName: appName, Summary: summary, Body: body})
+	return uint32(len(f.notifications)), nil
+}
+
+// received returns the notifications received so far.
+func (f *fakeNotifications) received() []notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]notification(nil), f.notifications...)
+}
+
+// setFakeNotifications starts a private session bus with a fake
+// notification service for the duration of the test.
+func setFakeNotifications(t *testing.T) *fakeNotifications {
+	t.Helper()
+	startSessionBus(t)
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	f := &fakeNotifications{}
+	if err := conn.Export(f, notificationsPath, notificationsName); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(notificationsName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot own %s: %v", notificationsName, reply)
+	}
+	return f
+}
+
+func TestHandleCommandNotify(t *testing.T) {
+	f := setFakeNotifications(t)
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"registries": ["*.prod.example.com"], "notify": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	h := newMemoryStore()
+	for _, serverURL := range []string{"registry.prod.example.com", "registry.dev.example.com"} {
+		_ = h.Add(&Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"})
+	}
+
+	out := new(bytes.Buffer)
+	if err := HandleCommand(h, ActionGet, strings.NewReader("registry.dev.example.com"), out); err != nil {
+		t.Fatal(err)
+	}
+	if err := HandleCommand(h, ActionGet, strings.NewReader("missing.prod.example.com"), out); !IsErrCredentialsNotFound(err) {
+		t.Fatalf("expected credentials not found, got %v", err)
+	}
+	if got := f.received(); len(got) != 0 {
+		t.Fatalf("expected no notification, got %+v", got)
+	}
+
+	if err := HandleCommand(h, ActionGet, strings.NewReader("registry.prod.example.com"), out); err != nil {
+		t.Fatal(err)
+	}
+	got := f.received()
+	if len(got) != 1 || got[0].AppName == "" || got[0].Summary != "Docker credentials read" {
+		t.Fatalf("unexpected notifications %+v", got)
+	}
+	caller := parentCaller()
+	name := filepath.Base(caller.Executable)
+	if caller.Executable == "" {
+		name = describeCaller(caller)
+	}
+	if !strings.Contains(got[0].Body, name) || !strings.Contains(got[0].Body, "foo for registry.prod.example.com") {
+		t.Errorf("expected notification to name the caller and registry, got %q", got[0].Body)
+	}
+}
+
+func TestHandleCommandNotifyWithoutSessionBus(t *testing.T) {
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "missing"))
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"notify": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "registry.example.com", Username: "foo", Secret: "bar"})
+	out := new(bytes.Buffer)
+	if err := HandleCommand(h, ActionGet, strings.NewReader("registry.example.com"), out); err != nil {
+		t.Errorf("expected get to succeed without a session bus, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package credentials
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/internal/fsutil"
+	"github.com/docker/docker-credential-helpers/internal/oauth2"
+)
+
+// oauth2SecretPrefix marks the secrets holding an OAuth2Token instead of a
+// password.
+const oauth2SecretPrefix = "oauth2:"
+
+// oauth2ExpiryLeeway is how long before their expiry access tokens are
+// renewed, so they are still valid when the registry receives them.
+const oauth2ExpiryLeeway = 30 * time.Second
+
+// oauth2Timeout bounds the time spent renewing an access token.
+const oauth2Timeout = 30 * time.Second
+
+// OAuth2Token is an OAuth2 refresh token, such as docker's identity tokens,
+// along with the token endpoint to exchange it for access tokens at, and
+// the last access token obtained.
+type OAuth2Token struct {
+	TokenURL     string    `json:"tokenURL"`
+	ClientID     string    `json:"clientID,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	RefreshToken string    `json:"refreshToken"`
+	AccessToken  string    `json:"accessToken,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	// Access is the access scope of the credentials, see
+	// Credentials.Access.
+	Access string `json:"access,omitempty"`
+	// Rotating is set once the authorization server has rotated the
+	// refresh token. Such tokens cannot be renewed in read-only mode, as
+	// the rotated refresh token could not be stored.
+	Rotating bool `json:"rotating,omitempty"`
+}
+
+// NewOAuth2Credentials returns the credentials to store for serverURL so
+// that OAuth2 returns username with access tokens renewed with token.
+func NewOAuth2Credentials(serverURL, username string, token *OAuth2Token) (*Credentials, error) {
+	if token.TokenURL == "" {
+		return nil, errors.New("missing token URL")
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.New("missing refresh token")
+	}
+	b, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{ServerURL: serverURL, Username: username, Secret: oauth2SecretPrefix + string(b)}, nil
+}
+
+// parseOAuth2Secret returns the OAuth2Token held in secret, if any.
+func parseOAuth2Secret(secret string) (*OAuth2Token, bool) {
+	data, ok := strings.CutPrefix(secret, oauth2SecretPrefix)
+	if !ok {
+		return nil, false
+	}
+	var token OAuth2Token
+	if err := json.Unmarshal([]byte(data), &token); err != nil || token.TokenURL == "" || token.RefreshToken == "" {
+		return nil, false
+	}
+	return &token, true
+}
+
+// OAuth2 is a Helper that returns access tokens in place of the OAuth2
+// tokens stored with NewOAuth2Credentials in another helper. Access tokens
+// are renewed with the refresh token grant when they expire, and the
+// renewed tokens, including rotated refresh tokens, are stored back into
+// the other helper. In read-only mode, renewed tokens are not stored, and
+// tokens whose authorization server rotates refresh tokens are not renewed,
+// as the rotated refresh token would be lost. Other credentials are
+// returned as they are.
+type OAuth2 struct {
+	helper Helper
+	client *http.Client
+	now    func() time.Time
+	// readOnly is set in read-only mode, where renewed tokens are not
+	// stored back.
+	readOnly bool
+}
+
+// NewOAuth2 wraps helper to renew the access tokens of its OAuth2 tokens.
+func NewOAuth2(helper Helper) *OAuth2 {
+	return &OAuth2{helper: helper, client: &http.Client{Timeout: oauth2Timeout}, now: time.Now}
+}
+
+// Add stores credentials in the wrapped helper.
+func (o *OAuth2) Add(creds *Credentials) error {
+	return o.helper.Add(creds)
+}
+
+// Delete removes credentials from the wrapped helper.
+func (o *OAuth2) Delete(serverURL string) error {
+	return o.helper.Delete(serverURL)
+}
+
+// Get returns the credentials for serverURL from the wrapped helper, with
+// a valid access token in place of OAuth2 tokens.
+func (o *OAuth2) Get(serverURL string) (string, string, error) {
+	username, secret, err := o.helper.Get(serverURL)
+	if err != nil {
+		return username, secret, err
+	}
+	token, ok := parseOAuth2Secret(secret)
+	if !ok {
+		return username, secret, nil
+	}
+	if o.valid(token) {
+		return username, withAccess(token.AccessToken, token.Access), nil
+	}
+
+	// Refresh tokens may only be used once, so concurrent helpers must not
+	// renew the same token.
+	unlock, err := lockOAuth2(serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer unlock()
+	if username, secret, err = o.helper.Get(serverURL); err != nil {
+		return username, secret, err
+	}
+	if token, ok = parseOAuth2Secret(secret); !ok {
+		return username, secret, nil
+	}
+	if o.valid(token) {
+		return username, withAccess(token.AccessToken, token.Access), nil
+	}
+
+	if o.readOnly && token.Rotating {
+		return "", "", fmt.Errorf("renewing access token for %s: the authorization server rotates refresh tokens, which cannot be stored in read-only mode", serverURL)
+	}
+	refreshToken := token.RefreshToken
+	if err := o.refresh(token); err != nil {
+		return "", "", fmt.Errorf("renewing access token for %s: %w", serverURL, err)
+	}
+	if o.readOnly {
+		// The stored refresh token has been used, and may no longer be
+		// accepted.
+		if token.RefreshToken != refreshToken {
+			return "", "", fmt.Errorf("renewing access token for %s: the authorization server rotated the refresh token, which cannot be stored in read-only mode: log in again", serverURL)
+		}
+		Logger().Debug("renewed access token, not storing it in read-only mode", "serverURL", serverURL, "expiry", token.Expiry)
+		return username, withAccess(token.AccessToken, token.Access), nil
+	}
+	creds, err := NewOAuth2Credentials(serverURL, username, token)
+	if err != nil {
+		return "", "", err
+	}
+	if err := o.helper.Add(creds); err != nil {
+		return "", "", fmt.Errorf("storing renewed token for %s: %w", serverURL, err)
+	}
+	Logger().Debug("renewed access token", "serverURL", serverURL, "expiry", token.Expiry)
+	return username, withAccess(token.AccessToken, token.Access), nil
+}
+
+// List returns the credentials of the wrapped helper.
+func (o *OAuth2) List() (map[string]string, error) {
+	return o.helper.List()
+}
+
+// ListAll returns all the accounts of the wrapped helper, see
+// MultiAccountHelper.
+func (o *OAuth2) ListAll() (map[string]string, error) {
+	return listAll(o.helper)
+}
+
+// valid returns whether the access token of token can still be used.
+func (o *OAuth2) valid(token *OAuth2Token) bool {
+	return token.AccessToken != "" && o.now().Add(oauth2ExpiryLeeway).Before(token.Expiry)
+}
+
+// refresh renews the access token of token, and its refresh token if the
+// authorization server rotates them.
+func (o *OAuth2) refresh(token *OAuth2Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), oauth2Timeout)
+	defer cancel()
+	issued := o.now()
+	t, err := oauth2.Refresh(ctx, o.client, token.TokenURL, token.ClientID, token.RefreshToken, token.Scope)
+	if err != nil {
+		return err
+	}
+	token.AccessToken = t.AccessToken
+	// Access tokens without a lifetime are renewed on every use.
+	token.Expiry = t.Expiry(issued)
+	if t.RefreshToken != "" && t.RefreshToken != token.RefreshToken {
+		token.RefreshToken = t.RefreshToken
+		token.Rotating = true
+	}
+	return nil
+}
+
+// lockOAuth2 takes a lock, shared by all the helpers of the user, on the
+// renewal of the token of serverURL, and returns the function releasing it.
+func lockOAuth2(serverURL string) (func(), error) {
+	dir, err := fsutil.RuntimeDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(serverURL))
+	f, err := os.OpenFile(filepath.Join(dir, "oauth2-"+hex.EncodeToString(sum[:8])+".lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,225 @@
+package credentials
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestTokenEndpoint starts a token endpoint rotating refresh tokens:
+// refresh-N is exchanged once for access-N and refresh-N+1.
+func newTestTokenEndpoint(t *testing.T) (*httptest.Server, *int) {
+	t.Helper()
+	var mu sync.Mutex
+	used := map[string]bool{}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		var n int
+		refreshToken := r.FormValue("refresh_token")
+		if _, err := fmt.Sscanf(refreshToken, "refresh-%d", &n); err != nil || used[refreshToken] || r.FormValue("client_id") != "cli" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		used[refreshToken] = true
+		_, _ = fmt.Fprintf(w, `{"access_token": "access-%d", "refresh_token": "refresh-%d", "expires_in": 300}`, n, n+1)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestOAuth2(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	server, requests := newTestTokenEndpoint(t)
+
+	store := newMemoryStore()
+	creds, err := NewOAuth2Credentials("registry.example.com", "robot", &OAuth2Token{TokenURL: server.URL, ClientID: "cli", RefreshToken: "refresh-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Add(creds)
+	_ = store.Add(&Credentials{ServerURL: "ghcr.io", Username: "octocat", Secret: "password"})
+
+	now := time.Now()
+	o := NewOAuth2(store)
+	o.now = func() time.Time { return now }
+
+	for _, expected := range []struct {
+		elapsed  time.Duration
+		secret   string
+		refresh  string
+		requests int
+	}{
+		{elapsed: 0, secret: "access-1", refresh: "refresh-2", requests: 1},
+		{elapsed: 4 * time.Minute, secret: "access-1", refresh: "refresh-2", requests: 1},
+		{elapsed: 5 * time.Minute, secret: "access-2", refresh: "refresh-3", requests: 2},
+	} {
+		now = now.Add(expected.elapsed)
+		username, secret, err := o.Get("registry.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "robot" || secret != expected.secret {
+			t.Errorf("expected robot:%s, got %s:%s", expected.secret, username, secret)
+		}
+		if *requests != expected.requests {
+			t.Errorf("expected %d token requests, got %d", expected.requests, *requests)
+		}
+		_, stored, _ := store.Get("registry.example.com")
+		token, ok := parseOAuth2Secret(stored)
+		if !ok || token.RefreshToken != expected.refresh || token.AccessToken != expected.secret {
+			t.Errorf("expected %s and %s to be stored, got %+v", expected.refresh, expected.secret, token)
+		}
+	}
+
+	if username, secret, err := o.Get("ghcr.io"); err != nil || username != "octocat" || secret != "password" {
+		t.Errorf("expected other credentials to be returned as they are, got %s:%s, %v", username, secret, err)
+	}
+	if _, _, err := o.Get("missing.example.com"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+
+	creds, _ = NewOAuth2Credentials("revoked.example.com", "robot", &OAuth2Token{TokenURL: server.URL, ClientID: "cli", RefreshToken: "refresh-1"})
+	_ = store.Add(creds)
+	if _, _, err := o.Get("revoked.example.com"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("expected invalid grant error, got %v", err)
+	}
+}
+
+func TestOAuth2ReadOnly(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	server, requests := newTestTokenEndpoint(t)
+	static := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token": "access", "expires_in": 300}`))
+	}))
+	t.Cleanup(static.Close)
+
+	store := newMemoryStore()
+	o := NewOAuth2(store)
+	o.readOnly = true
+
+	// Tokens are renewed, but not stored, if the refresh token is not
+	// rotated.
+	creds, err := NewOAuth2Credentials("static.example.com", "robot", &OAuth2Token{TokenURL: static.URL, RefreshToken: "refresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Add(creds)
+	if _, secret, err := o.Get("static.example.com"); err != nil || secret != "access" {
+		t.Fatalf("expected renewed access token, got %s, %v", secret, err)
+	}
+	if _, stored, _ := store.Get("static.example.com"); stored != creds.Secret {
+		t.Errorf("expected the stored token to be left as it is, got %s", stored)
+	}
+
+	// A rotated refresh token cannot be stored, so renewing fails loudly.
+	creds, _ = NewOAuth2Credentials("registry.example.com", "robot", &OAuth2Token{TokenURL: server.URL, ClientID: "cli", RefreshToken: "refresh-1"})
+	_ = store.Add(creds)
+	if _, _, err := o.Get("registry.example.com"); err == nil || !strings.Contains(err.Error(), "rotated the refresh token") {
+		t.Errorf("expected rotated refresh token error, got %v", err)
+	}
+
+	// Tokens known to be rotated are not renewed at all.
+	creds, _ = NewOAuth2Credentials("rotating.example.com", "robot", &OAuth2Token{TokenURL: server.URL, ClientID: "cli", RefreshToken: "refresh-5", Rotating: true})
+	_ = store.Add(creds)
+	*requests = 0
+	if _, _, err := o.Get("rotating.example.com"); err == nil || !strings.Contains(err.Error(), "rotates refresh tokens") {
+		t.Errorf("expected rotating refresh token error, got %v", err)
+	}
+	if *requests != 0 {
+		t.Errorf("expected no token request, got %d", *requests)
+	}
+}
+
+func TestOAuth2Cache(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	server, requests := newTestTokenEndpoint(t)
+
+	store := newMemoryStore()
+	creds, err := NewOAuth2Credentials("registry.example.com", "robot", &OAuth2Token{TokenURL: server.URL, ClientID: "cli", RefreshToken: "refresh-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Add(creds)
+
+	// The cache holds the stored tokens, for longer than access tokens are
+	// valid, and OAuth2 renews them.
+	now := time.Now()
+	c, err := NewCache(store, CacheOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return now }
+	o := NewOAuth2(c)
+	o.now = c.now
+
+	for _, expected := range []struct {
+		elapsed  time.Duration
+		secret   string
+		requests int
+	}{
+		{elapsed: 0, secret: "access-1", requests: 1},
+		{elapsed: 4 * time.Minute, secret: "access-1", requests: 1},
+		{elapsed: 5 * time.Minute, secret: "access-2", requests: 2},
+		{elapsed: time.Minute, secret: "access-2", requests: 2},
+	} {
+		now = now.Add(expected.elapsed)
+		if _, secret, err := o.Get("registry.example.com"); err != nil || secret != expected.secret {
+			t.Fatalf("expected %s, got %s, %v", expected.secret, secret, err)
+		}
+		if *requests != expected.requests {
+			t.Errorf("expected %d token requests, got %d", expected.requests, *requests)
+		}
+	}
+}
+
+func TestOAuth2ApproximateMatch(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	server, _ := newTestTokenEndpoint(t)
+
+	const serverURL = "https://registry.example.com:5000"
+	store := newMemoryStore()
+	creds, err := NewOAuth2Credentials(serverURL, "robot", &OAuth2Token{TokenURL: server.URL, ClientID: "cli", RefreshToken: "refresh-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Add(creds)
+
+	out := new(bytes.Buffer)
+	if err := Get(NewOAuth2(store), strings.NewReader("registry.example.com"), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Secret":"access-1"`) {
+		t.Errorf("expected renewed access token, got %s", out)
+	}
+	accts, _ := store.List()
+	if len(accts) != 1 {
+		t.Errorf("expected renewed token to be stored under %s, got %v", serverURL, accts)
+	}
+	_, stored, _ := store.Get(serverURL)
+	if token, ok := parseOAuth2Secret(stored); !ok || token.RefreshToken != "refresh-2" {
+		t.Errorf("expected rotated refresh token to be stored, got %+v", token)
+	}
+}
+
+func TestNewOAuth2Credentials(t *testing.T) {
+	if _, err := NewOAuth2Credentials("registry.example.com", "robot", &OAuth2Token{RefreshToken: "refresh"}); err == nil {
+		t.Error("expected error without token URL")
+	}
+	if _, err := NewOAuth2Credentials("registry.example.com", "robot", &OAuth2Token{TokenURL: "https://auth.example.com/token"}); err == nil {
+		t.Error("expected error without refresh token")
+	}
+	for _, secret := range []string{"password", "oauth2:", "oauth2:{}", `oauth2:{"tokenURL": "https://auth.example.com/token"}`} {
+		if _, ok := parseOAuth2Secret(secret); ok {
+			t.Errorf("%s: expected no OAuth2 token", secret)
+		}
+	}
+}
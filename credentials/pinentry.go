@@ -0,0 +1,173 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes returned by pinentry, without the error source (see
+// libgpg-error).
+const (
+	gpgErrCanceled     = 99
+	gpgErrNotConfirmed = 114
+)
+
+// approval is the answer of the user to an approval prompt.
+type approval int
+
+const (
+	approvalDenied approval = iota
+	approvalOnce
+	approvalRemember
+)
+
+// errAssuan is an error returned by an Assuan server.
+type errAssuan struct {
+	code    uint32
+	message string
+}
+
+func (e errAssuan) Error() string {
+	return fmt.Sprintf("pinentry: %s (%d)", e.message, e.code)
+}
+
+// pinentry is a client for a pinentry program, which speaks the Assuan
+// protocol on its stdin and stdout.
+type pinentry struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func startPinentry(program string) (*pinentry, error) {
+	cmd := exec.Command(program)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &pinentry{cmd: cmd, in: in, out: bufio.NewReader(out)}
+
+	// The server greets the client with an OK response.
+	if err := p.response(); err != nil {
+		_ = p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// command sends a command, with its arguments escaped, and waits for the
+// response.
+func (p *pinentry) command(cmd string, args ...string) error {
+	line := cmd
+	for _, arg := range args {
+		line += " " + assuanEscape(arg)
+	}
+	Logger().Debug("pinentry command", "command", cmd)
+	if _, err := io.WriteString(p.in, line+"\n"); err != nil {
+		return err
+	}
+	return p.response()
+}
+
+// response reads lines until the final OK or ERR response. Comments, status
+// and data lines are ignored, as the commands used do not need them.
+func (p *pinentry) response() error {
+	for {
+		line, err := p.out.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("pinentry: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return nil
+		case strings.HasPrefix(line, "ERR "):
+			code, message, _ := strings.Cut(strings.TrimPrefix(line, "ERR "), " ")
+			n, err := strconv.ParseUint(code, 10, 32)
+			if err != nil {
+				return fmt.Errorf("pinentry: invalid response: %s", line)
+			}
+			return errAssuan{code: uint32(n), message: message}
+		}
+	}
+}
+
+func (p *pinentry) close() error {
+	_ = p.command("BYE")
+	_ = p.in.Close()
+	return p.cmd.Wait()
+}
+
+// assuanEscape percent-escapes the characters that cannot appear in the
+// arguments of an Assuan command.
+func assuanEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// askApproval asks the user, through the pinentry program, to approve
+// releasing the credentials for serverURL to caller. If remember is not
+// zero, the user is also offered to approve further requests from the same
+// executable for that long.
+func askApproval(program, serverURL string, caller *Caller, remember time.Duration) (approval, error) {
+	p, err := startPinentry(program)
+	if err != nil {
+		return approvalDenied, fmt.Errorf("cannot ask for approval: %w", err)
+	}
+	defer p.close()
+
+	// pinentry-curses needs to know the terminal to draw on, as its stdin
+	// and stdout are the pipes to the client. Other pinentries ignore
+	// these options.
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		_ = p.command("OPTION", "ttyname="+tty)
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		_ = p.command("OPTION", "ttytype="+term)
+	}
+
+	desc := fmt.Sprintf("%s is requesting the credentials for %s.\n\nAllow access?", describeCaller(caller), serverURL)
+	cmds := [][]string{
+		{"SETTITLE", "Docker credentials"},
+		{"SETDESC", desc},
+		{"SETOK", "Allow"},
+		{"SETCANCEL", "Deny"},
+	}
+	if remember > 0 {
+		cmds = append(cmds, []string{"SETNOTOK", fmt.Sprintf("Allow for %d minutes", int(remember.Minutes()))})
+	}
+	for _, cmd := range cmds {
+		if err := p.command(cmd[0], cmd[1:]...); err != nil {
+			return approvalDenied, fmt.Errorf("cannot ask for approval: %w", err)
+		}
+	}
+
+	err = p.command("CONFIRM")
+	var assuanErr errAssuan
+	switch {
+	case err == nil:
+		return approvalOnce, nil
+	case errors.As(err, &assuanErr) && assuanErr.code&0xffff == gpgErrNotConfirmed && remember > 0:
+		return approvalRemember, nil
+	case errors.As(err, &assuanErr) && (assuanErr.code&0xffff == gpgErrCanceled || assuanErr.code&0xffff == gpgErrNotConfirmed):
+		return approvalDenied, nil
+	default:
+		return approvalDenied, fmt.Errorf("cannot ask for approval: %w", err)
+	}
+}
@@ -0,0 +1,119 @@
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePinentry is a pinentry speaking just enough of the Assuan protocol.
+// It logs the commands it receives to $FAKE_PINENTRY_LOG, and answers
+// CONFIRM with $FAKE_PINENTRY_CONFIRM.
+const fakePinentry = `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r line; do
+	echo "$line" >> "$FAKE_PINENTRY_LOG"
+	case "$line" in
+	CONFIRM*) echo "$FAKE_PINENTRY_CONFIRM" ;;
+	BYE*) echo "OK closing connection"; exit 0 ;;
+	*) echo "# ignored"; echo "OK" ;;
+	esac
+done
+`
+
+// Answers of pinentry to CONFIRM.
+const (
+	pinentryOK        = "OK"
+	pinentryNotOK     = "ERR 83886194 Not confirmed <Pinentry>"
+	pinentryCancelled = "ERR 83886179 Operation cancelled <Pinentry>"
+)
+
+// setFakePinentry sets a fake pinentry giving answer to CONFIRM in the
+// policy, and returns the path of the log of the commands it receives.
+func setFakePinentry(t *testing.T, p *Policy, answer string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pinentry is a shell script")
+	}
+	dir := t.TempDir()
+	program := filepath.Join(dir, "pinentry")
+	if err := os.WriteFile(program, []byte(fakePinentry), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "pinentry.log")
+	p.Pinentry = program
+	t.Setenv("FAKE_PINENTRY_LOG", log)
+	t.Setenv("FAKE_PINENTRY_CONFIRM", answer)
+	return log
+}
+
+// pinentryCommands returns the commands received by the fake pinentry.
+func pinentryCommands(t *testing.T, log string) []string {
+	t.Helper()
+	b, err := os.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestAskApproval(t *testing.T) {
+	caller := &Caller{PID: 42, Executable: "/usr/bin/docker"}
+	tests := []struct {
+		answer   string
+		remember time.Duration
+		expected approval
+	}{
+		{answer: pinentryOK, expected: approvalOnce},
+		{answer: pinentryOK, remember: 10 * time.Minute, expected: approvalOnce},
+		{answer: pinentryNotOK, remember: 10 * time.Minute, expected: approvalRemember},
+		{answer: pinentryNotOK, expected: approvalDenied},
+		{answer: pinentryCancelled, remember: 10 * time.Minute, expected: approvalDenied},
+	}
+	for _, tc := range tests {
+		p := new(Policy)
+		log := setFakePinentry(t, p, tc.answer)
+		actual, err := askApproval(p.Pinentry, "registry.example.com", caller, tc.remember)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("expected approval %d for %q, got %d", tc.expected, tc.answer, actual)
+		}
+
+		cmds := strings.Join(pinentryCommands(t, log), "\n")
+		if !strings.Contains(cmds, "SETDESC process 42 (/usr/bin/docker) is requesting the credentials for registry.example.com.%0A%0AAllow access?") {
+			t.Errorf("expected description of the request, got:\n%s", cmds)
+		}
+		if remember := strings.Contains(cmds, "SETNOTOK Allow for 10 minutes"); remember != (tc.remember > 0) {
+			t.Errorf("expected remember option: %t, got:\n%s", tc.remember > 0, cmds)
+		}
+		if !strings.HasSuffix(cmds, "CONFIRM\nBYE") {
+			t.Errorf("expected confirmation, got:\n%s", cmds)
+		}
+	}
+}
+
+func TestAskApprovalError(t *testing.T) {
+	p := new(Policy)
+	setFakePinentry(t, p, "ERR 83886335 Unknown IPC command <Pinentry>")
+	if _, err := askApproval(p.Pinentry, "registry.example.com", nil, 0); err == nil {
+		t.Error("expected error")
+	}
+
+	if _, err := askApproval(filepath.Join(t.TempDir(), "missing"), "registry.example.com", nil, 0); err == nil {
+		t.Error("expected error without pinentry")
+	}
+}
+
+func TestAssuanEscape(t *testing.T) {
+	if actual := assuanEscape("100%\r\nsure"); actual != "100%25%0D%0Asure" {
+		t.Errorf("unexpected escaping: %s", actual)
+	}
+}
@@ -0,0 +1,450 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/docker/docker-credential-helpers/registryurl"
+)
+
+// policy is the policy enforced by HandleCommand, if any.
+var policy *Policy
+
+// SetPolicy sets the policy enforced by HandleCommand. A nil policy
+// disables enforcement.
+func SetPolicy(p *Policy) {
+	policy = p
+}
+
+// Policy restricts which registries a helper serves. It is a list of
+// rules, all of which must be satisfied for an action to be permitted.
+//
+// For example, the following policy refuses to store credentials for
+// plain-http registries, only hands out credentials for ghcr.io and
+// internal registries, and requires the credentials for the internal
+// registry to belong to a service account:
+//
+//	{
+//	  "rules": [
+//	    {"actions": ["store"], "deny": ["http://*"]},
+//	    {"actions": ["get"], "allow": ["ghcr.io", "*.corp.example.com"]},
+//	    {"registries": ["registry.corp.example.com"], "username": "^svc-"},
+//	    {"actions": ["get"], "registries": ["*.corp.example.com"], "callers": ["/usr/bin/docker", "/usr/bin/buildctl"]},
+//	    {"registries": ["registry.prod.example.com"], "confirm": true, "remember": 10},
+//	    {"registries": ["*.example.com"], "notify": true}
+//	  ],
+//	  "pinentry": "/usr/bin/pinentry-gnome3"
+//	}
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+
+	// Pinentry is the absolute path of the pinentry program asking the
+	// user for approval, see [Policy.Confirm].
+	Pinentry string `json:"pinentry,omitempty"`
+}
+
+// PolicyRule is a single rule of a [Policy]. Patterns use the syntax of
+// [registryurl.Pattern].
+type PolicyRule struct {
+	// Actions is the list of actions the rule applies to. If empty, the
+	// rule applies to store, get and erase. For list, the rule filters
+	// out the server URLs it does not permit.
+	Actions []Action `json:"actions,omitempty"`
+
+	// Registries limits the rule to server URLs matching one of these
+	// patterns. If empty, the rule applies to all server URLs.
+	Registries []string `json:"registries,omitempty"`
+
+	// Allow, if not empty, permits only server URLs matching one of these
+	// patterns.
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny refuses server URLs matching any of these patterns.
+	Deny []string `json:"deny,omitempty"`
+
+	// Username is a regular expression the username must match.
+	Username string `json:"username,omitempty"`
+
+	// Callers, if not empty, permits only callers whose executable matches
+	// one of these patterns. Patterns must be absolute paths, such as
+	// "/usr/bin/docker", as a bare name would be matched by any executable
+	// of that name, wherever the caller put it. Callers can only be
+	// identified on Linux.
+	Callers []string `json:"callers,omitempty"`
+
+	// Cgroups, if not empty, permits only callers in, or below, a cgroup
+	// matching one of these patterns, such as "/user.slice/*".
+	Cgroups []string `json:"cgroups,omitempty"`
+
+	// Confirm requires the user to approve each get action through
+	// pinentry before the credentials are released, like ssh-agent -c.
+	Confirm bool `json:"confirm,omitempty"`
+
+	// Remember, if not zero, offers the user to approve further get
+	// actions from the same executable for the same server URL for this
+	// many minutes.
+	Remember int `json:"remember,omitempty"`
+
+	// Notify sends a desktop notification naming the caller whenever get
+	// returns credentials.
+	Notify bool `json:"notify,omitempty"`
+}
+
+// errPolicyViolation is returned when an action is refused by the policy.
+type errPolicyViolation struct {
+	action    Action
+	serverURL string
+	reason    string
+}
+
+func (e errPolicyViolation) Error() string {
+	return fmt.Sprintf("policy violation: %s for %s: %s", e.action, e.serverURL, e.reason)
+}
+
+// Forbidden implements the [ErrForbidden][errdefs.ErrForbidden] interface.
+//
+// [errdefs.ErrForbidden]: https://pkg.go.dev/github.com/docker/docker@v24.0.1+incompatible/errdefs#ErrForbidden
+func (errPolicyViolation) Forbidden() {}
+
+// IsErrPolicyViolation returns true if the error was caused by an action
+// being refused by the policy.
+func IsErrPolicyViolation(err error) bool {
+	var target errPolicyViolation
+	return errors.As(err, &target)
+}
+
+// errCallerDenied is returned when the policy does not permit the calling
+// process to request an action.
+type errCallerDenied struct {
+	action    Action
+	serverURL string
+	caller    string
+}
+
+func (e errCallerDenied) Error() string {
+	return fmt.Sprintf("access denied: %s for %s is not permitted for %s", e.action, e.serverURL, e.caller)
+}
+
+// Forbidden implements the [ErrForbidden][errdefs.ErrForbidden] interface.
+//
+// [errdefs.ErrForbidden]: https://pkg.go.dev/github.com/docker/docker@v24.0.1+incompatible/errdefs#ErrForbidden
+func (errCallerDenied) Forbidden() {}
+
+// IsErrCallerDenied returns true if the error was caused by the policy not
+// permitting the calling process to request an action.
+func IsErrCallerDenied(err error) bool {
+	var target errCallerDenied
+	return errors.As(err, &target)
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := ParsePolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParsePolicy reads a JSON-encoded policy and validates its patterns and
+// regular expressions.
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Pinentry != "" && !filepath.IsAbs(p.Pinentry) {
+		return nil, fmt.Errorf("invalid pinentry %q: not an absolute path", p.Pinentry)
+	}
+	for _, rule := range p.Rules {
+		for _, patterns := range [][]string{rule.Registries, rule.Allow, rule.Deny} {
+			for _, pattern := range patterns {
+				if _, err := registryurl.ParsePattern(pattern); err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+				}
+			}
+		}
+		if _, err := regexp.Compile(rule.Username); err != nil {
+			return nil, err
+		}
+		for _, pattern := range append(rule.Callers, rule.Cgroups...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		for _, pattern := range rule.Callers {
+			if !filepath.IsAbs(pattern) {
+				return nil, fmt.Errorf("invalid caller %q: not an absolute path", pattern)
+			}
+		}
+		if rule.Remember < 0 {
+			return nil, fmt.Errorf("invalid remember: %d", rule.Remember)
+		}
+	}
+	return &p, nil
+}
+
+// Check returns an error if the policy does not permit the action for the
+// server URL and username. An empty username is not checked against
+// username rules, which allows to check an action before the username is
+// known. Rules restricting callers are never satisfied; use CheckCaller to
+// check an action requested by a known process.
+func (p *Policy) Check(action Action, serverURL, username string) error {
+	return p.CheckCaller(action, serverURL, username, nil)
+}
+
+// CheckCaller is like Check, but also checks that the policy permits the
+// calling process to request the action. If the caller is not permitted,
+// the error is one for which IsErrCallerDenied returns true.
+func (p *Policy) CheckCaller(action Action, serverURL, username string, caller *Caller) error {
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(action, serverURL) {
+			continue
+		}
+		if reason := rule.check(serverURL, username); reason != "" {
+			Logger().Debug("policy refused action", "action", action, "serverURL", serverURL, "username", username, "reason", reason)
+			return errPolicyViolation{action: action, serverURL: serverURL, reason: reason}
+		}
+		if !rule.permitsCaller(caller) {
+			Logger().Debug("policy refused caller", "action", action, "serverURL", serverURL, "caller", caller)
+			return errCallerDenied{action: action, serverURL: serverURL, caller: describeCaller(caller)}
+		}
+	}
+	return nil
+}
+
+func (r *PolicyRule) appliesTo(action Action, serverURL string) bool {
+	if len(r.Actions) == 0 {
+		switch action {
+		case ActionStore, ActionGet, ActionErase:
+		default:
+			return false
+		}
+	} else if !containsAction(r.Actions, action) {
+		return false
+	}
+	return len(r.Registries) == 0 || matchAny(r.Registries, serverURL)
+}
+
+// check returns the reason the rule refuses serverURL and username, or an
+// empty string if it permits them.
+func (r *PolicyRule) check(serverURL, username string) string {
+	if matchAny(r.Deny, serverURL) {
+		return "registry is denied"
+	}
+	if len(r.Allow) > 0 && !matchAny(r.Allow, serverURL) {
+		return "registry is not allowed"
+	}
+	if r.Username != "" && username != "" {
+		if ok, err := regexp.MatchString(r.Username, username); err != nil || !ok {
+			return fmt.Sprintf("username %q is not allowed", username)
+		}
+	}
+	return ""
+}
+
+// permitsCaller reports whether the rule permits the caller.
+func (r *PolicyRule) permitsCaller(caller *Caller) bool {
+	if len(r.Callers) == 0 && len(r.Cgroups) == 0 {
+		return true
+	}
+	if caller == nil {
+		return false
+	}
+	if len(r.Callers) > 0 && !matchAnyFunc(r.Callers, caller.Executable, matchExecutable) {
+		return false
+	}
+	if len(r.Cgroups) > 0 && !matchAnyFunc(r.Cgroups, caller.Cgroup, matchCgroup) {
+		return false
+	}
+	return true
+}
+
+func matchAnyFunc(patterns []string, s string, match func(pattern, s string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether serverURL matches any of the patterns.
+func matchAny(patterns []string, serverURL string) bool {
+	for _, pattern := range patterns {
+		p, err := registryurl.ParsePattern(pattern)
+		if err == nil && p.MatchString(serverURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyHelper is a Helper that enforces a policy on another helper, for
+// actions requested by caller.
+type policyHelper struct {
+	helper Helper
+	policy *Policy
+	caller *Caller
+}
+
+func (h policyHelper) Add(creds *Credentials) error {
+	if creds == nil {
+		return errors.New("missing credentials")
+	}
+	if err := h.policy.CheckCaller(ActionStore, creds.ServerURL, creds.Username, h.caller); err != nil {
+		return err
+	}
+	return h.helper.Add(creds)
+}
+
+func (h policyHelper) Delete(serverURL string) error {
+	if err := h.policy.CheckCaller(ActionErase, serverURL, "", h.caller); err != nil {
+		return err
+	}
+	return h.helper.Delete(serverURL)
+}
+
+// Get checks the server URL before retrieving the credentials, so that no
+// secret is retrieved for a refused registry, and the username afterwards.
+// Approval is only asked for credentials that exist, so that looking up
+// missing credentials does not prompt the user.
+func (h policyHelper) Get(serverURL string) (string, string, error) {
+	if err := h.policy.CheckCaller(ActionGet, serverURL, "", h.caller); err != nil {
+		return "", "", err
+	}
+	username, secret, err := h.helper.Get(serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	if err := h.policy.CheckCaller(ActionGet, serverURL, username, h.caller); err != nil {
+		return "", "", err
+	}
+	if err := h.policy.Confirm(serverURL, h.caller); err != nil {
+		return "", "", err
+	}
+	h.policy.notify(serverURL, username, h.caller)
+	return username, secret, nil
+}
+
+func (h policyHelper) List() (map[string]string, error) {
+	return h.filter(h.helper.List())
+}
+
+func (h policyHelper) ListAll() (map[string]string, error) {
+	return h.filter(listAll(h.helper))
+}
+
+// filter removes the credentials the policy does not permit to list from
+// accts.
+func (h policyHelper) filter(accts map[string]string, err error) (map[string]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	for serverURL, username := range accts {
+		if h.policy.CheckCaller(ActionList, serverURL, username, h.caller) != nil {
+			delete(accts, serverURL)
+		}
+	}
+	return accts, nil
+}
+
+// LoadDefaultPolicy loads the system-wide policy file and the policy file
+// in the user's configuration directory, and returns a policy made of the
+// rules of both. As all the rules must be satisfied, the user's policy can
+// only add restrictions to the system-wide one. The locations cannot be
+// changed from the environment, and files that are writable by other users
+// or not owned by the user or root are refused. It returns nil if there is
+// no policy.
+func LoadDefaultPolicy() (*Policy, error) {
+	var system *Policy
+	if systemPolicyPath != "" {
+		p, err := loadPrivatePolicy(systemPolicyPath)
+		if err := ignoreNotExist(err); err != nil {
+			return nil, err
+		}
+		system = p
+	}
+
+	var user *Policy
+	if path, err := userPolicyPath(); err == nil {
+		p, err := loadPrivatePolicy(path)
+		if err := ignoreNotExist(err); err != nil {
+			return nil, err
+		}
+		user = p
+	}
+	return mergePolicies(system, user), nil
+}
+
+// mergePolicies returns a policy enforcing the rules of both system and
+// user, either of which may be nil. If there is a system policy, the
+// pinentry set by the user is ignored, so that the confirmations it
+// requires cannot be answered by a program of the user's choosing.
+func mergePolicies(system, user *Policy) *Policy {
+	switch {
+	case system == nil:
+		return user
+	case user == nil:
+		return system
+	}
+	return &Policy{
+		Rules:    append(append([]PolicyRule(nil), system.Rules...), user.Rules...),
+		Pinentry: system.Pinentry,
+	}
+}
+
+// loadPrivatePolicy is LoadPolicy, refusing files that could have been
+// written by another user.
+func loadPrivatePolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPolicyFile(path, info); err != nil {
+		return nil, err
+	}
+	p, err := ParsePolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ignoreNotExist returns nil if err reports a missing file.
+func ignoreNotExist(err error) error {
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
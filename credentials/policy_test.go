@@ -0,0 +1,255 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `{
+  "rules": [
+    {"actions": ["store"], "deny": ["http://*"]},
+    {"actions": ["get", "list"], "allow": ["ghcr.io", "*.corp.example.com"]},
+    {"registries": ["registry.corp.example.com"], "username": "^svc-"}
+  ]
+}`
+
+func TestPolicyCheck(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		action    Action
+		serverURL string
+		username  string
+		allowed   bool
+	}{
+		{action: ActionStore, serverURL: "http://registry.example.com", username: "foo", allowed: false},
+		{action: ActionStore, serverURL: "https://registry.example.com", username: "foo", allowed: true},
+		{action: ActionStore, serverURL: "registry.example.com", username: "foo", allowed: true},
+		{action: ActionGet, serverURL: "https://ghcr.io", allowed: true},
+		{action: ActionGet, serverURL: "build.corp.example.com", allowed: true},
+		{action: ActionGet, serverURL: "https://evil.example.com", allowed: false},
+		{action: ActionErase, serverURL: "https://evil.example.com", allowed: true},
+		{action: ActionStore, serverURL: "registry.corp.example.com", username: "svc-build", allowed: true},
+		{action: ActionStore, serverURL: "registry.corp.example.com", username: "jdoe", allowed: false},
+		{action: ActionGet, serverURL: "registry.corp.example.com", username: "jdoe", allowed: false},
+		{action: ActionGet, serverURL: "registry.corp.example.com", allowed: true},
+		{action: ActionVersion, allowed: true},
+	}
+
+	for _, tc := range tests {
+		err := p.Check(tc.action, tc.serverURL, tc.username)
+		if tc.allowed && err != nil {
+			t.Errorf("expected %s for %s (%q) to be allowed, got %v", tc.action, tc.serverURL, tc.username, err)
+		}
+		if !tc.allowed && !IsErrPolicyViolation(err) {
+			t.Errorf("expected %s for %s (%q) to be a policy violation, got %v", tc.action, tc.serverURL, tc.username, err)
+		}
+	}
+}
+
+func TestPolicyCheckCaller(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(`{
+  "rules": [
+    {"actions": ["get"], "registries": ["*.prod.example.com"], "callers": ["/usr/bin/docker", "/usr/local/bin/buildctl"]},
+    {"actions": ["store"], "cgroups": ["/user.slice"]}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docker := &Caller{PID: 1, Executable: "/usr/bin/docker", Cgroup: "/user.slice/user-1000.slice"}
+	buildctl := &Caller{PID: 2, Executable: "/usr/local/bin/buildctl", Cgroup: "/system.slice/buildkit.service"}
+	curl := &Caller{PID: 3, Executable: "/usr/bin/curl", Cgroup: "/user.slice/user-1000.slice"}
+
+	tests := []struct {
+		action    Action
+		serverURL string
+		caller    *Caller
+		allowed   bool
+	}{
+		{action: ActionGet, serverURL: "registry.prod.example.com", caller: docker, allowed: true},
+		{action: ActionGet, serverURL: "registry.prod.example.com", caller: buildctl, allowed: true},
+		{action: ActionGet, serverURL: "registry.prod.example.com", caller: curl, allowed: false},
+		{action: ActionGet, serverURL: "registry.prod.example.com", caller: nil, allowed: false},
+		{action: ActionGet, serverURL: "registry.dev.example.com", caller: curl, allowed: true},
+		{action: ActionStore, serverURL: "registry.dev.example.com", caller: docker, allowed: true},
+		{action: ActionStore, serverURL: "registry.dev.example.com", caller: buildctl, allowed: false},
+	}
+	for _, tc := range tests {
+		err := p.CheckCaller(tc.action, tc.serverURL, "", tc.caller)
+		if tc.allowed && err != nil {
+			t.Errorf("expected %s for %s by %+v to be allowed, got %v", tc.action, tc.serverURL, tc.caller, err)
+		}
+		if !tc.allowed && !IsErrCallerDenied(err) {
+			t.Errorf("expected %s for %s by %+v to be denied, got %v", tc.action, tc.serverURL, tc.caller, err)
+		}
+	}
+}
+
+func TestHandleCommandCallerDenied(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(`{"rules": [{"actions": ["get"], "callers": ["/usr/bin/docker"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+	path := filepath.Join(t.TempDir(), "audit.log")
+	SetAuditLog(NewAuditLog(path))
+	t.Cleanup(func() { SetAuditLog(nil) })
+
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "registry.example.com", Username: "foo", Secret: "bar"})
+
+	// The parent of the test binary is not /usr/bin/docker.
+	out := new(bytes.Buffer)
+	if err := HandleCommand(h, ActionGet, strings.NewReader("registry.example.com"), out); !IsErrCallerDenied(err) {
+		t.Fatalf("expected caller to be denied, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %s", out.String())
+	}
+
+	recs := readAuditRecords(t, path)
+	if len(recs) != 1 || recs[0].Outcome != AuditOutcomeDenied {
+		t.Errorf("expected denial to be audited, got %+v", recs)
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	for _, policy := range []string{
+		`{"rules": [{"allow": ["ftp://ghcr.io"]}]}`,
+		`{"rules": [{"username": "("}]}`,
+		`{"rules": [{"unknown": true}]}`,
+		`{"rules": [{"callers": ["[docker"]}]}`,
+		`{"rules": [{"callers": ["buildctl"]}]}`,
+		`{"rules": [{"confirm": true, "remember": -1}]}`,
+	} {
+		if _, err := ParsePolicy(strings.NewReader(policy)); err == nil {
+			t.Errorf("expected error parsing %s, got nil", policy)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 3 {
+		t.Errorf("expected 3 rules, got %d", len(p.Rules))
+	}
+}
+
+func TestMergePolicies(t *testing.T) {
+	system, err := ParsePolicy(strings.NewReader(`{"rules": [{"deny": ["http://*"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := ParsePolicy(strings.NewReader(`{"rules": [{"allow": ["http://*", "ghcr.io"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := mergePolicies(nil, nil); p != nil {
+		t.Errorf("expected no policy, got %+v", p)
+	}
+	if p := mergePolicies(system, nil); p != system {
+		t.Errorf("expected the system policy, got %+v", p)
+	}
+	if p := mergePolicies(nil, user); p != user {
+		t.Errorf("expected the user policy, got %+v", p)
+	}
+
+	// The user policy cannot lift the restrictions of the system policy.
+	p := mergePolicies(system, user)
+	if err := p.Check(ActionGet, "http://ghcr.io", ""); !IsErrPolicyViolation(err) {
+		t.Errorf("expected denied registry, got %v", err)
+	}
+	if err := p.Check(ActionGet, "https://docker.io", ""); !IsErrPolicyViolation(err) {
+		t.Errorf("expected registry not allowed by the user policy, got %v", err)
+	}
+	if err := p.Check(ActionGet, "https://ghcr.io", ""); err != nil {
+		t.Errorf("expected allowed registry, got %v", err)
+	}
+}
+
+func TestLoadPrivatePolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPrivatePolicy(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPrivatePolicy(path); err == nil {
+		t.Error("expected a world-writable policy to be refused")
+	}
+}
+
+func TestHandleCommandPolicy(t *testing.T) {
+	p, err := ParsePolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(nil) })
+
+	h := newMemoryStore()
+	_ = h.Add(&Credentials{ServerURL: "https://evil.example.com", Username: "foo", Secret: "bar"})
+	_ = h.Add(&Credentials{ServerURL: "registry.corp.example.com", Username: "jdoe", Secret: "bar"})
+	_ = h.Add(&Credentials{ServerURL: "ghcr.io", Username: "foo", Secret: "bar"})
+
+	out := new(bytes.Buffer)
+	for _, serverURL := range []string{"https://evil.example.com", "registry.corp.example.com"} {
+		err := HandleCommand(h, ActionGet, strings.NewReader(serverURL), out)
+		if !IsErrPolicyViolation(err) {
+			t.Errorf("expected policy violation for %s, got %v", serverURL, err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %s", out.String())
+	}
+
+	if err := HandleCommand(h, ActionGet, strings.NewReader("ghcr.io"), out); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(Credentials{ServerURL: "http://registry.example.com", Username: "foo", Secret: "bar"})
+	if err := HandleCommand(h, ActionStore, bytes.NewReader(b), out); !IsErrPolicyViolation(err) {
+		t.Errorf("expected policy violation, got %v", err)
+	}
+	if _, ok := h.creds["http://registry.example.com"]; ok {
+		t.Error("expected credentials not to be stored")
+	}
+
+	out.Reset()
+	if err := HandleCommand(h, ActionList, nil, out); err != nil {
+		t.Fatal(err)
+	}
+	var accts map[string]string
+	if err := json.NewDecoder(out).Decode(&accts); err != nil {
+		t.Fatal(err)
+	}
+	if len(accts) != 2 {
+		t.Errorf("expected list to be filtered by policy, got %v", accts)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// systemPolicyPath is the policy file used when the user has none.
+const systemPolicyPath = "/etc/docker-credential-helpers/policy.json"
+
+// defaultPinentries are the pinentry programs tried, in order, if the policy
+// sets none. pinentry is usually a symlink to the user's preferred
+// pinentry, such as pinentry-curses or pinentry-gnome3.
+var defaultPinentries = []string{
+	"/usr/bin/pinentry",
+	"/usr/local/bin/pinentry",
+	"/opt/homebrew/bin/pinentry",
+}
+
+// userPolicyPath returns the location of the user's policy file. The home
+// directory is taken from the user database rather than the environment,
+// so that callers cannot redirect it.
+func userPolicyPath() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(u.HomeDir, ".config")
+	if runtime.GOOS == "darwin" {
+		dir = filepath.Join(u.HomeDir, "Library", "Application Support")
+	}
+	return filepath.Join(dir, "docker-credential-helpers", "policy.json"), nil
+}
+
+// checkPolicyFile refuses policy files that are not owned by the current
+// user or root, or that other users can write to.
+func checkPolicyFile(path string, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (int(st.Uid) != os.Getuid() && st.Uid != 0) {
+		return fmt.Errorf("policy %s is not owned by the current user or root", path)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("policy %s is writable by other users", path)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// systemPolicyPath is the policy file used when the user has none. There
+// is no system-wide policy on Windows.
+const systemPolicyPath = ""
+
+// defaultPinentries are the pinentry programs tried, in order, if the policy
+// sets none. They are the ones installed by Gpg4win.
+var defaultPinentries = []string{
+	`C:\Program Files (x86)\Gpg4win\bin\pinentry.exe`,
+	`C:\Program Files\Gpg4win\bin\pinentry.exe`,
+}
+
+// userPolicyPath returns the location of the user's policy file.
+func userPolicyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "docker-credential-helpers", "policy.json"), nil
+}
+
+// checkPolicyFile is a no-op on Windows, where the user's configuration
+// directory is protected by its ACL.
+func checkPolicyFile(string, os.FileInfo) error {
+	return nil
+}
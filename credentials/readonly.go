@@ -0,0 +1,88 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envReadOnly is the environment variable that, when set to a true value,
+// makes Serve refuse to store or erase credentials.
+const envReadOnly = "DOCKER_CREDENTIAL_HELPERS_READ_ONLY"
+
+// errReadOnlyMessage standardizes the error returned when storing or
+// erasing credentials in read-only mode.
+const errReadOnlyMessage = "credentials store is read-only"
+
+// errReadOnly represents an error raised when credentials are stored or
+// erased through a read-only helper.
+type errReadOnly struct{}
+
+func (errReadOnly) Error() string {
+	return errReadOnlyMessage
+}
+
+// Forbidden implements the [ErrForbidden][errdefs.ErrForbidden] interface.
+//
+// [errdefs.ErrForbidden]: https://pkg.go.dev/github.com/docker/docker@v24.0.1+incompatible/errdefs#ErrForbidden
+func (errReadOnly) Forbidden() {}
+
+// NewErrReadOnly creates a new error for when credentials are stored or
+// erased through a read-only helper.
+func NewErrReadOnly() error {
+	return errReadOnly{}
+}
+
+// IsErrReadOnly returns true if the error was caused by storing or erasing
+// credentials through a read-only helper.
+func IsErrReadOnly(err error) bool {
+	var target errReadOnly
+	return errors.As(err, &target)
+}
+
+// IsErrReadOnlyMessage returns true if the error message was caused by
+// storing or erasing credentials through a read-only helper.
+//
+// This function helps to check messages returned by an
+// external program via its standard output.
+func IsErrReadOnlyMessage(err string) bool {
+	return strings.TrimSpace(err) == errReadOnlyMessage
+}
+
+// ReadOnly wraps helper so that credentials can be retrieved and listed,
+// but not stored or erased.
+func ReadOnly(helper Helper) Helper {
+	return readOnlyHelper{helper: helper}
+}
+
+type readOnlyHelper struct {
+	helper Helper
+}
+
+func (readOnlyHelper) Add(*Credentials) error {
+	return NewErrReadOnly()
+}
+
+func (readOnlyHelper) Delete(string) error {
+	return NewErrReadOnly()
+}
+
+func (h readOnlyHelper) Get(serverURL string) (string, string, error) {
+	return h.helper.Get(serverURL)
+}
+
+func (h readOnlyHelper) List() (map[string]string, error) {
+	return h.helper.List()
+}
+
+func (h readOnlyHelper) ListAll() (map[string]string, error) {
+	return listAll(h.helper)
+}
+
+// readOnlyFromEnv reports whether read-only mode is enabled in the
+// environment.
+func readOnlyFromEnv() bool {
+	readOnly, _ := strconv.ParseBool(os.Getenv(envReadOnly))
+	return readOnly
+}
@@ -0,0 +1,58 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	const serverURL = "https://registry.example.com"
+	store := newMemoryStore()
+	_ = store.Add(&Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"})
+	h := ReadOnly(store)
+
+	b, err := json.Marshal(Credentials{ServerURL: "https://other.example.com", Username: "foo", Secret: "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := HandleCommand(h, ActionStore, bytes.NewReader(b), new(bytes.Buffer)); !IsErrReadOnly(err) {
+		t.Errorf("expected read-only error on store, got %v", err)
+	}
+	if err := HandleCommand(h, ActionErase, strings.NewReader(serverURL), new(bytes.Buffer)); !IsErrReadOnly(err) {
+		t.Errorf("expected read-only error on erase, got %v", err)
+	}
+	if len(store.creds) != 1 {
+		t.Errorf("expected store to be unchanged, got %v", store.creds)
+	}
+
+	out := new(bytes.Buffer)
+	if err := HandleCommand(h, ActionGet, strings.NewReader(serverURL), out); err != nil {
+		t.Fatal(err)
+	}
+	var c Credentials
+	if err := json.NewDecoder(out).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Secret != "bar" {
+		t.Errorf("expected secret bar, got %s", c.Secret)
+	}
+
+	out.Reset()
+	if err := HandleCommand(h, ActionList, nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), serverURL) {
+		t.Errorf("expected %s in list, got %s", serverURL, out.String())
+	}
+}
+
+func TestIsErrReadOnlyMessage(t *testing.T) {
+	if !IsErrReadOnlyMessage(NewErrReadOnly().Error() + "\n") {
+		t.Error("expected read-only error message to be recognized")
+	}
+	if IsErrReadOnlyMessage(NewErrCredentialsNotFound().Error()) {
+		t.Error("expected other error message not to be recognized")
+	}
+}
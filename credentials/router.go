@@ -0,0 +1,138 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker-credential-helpers/registryurl"
+)
+
+// Route associates a registry pattern with the helper that keeps the
+// credentials for server URLs matching it. See [registryurl.Pattern] for
+// the pattern syntax.
+type Route struct {
+	Pattern string
+	Helper  Helper
+}
+
+type route struct {
+	pattern *registryurl.Pattern
+	helper  Helper
+}
+
+// Router is a Helper that dispatches every operation to the helper of the
+// first route matching the server URL. Server URLs not matching any route
+// are sent to the fallback helper, if any.
+type Router struct {
+	routes   []route
+	fallback Helper
+}
+
+// NewRouter creates a Router from routes, which are evaluated in order.
+// The fallback helper may be nil, in which case server URLs that match no
+// route are reported as not found.
+func NewRouter(routes []Route, fallback Helper) (*Router, error) {
+	r := &Router{fallback: fallback}
+	for _, rt := range routes {
+		if rt.Helper == nil {
+			return nil, fmt.Errorf("no helper for route %q", rt.Pattern)
+		}
+		p, err := registryurl.ParsePattern(rt.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", rt.Pattern, err)
+		}
+		r.routes = append(r.routes, route{pattern: p, helper: rt.Helper})
+	}
+	return r, nil
+}
+
+// backends returns the helpers of all routes, followed by the fallback.
+func (r *Router) backends() []Helper {
+	helpers := make([]Helper, 0, len(r.routes)+1)
+	for _, rt := range r.routes {
+		helpers = append(helpers, rt.helper)
+	}
+	if r.fallback != nil {
+		helpers = append(helpers, r.fallback)
+	}
+	return helpers
+}
+
+// lookup returns the index into backends of the helper serverURL is routed
+// to, or -1 if there is none.
+func (r *Router) lookup(serverURL string) int {
+	if u, err := registryurl.Parse(serverURL); err == nil {
+		for i, rt := range r.routes {
+			if rt.pattern.Match(u) {
+				Logger().Debug("routing server URL", "serverURL", serverURL, "pattern", rt.pattern.String())
+				return i
+			}
+		}
+	}
+	if r.fallback != nil {
+		Logger().Debug("routing server URL to fallback helper", "serverURL", serverURL)
+		return len(r.routes)
+	}
+	Logger().Debug("no route for server URL", "serverURL", serverURL)
+	return -1
+}
+
+// Add stores credentials in the helper the server URL is routed to.
+func (r *Router) Add(creds *Credentials) error {
+	if creds == nil {
+		return errors.New("missing credentials")
+	}
+	i := r.lookup(creds.ServerURL)
+	if i < 0 {
+		return fmt.Errorf("no credentials helper configured for %s", creds.ServerURL)
+	}
+	return r.backends()[i].Add(creds)
+}
+
+// Delete removes credentials from the helper the server URL is routed to.
+// It is a no-op for server URLs that are not routed to any helper.
+func (r *Router) Delete(serverURL string) error {
+	i := r.lookup(serverURL)
+	if i < 0 {
+		return nil
+	}
+	return r.backends()[i].Delete(serverURL)
+}
+
+// Get retrieves credentials from the helper the server URL is routed to.
+func (r *Router) Get(serverURL string) (string, string, error) {
+	i := r.lookup(serverURL)
+	if i < 0 {
+		return "", "", NewErrCredentialsNotFound()
+	}
+	return r.backends()[i].Get(serverURL)
+}
+
+// List merges the credentials of all helpers. If a server URL is known to
+// more than one helper, the username from the helper the server URL is
+// routed to takes precedence.
+func (r *Router) List() (map[string]string, error) {
+	return r.list(Helper.List)
+}
+
+// ListAll merges all the accounts of all helpers, see MultiAccountHelper.
+func (r *Router) ListAll() (map[string]string, error) {
+	return r.list(listAll)
+}
+
+// list merges the credentials listed by list for all helpers.
+func (r *Router) list(list func(Helper) (map[string]string, error)) (map[string]string, error) {
+	resp := make(map[string]string)
+	for i, h := range r.backends() {
+		accts, err := list(h)
+		if err != nil {
+			return nil, err
+		}
+		for serverURL, username := range accts {
+			if _, exists := resp[serverURL]; !exists || r.lookup(serverURL) == i {
+				resp[serverURL] = username
+			}
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,106 @@
+package credentials
+
+import (
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	ghcr, internal, other := newMemoryStore(), newMemoryStore(), newMemoryStore()
+	r, err := NewRouter([]Route{
+		{Pattern: "ghcr.io", Helper: ghcr},
+		{Pattern: "*.corp.example.com", Helper: internal},
+	}, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds := []*Credentials{
+		{ServerURL: "https://ghcr.io", Username: "foo", Secret: "ghcr"},
+		{ServerURL: "registry.corp.example.com", Username: "bar", Secret: "internal"},
+		{ServerURL: "https://index.docker.io/v1/", Username: "baz", Secret: "other"},
+	}
+	for _, c := range creds {
+		if err := r.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for store, serverURL := range map[*memoryStore]string{
+		ghcr:     "https://ghcr.io",
+		internal: "registry.corp.example.com",
+		other:    "https://index.docker.io/v1/",
+	} {
+		if len(store.creds) != 1 || store.creds[serverURL] == nil {
+			t.Errorf("expected only %s in backend, got %v", serverURL, store.creds)
+		}
+	}
+
+	for _, c := range creds {
+		username, secret, err := r.Get(c.ServerURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != c.Username || secret != c.Secret {
+			t.Errorf("expected %s:%s for %s, got %s:%s", c.Username, c.Secret, c.ServerURL, username, secret)
+		}
+	}
+
+	accts, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accts) != len(creds) {
+		t.Errorf("expected %d credentials, got %v", len(creds), accts)
+	}
+
+	if err := r.Delete("https://ghcr.io"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ghcr.creds) != 0 {
+		t.Errorf("expected credentials to be deleted, got %v", ghcr.creds)
+	}
+}
+
+func TestRouterWithoutFallback(t *testing.T) {
+	r, err := NewRouter([]Route{{Pattern: "ghcr.io", Helper: newMemoryStore()}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := r.Get("https://index.docker.io/v1/"); !IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, got %v", err)
+	}
+	if err := r.Add(&Credentials{ServerURL: "https://index.docker.io/v1/", Username: "foo", Secret: "bar"}); err == nil {
+		t.Error("expected error storing credentials without route, got nil")
+	}
+	if err := r.Delete("https://index.docker.io/v1/"); err != nil {
+		t.Errorf("expected no error erasing credentials without route, got %v", err)
+	}
+}
+
+func TestRouterListPrefersRoutedHelper(t *testing.T) {
+	ghcr, other := newMemoryStore(), newMemoryStore()
+	_ = ghcr.Add(&Credentials{ServerURL: "ghcr.io", Username: "routed"})
+	_ = other.Add(&Credentials{ServerURL: "ghcr.io", Username: "stale"})
+
+	r, err := NewRouter([]Route{{Pattern: "ghcr.io", Helper: ghcr}}, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accts, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accts["ghcr.io"] != "routed" {
+		t.Errorf("expected username from routed helper, got %q", accts["ghcr.io"])
+	}
+}
+
+func TestNewRouterInvalidPattern(t *testing.T) {
+	if _, err := NewRouter([]Route{{Pattern: "ftp://ghcr.io", Helper: newMemoryStore()}}, nil); err == nil {
+		t.Error("expected error for invalid pattern, got nil")
+	}
+	if _, err := NewRouter([]Route{{Pattern: "ghcr.io"}}, nil); err == nil {
+		t.Error("expected error for route without helper, got nil")
+	}
+}
@@ -0,0 +1,237 @@
+package credentials
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// envAge is the environment variable holding the age program used to seal
+// bundles for age recipients.
+const envAge = "DOCKER_CREDENTIAL_HELPERS_AGE"
+
+const (
+	sealFormat = "docker-credential-helpers-bundle"
+
+	// Encryptions of a sealed bundle.
+	sealPassphrase = "passphrase"
+	sealAge        = "age"
+
+	// pbkdf2Iterations is the number of PBKDF2-HMAC-SHA256 iterations used
+	// to derive keys from passphrases, as recommended by OWASP.
+	pbkdf2Iterations = 600000
+	// maxPBKDF2Iterations bounds the iterations of the bundles opened, so
+	// that a crafted bundle cannot keep the helper busy for hours.
+	maxPBKDF2Iterations = 10 * pbkdf2Iterations
+)
+
+// BundleKey holds the keys to seal or open a bundle with: either a
+// passphrase, or age recipients to seal it for and age identity files to
+// open it with.
+type BundleKey struct {
+	Passphrase []byte
+	Recipients []string
+	Identities []string
+}
+
+// sealedBundle is the encrypted form of a bundle, as written to disk.
+type sealedBundle struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Encryption string `json:"encryption"`
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce,omitempty"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealBundle encrypts a bundle with the passphrase, or for the age
+// recipients, of key.
+func SealBundle(b *Bundle, key BundleKey) ([]byte, error) {
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	var sealed *sealedBundle
+	switch {
+	case len(key.Recipients) > 0:
+		sealed, err = sealForRecipients(plaintext, key.Recipients)
+	case len(key.Passphrase) > 0:
+		sealed, err = sealWithPassphrase(plaintext, key.Passphrase, pbkdf2Iterations)
+	default:
+		return nil, errors.New("no passphrase or age recipient to encrypt the bundle with")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(sealed, "", "  ")
+}
+
+// OpenBundle decrypts a bundle sealed by SealBundle, with the passphrase or
+// the age identities of key.
+func OpenBundle(data []byte, key BundleKey) (*Bundle, error) {
+	sealed, err := parseSealedBundle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext []byte
+	switch sealed.Encryption {
+	case sealPassphrase:
+		if len(key.Passphrase) == 0 {
+			return nil, errors.New("the bundle is encrypted with a passphrase")
+		}
+		plaintext, err = sealed.openWithPassphrase(key.Passphrase)
+	case sealAge:
+		if len(key.Identities) == 0 {
+			return nil, errors.New("the bundle is encrypted for age recipients: an identity is needed")
+		}
+		args := []string{"--decrypt"}
+		for _, identity := range key.Identities {
+			args = append(args, "--identity", identity)
+		}
+		plaintext, err = runAge(args, sealed.Ciphertext)
+	default:
+		return nil, fmt.Errorf("unsupported bundle encryption: %s", sealed.Encryption)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(plaintext, &b); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	if b.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	return &b, nil
+}
+
+// bundleEncryption returns how a sealed bundle is encrypted.
+func bundleEncryption(data []byte) (string, error) {
+	sealed, err := parseSealedBundle(data)
+	if err != nil {
+		return "", err
+	}
+	return sealed.Encryption, nil
+}
+
+func parseSealedBundle(data []byte) (*sealedBundle, error) {
+	var sealed sealedBundle
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Format != sealFormat {
+		return nil, errors.New("not a credentials bundle")
+	}
+	if sealed.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", sealed.Version)
+	}
+	return &sealed, nil
+}
+
+func sealWithPassphrase(plaintext, passphrase []byte, iterations int) (*sealedBundle, error) {
+	sealed := &sealedBundle{
+		Format:     sealFormat,
+		Version:    BundleVersion,
+		Encryption: sealPassphrase,
+		KDF:        "pbkdf2-sha256",
+		Iterations: iterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newBundleCipher(passphrase, sealed.Salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Ciphertext = gcm.Seal(nil, sealed.Nonce, plaintext, sealed.additionalData())
+	return sealed, nil
+}
+
+func (s *sealedBundle) openWithPassphrase(passphrase []byte) ([]byte, error) {
+	if s.KDF != "pbkdf2-sha256" || s.Iterations <= 0 {
+		return nil, fmt.Errorf("unsupported key derivation: %s", s.KDF)
+	}
+	if s.Iterations > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("too many key derivation iterations: %d, at most %d are supported", s.Iterations, maxPBKDF2Iterations)
+	}
+	gcm, err := newBundleCipher(passphrase, s.Salt, s.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid bundle nonce")
+	}
+	plaintext, err := gcm.Open(nil, s.Nonce, s.Ciphertext, s.additionalData())
+	if err != nil {
+		return nil, errors.New("cannot decrypt the bundle: wrong passphrase, or the bundle is corrupt")
+	}
+	return plaintext, nil
+}
+
+// additionalData authenticates the parameters of the encryption along with
+// the ciphertext.
+func (s *sealedBundle) additionalData() []byte {
+	return []byte(fmt.Sprintf("%s\x00%d\x00%s\x00%s\x00%d\x00%x", s.Format, s.Version, s.Encryption, s.KDF, s.Iterations, s.Salt))
+}
+
+func newBundleCipher(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealForRecipients(plaintext []byte, recipients []string) (*sealedBundle, error) {
+	args := []string{"--encrypt"}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	ciphertext, err := runAge(args, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedBundle{
+		Format:     sealFormat,
+		Version:    BundleVersion,
+		Encryption: sealAge,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// runAge runs the age program set in the DOCKER_CREDENTIAL_HELPERS_AGE
+// environment variable, or age, with input on its stdin.
+func runAge(args []string, input []byte) ([]byte, error) {
+	program := os.Getenv(envAge)
+	if program == "" {
+		program = "age"
+	}
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("age: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("age: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBundle() *Bundle {
+	return &Bundle{
+		Version: BundleVersion,
+		Helper:  "docker-credential-test",
+		Label:   CredsLabel,
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Credentials: []Credentials{
+			{ServerURL: "ghcr.io", Username: "octocat", Secret: "hunter2"},
+			{ServerURL: "https://registry.example.com", Username: "foo", Secret: "bar"},
+		},
+	}
+}
+
+func TestSealBundle(t *testing.T) {
+	b := testBundle()
+	data, err := SealBundle(b, BundleKey{Passphrase: []byte("correct horse")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") || strings.Contains(string(data), "octocat") {
+		t.Fatal("expected bundle to be encrypted")
+	}
+	if encryption, err := bundleEncryption(data); err != nil || encryption != sealPassphrase {
+		t.Errorf("expected bundle encrypted with a passphrase, got %q, %v", encryption, err)
+	}
+
+	opened, err := OpenBundle(data, BundleKey{Passphrase: []byte("correct horse")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opened, b) {
+		t.Errorf("expected %+v, got %+v", b, opened)
+	}
+
+	if _, err := OpenBundle(data, BundleKey{Passphrase: []byte("wrong horse")}); err == nil {
+		t.Error("expected error with wrong passphrase")
+	}
+	if _, err := OpenBundle(data, BundleKey{}); err == nil {
+		t.Error("expected error without passphrase")
+	}
+	if _, err := SealBundle(b, BundleKey{}); err == nil {
+		t.Error("expected error without passphrase or recipient")
+	}
+}
+
+func TestOpenBundleTampered(t *testing.T) {
+	plaintext, _ := json.Marshal(testBundle())
+	tamper := []func(s *sealedBundle){
+		func(s *sealedBundle) { s.Ciphertext[0] ^= 1 },
+		func(s *sealedBundle) { s.Salt[0] ^= 1 },
+		func(s *sealedBundle) { s.Iterations++ },
+		func(s *sealedBundle) { s.Iterations = 100 * pbkdf2Iterations },
+		func(s *sealedBundle) { s.Nonce = s.Nonce[1:] },
+		func(s *sealedBundle) { s.KDF = "md5" },
+		func(s *sealedBundle) { s.Version = BundleVersion + 1 },
+	}
+	for i, f := range tamper {
+		sealed, err := sealWithPassphrase(plaintext, []byte("passphrase"), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(sealed)
+		data, _ := json.Marshal(sealed)
+		if _, err := OpenBundle(data, BundleKey{Passphrase: []byte("passphrase")}); err == nil {
+			t.Errorf("%d: expected error opening tampered bundle", i)
+		}
+	}
+
+	if _, err := OpenBundle([]byte(`{"credentials": []}`), BundleKey{Passphrase: []byte("passphrase")}); err == nil {
+		t.Error("expected error opening unencrypted file")
+	}
+}
+
+// fakeAge "encrypts" by recording the recipients and encoding the input in
+// base64, and only decrypts with the identity file holding fake-identity.
+const fakeAge = `#!/bin/sh
+if [ "$1" = "--encrypt" ]; then
+	echo "$*"
+	base64
+else
+	[ "$(cat "$3")" = "fake-identity" ] || { echo "no identity matched any of the recipients" >&2; exit 1; }
+	tail -n +2 | base64 -d
+fi
+`
+
+func TestSealBundleAge(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake age is a shell script")
+	}
+	dir := t.TempDir()
+	program := filepath.Join(dir, "age")
+	if err := os.WriteFile(program, []byte(fakeAge), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envAge, program)
+	identity := filepath.Join(dir, "identity")
+	if err := os.WriteFile(identity, []byte("fake-identity"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wrongIdentity := filepath.Join(dir, "wrong")
+	if err := os.WriteFile(wrongIdentity, []byte("other-identity"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := testBundle()
+	data, err := SealBundle(b, BundleKey{Recipients: []string{"age1alice", "age1bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := parseSealedBundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed.Encryption != sealAge || !strings.HasPrefix(string(sealed.Ciphertext), "--encrypt --recipient age1alice --recipient age1bob\n") {
+		t.Errorf("unexpected sealed bundle %+v", sealed)
+	}
+
+	opened, err := OpenBundle(data, BundleKey{Identities: []string{identity}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opened, b) {
+		t.Errorf("expected %+v, got %+v", b, opened)
+	}
+
+	_, err = OpenBundle(data, BundleKey{Identities: []string{wrongIdentity}})
+	if err == nil || !strings.Contains(err.Error(), "no identity matched") {
+		t.Errorf("expected age error, got %v", err)
+	}
+	if _, err := OpenBundle(data, BundleKey{Passphrase: []byte("passphrase")}); err == nil {
+		t.Error("expected error without identity")
+	}
+}
@@ -0,0 +1,18 @@
+package credentials
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// readPassword reads a line from the terminal f with echo disabled.
+func readPassword(f *os.File) (string, error) {
+	b, err := term.ReadPassword(int(f.Fd()))
+	return string(b), err
+}
@@ -0,0 +1,192 @@
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/internal/registryauth"
+	"github.com/docker/docker-credential-helpers/registryurl"
+)
+
+// verifyClientID identifies VerifyCredentials to token services, which
+// require a client ID for refresh token grants.
+const verifyClientID = "docker-credential-helpers"
+
+// Statuses of a Verification.
+const (
+	// VerifyValid means the registry accepted the credentials.
+	VerifyValid = "valid"
+	// VerifyInvalid means the registry, or its token service, rejected the
+	// credentials.
+	VerifyInvalid = "invalid"
+	// VerifyExpired means the credentials expired.
+	VerifyExpired = "expired"
+	// VerifyUnreachable means the credentials could not be checked, as the
+	// registry could not be reached, or did not answer as a registry.
+	VerifyUnreachable = "unreachable"
+)
+
+// Verification is the outcome of VerifyCredentials. It never holds the
+// secret.
+type Verification struct {
+	ServerURL string
+	Username  string
+	Status    string
+	// Detail explains the status, such as the error reaching the registry.
+	Detail string `json:",omitempty"`
+}
+
+// VerifyCredentials checks creds against the registry they are for: it
+// answers the authentication challenge of the /v2/ endpoint of the
+// registry with them, exchanging them for a token with the token service
+// of the registry if it requires one. Requests are sent with rt.
+func VerifyCredentials(ctx context.Context, rt http.RoundTripper, creds *Credentials) Verification {
+	v := Verification{ServerURL: creds.ServerURL, Username: creds.Username, Status: VerifyUnreachable}
+	if expiry, ok := jwtExpiry(creds.Secret); ok && time.Now().After(expiry) {
+		v.Status, v.Detail = VerifyExpired, "token expired on "+expiry.UTC().Format(time.RFC3339)
+		return v
+	}
+
+	endpoint, err := registryEndpoint(creds.ServerURL)
+	if err != nil {
+		v.Detail = err.Error()
+		return v
+	}
+	resp, err := getEndpoint(ctx, rt, endpoint, "")
+	if err != nil {
+		v.Detail = err.Error()
+		return v
+	}
+	registryauth.CloseResponse(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		v.Status, v.Detail = VerifyValid, "the registry does not require authentication"
+		return v
+	case http.StatusUnauthorized:
+	default:
+		v.Detail = "unexpected response from the registry: " + resp.Status
+		return v
+	}
+
+	c, ok := registryauth.PreferredChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok {
+		v.Detail = "unsupported authentication challenge"
+		return v
+	}
+	var authorization string
+	switch c.Scheme {
+	case "basic":
+		if creds.Username == tokenUsername {
+			v.Status, v.Detail = VerifyInvalid, "the registry does not accept identity tokens"
+			return v
+		}
+		r := &http.Request{Header: http.Header{}}
+		r.SetBasicAuth(creds.Username, creds.Secret)
+		authorization = r.Header.Get("Authorization")
+	case "bearer":
+		r := registryauth.TokenRequest{Challenge: c, ClientID: verifyClientID}
+		if u, err := url.Parse(endpoint); err == nil {
+			r.RegistryScheme = u.Scheme
+		}
+		if creds.Username == tokenUsername {
+			r.RefreshToken = creds.Secret
+		} else {
+			r.Username, r.Password = creds.Username, creds.Secret
+		}
+		token, err := registryauth.FetchToken(ctx, rt, r)
+		if err != nil {
+			var statusErr *registryauth.StatusError
+			if errors.As(err, &statusErr) {
+				switch statusErr.StatusCode {
+				case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+					v.Status = VerifyInvalid
+					if strings.Contains(strings.ToLower(statusErr.Body), "expired") {
+						v.Status = VerifyExpired
+					}
+				}
+			}
+			v.Detail = err.Error()
+			return v
+		}
+		authorization = "Bearer " + token.Token
+	}
+
+	resp, err = getEndpoint(ctx, rt, endpoint, authorization)
+	if err != nil {
+		v.Detail = err.Error()
+		return v
+	}
+	registryauth.CloseResponse(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		v.Status, v.Detail = VerifyValid, ""
+	case http.StatusUnauthorized, http.StatusForbidden:
+		v.Status, v.Detail = VerifyInvalid, "the registry rejected the credentials"
+	default:
+		v.Detail = "unexpected response from the registry: " + resp.Status
+	}
+	return v
+}
+
+// registryEndpoint returns the URL of the /v2/ endpoint of the registry at
+// serverURL. Registries are reached with HTTPS, unless serverURL has the
+// http scheme.
+func registryEndpoint(serverURL string) (string, error) {
+	u, err := registryurl.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+	scheme := "https"
+	if u.Scheme == "http" {
+		scheme = "http"
+	}
+	host := u.Host
+	switch u.Hostname() {
+	case "docker.io", "index.docker.io":
+		host = "registry-1.docker.io"
+	}
+	return scheme + "://" + host + "/v2/", nil
+}
+
+// getEndpoint sends a GET request to endpoint, with the given
+// Authorization header if any.
+func getEndpoint(ctx context.Context, rt http.RoundTripper, endpoint, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return rt.RoundTrip(req)
+}
+
+// jwtExpiry returns the expiry of secret, if it is a JSON web token with
+// an expiry.
+func jwtExpiry(secret string) (time.Time, bool) {
+	parts := strings.Split(secret, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == "" {
+		return time.Time{}, false
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
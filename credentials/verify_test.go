@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRegistry starts a registry stand-in requiring a bearer token from
+// its token service, which accepts foo:bar and the refresh-token identity
+// token, and reports old:bar as expired. With basic set, the registry
+// requires Basic authentication with foo:bar instead.
+func newTestRegistry(t *testing.T, basic bool) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if r.FormValue("refresh_token") != "refresh-token" {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "registry-token"}`))
+			return
+		}
+		switch username, password, _ := r.BasicAuth(); {
+		case username == "foo" && password == "bar":
+			_, _ = w.Write([]byte(`{"token": "registry-token"}`))
+		case username == "old":
+			http.Error(w, `{"details": "access token has expired"}`, http.StatusUnauthorized)
+		default:
+			http.Error(w, `{"details": "incorrect username or password"}`, http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if basic {
+			if username, password, ok := r.BasicAuth(); !ok || username != "foo" || password != "bar" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testJWT(expiry time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub": "foo", "exp": %d}`, expiry.Unix())))
+	return "eyJhbGciOiJub25lIn0." + payload + ".c2lnbmF0dXJl"
+}
+
+func TestVerifyCredentials(t *testing.T) {
+	bearer := newTestRegistry(t, false)
+	basic := newTestRegistry(t, true)
+	open := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(open.Close)
+	closed := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		creds  Credentials
+		status string
+	}{
+		{creds: Credentials{ServerURL: bearer.URL, Username: "foo", Secret: "bar"}, status: VerifyValid},
+		{creds: Credentials{ServerURL: bearer.URL, Username: "foo", Secret: "wrong"}, status: VerifyInvalid},
+		{creds: Credentials{ServerURL: bearer.URL, Username: "old", Secret: "bar"}, status: VerifyExpired},
+		{creds: Credentials{ServerURL: bearer.URL, Username: tokenUsername, Secret: "refresh-token"}, status: VerifyValid},
+		{creds: Credentials{ServerURL: bearer.URL, Username: tokenUsername, Secret: "revoked"}, status: VerifyInvalid},
+		{creds: Credentials{ServerURL: bearer.URL, Username: "foo", Secret: testJWT(time.Now().Add(-time.Hour))}, status: VerifyExpired},
+		{creds: Credentials{ServerURL: basic.URL, Username: "foo", Secret: "bar"}, status: VerifyValid},
+		{creds: Credentials{ServerURL: basic.URL, Username: "foo", Secret: "wrong"}, status: VerifyInvalid},
+		{creds: Credentials{ServerURL: basic.URL, Username: tokenUsername, Secret: "refresh-token"}, status: VerifyInvalid},
+		{creds: Credentials{ServerURL: open.URL, Username: "foo", Secret: "bar"}, status: VerifyValid},
+		{creds: Credentials{ServerURL: closed.URL, Username: "foo", Secret: "bar"}, status: VerifyUnreachable},
+		{creds: Credentials{ServerURL: "ftp://registry.example.com", Username: "foo", Secret: "bar"}, status: VerifyUnreachable},
+	}
+	for _, tc := range tests {
+		v := VerifyCredentials(context.Background(), http.DefaultTransport, &tc.creds)
+		if v.Status != tc.status {
+			t.Errorf("%s (%s): expected %s, got %s: %s", tc.creds.ServerURL, tc.creds.Username, tc.status, v.Status, v.Detail)
+		}
+		if v.ServerURL != tc.creds.ServerURL || v.Username != tc.creds.Username {
+			t.Errorf("expected verification of %s (%s), got %+v", tc.creds.ServerURL, tc.creds.Username, v)
+		}
+		if strings.Contains(v.Detail, tc.creds.Secret) {
+			t.Errorf("expected secret not to be in detail %q", v.Detail)
+		}
+	}
+}
+
+func TestRegistryEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"https://index.docker.io/v1/":  "https://registry-1.docker.io/v2/",
+		"docker.io":                    "https://registry-1.docker.io/v2/",
+		"ghcr.io":                      "https://ghcr.io/v2/",
+		"registry.example.com:5000/v1": "https://registry.example.com:5000/v2/",
+		"http://localhost:5000":        "http://localhost:5000/v2/",
+	}
+	for serverURL, expected := range tests {
+		if endpoint, err := registryEndpoint(serverURL); err != nil || endpoint != expected {
+			t.Errorf("%s: expected %s, got %s, %v", serverURL, expected, endpoint, err)
+		}
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	expiry := time.Unix(1700000000, 0)
+	if exp, ok := jwtExpiry(testJWT(expiry)); !ok || !exp.Equal(expiry) {
+		t.Errorf("expected %v, got %v, %v", expiry, exp, ok)
+	}
+	for _, secret := range []string{"secret", "a.b.c", "eyJhbGciOiJub25lIn0.e30.c2ln"} {
+		if _, ok := jwtExpiry(secret); ok {
+			t.Errorf("%s: expected no expiry", secret)
+		}
+	}
+}
@@ -9,7 +9,12 @@ retract (
 
 require (
 	github.com/danieljoos/wincred v1.2.3
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/keybase/go-keychain v0.0.1
+	golang.org/x/crypto v0.32.0
 )
 
-require golang.org/x/sys v0.20.0 // indirect
+require (
+	golang.org/x/sys v0.29.0
+	golang.org/x/term v0.28.0
+)
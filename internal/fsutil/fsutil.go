@@ -0,0 +1,44 @@
+// Package fsutil implements the file handling shared by the helpers'
+// on-disk state: where it is kept, and how files are replaced.
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// RuntimeDir returns the directory holding the helpers' runtime state,
+// in $XDG_RUNTIME_DIR if set, or in the user's cache directory otherwise.
+func RuntimeDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserCacheDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "docker-credential-helpers"), nil
+}
+
+// WriteFileAtomic replaces the file at path with data, through a temporary
+// file renamed over it, so that readers never see a partial file. The file
+// is only accessible to the user.
+func WriteFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
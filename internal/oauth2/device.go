@@ -0,0 +1,156 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultPollInterval is the interval between polls of the token endpoint
+// when the authorization server does not set one, as defined by RFC 8628.
+const defaultPollInterval = 5 * time.Second
+
+// slowDownIncrease is added to the polling interval when the authorization
+// server asks to slow down. It is a variable so tests can shorten it.
+var slowDownIncrease = 5 * time.Second
+
+// deviceCodeGrantType is the grant type of the device authorization grant.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Metadata holds the endpoints of an authorization server, as published in
+// its OpenID Connect discovery document.
+type Metadata struct {
+	Issuer                      string `json:"issuer"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// Discover fetches the metadata of the authorization server of issuer.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oauth2: discovery of %s returned %s", issuer, resp.Status)
+	}
+	var md Metadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&md); err != nil {
+		return nil, fmt.Errorf("oauth2: invalid discovery document of %s: %w", issuer, err)
+	}
+	if md.TokenEndpoint == "" {
+		return nil, fmt.Errorf("oauth2: no token endpoint in discovery document of %s", issuer)
+	}
+	return &md, nil
+}
+
+// DeviceAuthorization is the response of a device authorization endpoint
+// (RFC 8628).
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn is the lifetime of the device code, in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+	// Interval is the minimum interval between polls of the token
+	// endpoint, in seconds.
+	Interval int64 `json:"interval,omitempty"`
+}
+
+// PollInterval returns the interval between polls of the token endpoint.
+func (a *DeviceAuthorization) PollInterval() time.Duration {
+	if a.Interval <= 0 {
+		return defaultPollInterval
+	}
+	return time.Duration(a.Interval) * time.Second
+}
+
+// Expiry returns the time the device code expires, if issued at the given
+// time.
+func (a *DeviceAuthorization) Expiry(issued time.Time) time.Time {
+	return issued.Add(time.Duration(a.ExpiresIn) * time.Second)
+}
+
+// RequestDeviceAuthorization starts the device authorization grant with
+// the device authorization endpoint at deviceURL.
+func RequestDeviceAuthorization(ctx context.Context, client *http.Client, deviceURL, clientID, scope string) (*DeviceAuthorization, error) {
+	params := url.Values{"client_id": {clientID}}
+	if scope != "" {
+		params.Set("scope", scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, deviceURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(body, e)
+		return nil, e
+	}
+	var a DeviceAuthorization
+	if err := json.Unmarshal(body, &a); err != nil {
+		return nil, fmt.Errorf("oauth2: invalid device authorization response: %w", err)
+	}
+	if a.DeviceCode == "" || a.UserCode == "" || a.VerificationURI == "" {
+		return nil, errors.New("oauth2: incomplete device authorization response")
+	}
+	return &a, nil
+}
+
+// PollDeviceToken polls the token endpoint at tokenURL every interval
+// until the user authorizes the device, denies it, or ctx is done. The
+// interval is increased when the authorization server asks to slow down.
+func PollDeviceToken(ctx context.Context, client *http.Client, tokenURL, clientID, deviceCode string, interval time.Duration) (*Token, error) {
+	params := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {deviceCode},
+		"client_id":   {clientID},
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		token, err := RequestToken(ctx, client, tokenURL, params)
+		var e *Error
+		if !errors.As(err, &e) {
+			return token, err
+		}
+		switch e.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownIncrease
+		default:
+			return nil, err
+		}
+		timer.Reset(interval)
+	}
+}
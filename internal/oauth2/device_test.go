@@ -0,0 +1,115 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newDeviceServer starts an authorization server answering polls with the
+// given errors, in order, before issuing a token.
+func newDeviceServer(t *testing.T, pending ...string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var polls []string
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(Metadata{
+			Issuer:                      server.URL,
+			TokenEndpoint:               server.URL + "/token",
+			DeviceAuthorizationEndpoint: server.URL + "/device",
+		})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "cli" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"device_code": "device", "user_code": "ABCD-EFGH", "verification_uri": "https://sso.example.com/device", "expires_in": 600}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls = append(polls, r.FormValue("grant_type")+" "+r.FormValue("device_code"))
+		if len(pending) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(Error{Code: pending[0]})
+			pending = pending[1:]
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 300}`))
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &polls
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	server, polls := newDeviceServer(t, "authorization_pending", "authorization_pending")
+	ctx := context.Background()
+
+	md, err := Discover(ctx, server.Client(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.DeviceAuthorizationEndpoint != server.URL+"/device" || md.TokenEndpoint != server.URL+"/token" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+
+	a, err := RequestDeviceAuthorization(ctx, server.Client(), md.DeviceAuthorizationEndpoint, "cli", "openid offline_access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.UserCode != "ABCD-EFGH" || a.PollInterval() != defaultPollInterval {
+		t.Errorf("unexpected device authorization: %+v", a)
+	}
+	issued := time.Unix(1700000000, 0)
+	if expiry := a.Expiry(issued); !expiry.Equal(issued.Add(10 * time.Minute)) {
+		t.Errorf("expected expiry in 10 minutes, got %v", expiry)
+	}
+
+	token, err := PollDeviceToken(ctx, server.Client(), md.TokenEndpoint, "cli", a.DeviceCode, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("unexpected token: %+v", token)
+	}
+	if len(*polls) != 3 || (*polls)[0] != deviceCodeGrantType+" device" {
+		t.Errorf("expected 3 polls with the device code, got %q", *polls)
+	}
+
+	if _, err := RequestDeviceAuthorization(ctx, server.Client(), md.DeviceAuthorizationEndpoint, "other", ""); err == nil {
+		t.Error("expected error with unknown client")
+	}
+}
+
+func TestPollDeviceTokenErrors(t *testing.T) {
+	defer func(d time.Duration) { slowDownIncrease = d }(slowDownIncrease)
+	slowDownIncrease = 50 * time.Millisecond
+
+	server, _ := newDeviceServer(t, "slow_down", "access_denied")
+	start := time.Now()
+	_, err := PollDeviceToken(context.Background(), server.Client(), server.URL+"/token", "cli", "device", time.Millisecond)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "access_denied" {
+		t.Errorf("expected access denied, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < slowDownIncrease {
+		t.Errorf("expected polling to slow down, took %v", elapsed)
+	}
+
+	server, _ = newDeviceServer(t, "authorization_pending", "authorization_pending", "authorization_pending")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := PollDeviceToken(ctx, server.Client(), server.URL+"/token", "cli", "device", 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Token types of RFC 8693.
+const (
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeGrantType is the grant type of the token exchange grant.
+const tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// TokenExchange is a token exchange request (RFC 8693).
+type TokenExchange struct {
+	SubjectToken     string
+	SubjectTokenType string
+	// Audience and Resource identify the service the requested token is
+	// for. They are optional.
+	Audience string
+	Resource string
+	Scope    string
+	// RequestedTokenType is the type of the requested token. It is
+	// optional.
+	RequestedTokenType string
+	ClientID           string
+}
+
+// Exchange exchanges a token, such as an OpenID Connect token issued to a
+// CI job, for another token at the token endpoint at tokenURL.
+func Exchange(ctx context.Context, client *http.Client, tokenURL string, r TokenExchange) (*Token, error) {
+	params := url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"subject_token":      {r.SubjectToken},
+		"subject_token_type": {r.SubjectTokenType},
+	}
+	for name, value := range map[string]string{
+		"audience":             r.Audience,
+		"resource":             r.Resource,
+		"scope":                r.Scope,
+		"requested_token_type": r.RequestedTokenType,
+		"client_id":            r.ClientID,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	return RequestToken(ctx, client, tokenURL, params)
+}
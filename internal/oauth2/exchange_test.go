@@ -0,0 +1,41 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExchange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != tokenExchangeGrantType || r.FormValue("subject_token") != "ci-token" ||
+			r.FormValue("subject_token_type") != TokenTypeJWT || r.FormValue("audience") != "registry.example.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_request"}`))
+			return
+		}
+		if _, ok := r.Form["resource"]; ok {
+			http.Error(w, `{"error": "invalid_target"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "registry-token", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "token_type": "Bearer", "expires_in": 600}`))
+	}))
+	t.Cleanup(server.Close)
+
+	token, err := Exchange(context.Background(), server.Client(), server.URL, TokenExchange{
+		SubjectToken:     "ci-token",
+		SubjectTokenType: TokenTypeJWT,
+		Audience:         "registry.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "registry-token" || token.IssuedTokenType != TokenTypeAccessToken || token.ExpiresIn != 600 {
+		t.Errorf("unexpected token: %+v", token)
+	}
+
+	if _, err := Exchange(context.Background(), server.Client(), server.URL, TokenExchange{SubjectToken: "other", SubjectTokenType: TokenTypeJWT}); err == nil {
+		t.Error("expected error with rejected subject token")
+	}
+}
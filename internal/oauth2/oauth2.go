@@ -0,0 +1,110 @@
+// Package oauth2 implements the requests to OAuth2 authorization servers
+// needed by the helpers: the refresh token grant (RFC 6749), the device
+// authorization grant (RFC 8628) along with OpenID Connect discovery, and
+// token exchange (RFC 8693).
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxResponseSize bounds the size of the responses of token endpoints.
+const maxResponseSize = 1 << 20
+
+// Token is a successful response of a token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// ExpiresIn is the lifetime of the access token, in seconds. It is zero
+	// if the authorization server did not set it.
+	ExpiresIn int64  `json:"expires_in,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	// IssuedTokenType is the type of the token issued by a token exchange.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// Expiry returns the time the access token expires, if issued at the given
+// time, or the zero time if its lifetime is unknown.
+func (t *Token) Expiry(issued time.Time) time.Time {
+	if t.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return issued.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// Error is an error response of an authorization server.
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := "oauth2: " + e.Code
+	if e.Code == "" {
+		msg = fmt.Sprintf("oauth2: token endpoint returned status %d", e.StatusCode)
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// RequestToken posts a token request with the given parameters to the
+// token endpoint at tokenURL.
+func RequestToken(ctx context.Context, client *http.Client, tokenURL string, params url.Values) (*Token, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(body, e)
+		return nil, e
+	}
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oauth2: invalid token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("oauth2: no access token in token response")
+	}
+	return &token, nil
+}
+
+// Refresh exchanges a refresh token for an access token. The response
+// holds a new refresh token if the authorization server rotates them.
+func Refresh(ctx context.Context, client *http.Client, tokenURL, clientID, refreshToken, scope string) (*Token, error) {
+	params := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	if clientID != "" {
+		params.Set("client_id", clientID)
+	}
+	if scope != "" {
+		params.Set("scope", scope)
+	}
+	return RequestToken(ctx, client, tokenURL, params)
+}
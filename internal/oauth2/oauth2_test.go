@@ -0,0 +1,75 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("grant_type") != "refresh_token" || r.FormValue("client_id") != "cli" {
+			http.Error(w, `{"error": "invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("refresh_token") != "refresh-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token is not active"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "token_type": "Bearer", "refresh_token": "refresh-2", "expires_in": 300, "scope": "` + r.FormValue("scope") + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+
+	token, err := Refresh(ctx, server.Client(), server.URL, "cli", "refresh-1", "openid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Token{AccessToken: "access", TokenType: "Bearer", RefreshToken: "refresh-2", ExpiresIn: 300, Scope: "openid"}
+	if *token != expected {
+		t.Errorf("expected %+v, got %+v", expected, *token)
+	}
+	issued := time.Unix(1700000000, 0)
+	if expiry := token.Expiry(issued); !expiry.Equal(issued.Add(5 * time.Minute)) {
+		t.Errorf("expected expiry in 5 minutes, got %v", expiry)
+	}
+
+	_, err = Refresh(ctx, server.Client(), server.URL, "cli", "revoked", "")
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "invalid_grant" || e.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected invalid_grant error, got %v", err)
+	}
+	if e.Error() != "oauth2: invalid_grant: Token is not active" {
+		t.Errorf("unexpected error message: %v", e)
+	}
+}
+
+func TestRequestTokenErrors(t *testing.T) {
+	var body string
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	for _, tc := range []struct {
+		status int
+		body   string
+		err    string
+	}{
+		{status: http.StatusInternalServerError, body: "oops", err: "oauth2: token endpoint returned status 500"},
+		{status: http.StatusOK, body: "{}", err: "oauth2: no access token in token response"},
+		{status: http.StatusOK, body: "<html>", err: "oauth2: invalid token response: invalid character '<' looking for beginning of value"},
+	} {
+		status, body = tc.status, tc.body
+		_, err := RequestToken(context.Background(), server.Client(), server.URL, nil)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("expected %q, got %v", tc.err, err)
+		}
+	}
+}
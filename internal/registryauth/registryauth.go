@@ -0,0 +1,252 @@
+// Package registryauth implements the parts of the authentication of
+// clients to registries following the OCI distribution specification that
+// are shared by the client transport and the verify command: parsing
+// WWW-Authenticate challenges, and requesting bearer tokens from token
+// services.
+package registryauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultTokenExpiry is the lifetime of bearer tokens issued without an
+// expiry, as defined by the token authentication specification.
+const defaultTokenExpiry = 60 * time.Second
+
+// tokenExpiryLeeway is subtracted from the lifetime of bearer tokens, so
+// they are renewed before the registry rejects them.
+const tokenExpiryLeeway = 5 * time.Second
+
+// maxErrorBody is the size of the body of failed responses kept in a
+// StatusError.
+const maxErrorBody = 1024
+
+// Challenge is an authentication challenge from a WWW-Authenticate header.
+type Challenge struct {
+	// Scheme is the authentication scheme, in lower case.
+	Scheme string
+	// Params are the parameters of the challenge, with their names in
+	// lower case.
+	Params map[string]string
+}
+
+// ParseChallenges parses the challenges of a WWW-Authenticate header, such
+// as Bearer realm="https://auth.example.com/token",service="example.com".
+func ParseChallenges(header string) []Challenge {
+	var challenges []Challenge
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return challenges
+		}
+		var token string
+		token, s = cutToken(s)
+		if token == "" {
+			return challenges
+		}
+		if strings.HasPrefix(strings.TrimLeft(s, " \t"), "=") {
+			// A parameter without a challenge.
+			if len(challenges) == 0 {
+				return nil
+			}
+			s = strings.TrimLeft(s, " \t")[1:]
+			var value string
+			value, s = cutValue(strings.TrimLeft(s, " \t"))
+			challenges[len(challenges)-1].Params[strings.ToLower(token)] = value
+			continue
+		}
+		challenges = append(challenges, Challenge{Scheme: strings.ToLower(token), Params: map[string]string{}})
+	}
+}
+
+// PreferredChallenge returns the challenge to answer among the values of
+// WWW-Authenticate headers, preferring Bearer over Basic.
+func PreferredChallenge(headers []string) (Challenge, bool) {
+	var basic *Challenge
+	for _, h := range headers {
+		for _, c := range ParseChallenges(h) {
+			switch c.Scheme {
+			case "bearer":
+				return c, true
+			case "basic":
+				if basic == nil {
+					c := c
+					basic = &c
+				}
+			}
+		}
+	}
+	if basic != nil {
+		return *basic, true
+	}
+	return Challenge{}, false
+}
+
+// cutToken returns the token at the start of s, and the rest of s.
+func cutToken(s string) (string, string) {
+	i := strings.IndexAny(s, " \t,=\"")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+// cutValue returns the token or quoted string at the start of s, unquoted,
+// and the rest of s.
+func cutValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		return cutToken(s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// TokenRequest is a request for a bearer token answering a Bearer
+// challenge.
+type TokenRequest struct {
+	Challenge Challenge
+	Scopes    []string
+
+	// Username and Password are sent with Basic authentication, if set.
+	Username string
+	Password string
+	// RefreshToken is exchanged for the token with the OAuth2 refresh
+	// token grant, if set, instead of Basic authentication.
+	RefreshToken string
+	// ClientID identifies the client to the token service, which requires
+	// one for refresh token grants.
+	ClientID string
+	// RegistryScheme is the scheme of the URL the registry sent the
+	// challenge for. Only registries served over http may have a realm
+	// served over http, so that credentials sent to the token service of
+	// an https registry are not sent in the clear.
+	RegistryScheme string
+}
+
+// Token is a bearer token issued by a token service.
+type Token struct {
+	Token   string
+	Expires time.Time
+}
+
+// StatusError is returned by FetchToken when the token service fails.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Body is the start of the body of the response.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return "token service returned " + e.Status
+}
+
+// FetchToken requests a bearer token from the token service of a Bearer
+// challenge, with rt.
+func FetchToken(ctx context.Context, rt http.RoundTripper, r TokenRequest) (Token, error) {
+	realm, err := url.Parse(r.Challenge.Params["realm"])
+	if err != nil || (realm.Scheme != "https" && realm.Scheme != "http") {
+		return Token{}, fmt.Errorf("invalid realm %q", r.Challenge.Params["realm"])
+	}
+	if realm.Scheme == "http" && r.RegistryScheme != "http" {
+		return Token{}, fmt.Errorf("refusing realm %q: the registry is not served over http", r.Challenge.Params["realm"])
+	}
+	service := r.Challenge.Params["service"]
+
+	var req *http.Request
+	if r.RefreshToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {r.RefreshToken},
+			"client_id":     {r.ClientID},
+			"service":       {service},
+			"scope":         {strings.Join(r.Scopes, " ")},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return Token{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		q := realm.Query()
+		if service != "" {
+			q.Set("service", service)
+		}
+		for _, s := range r.Scopes {
+			q.Add("scope", s)
+		}
+		realm.RawQuery = q.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return Token{}, err
+		}
+		if r.Username != "" {
+			req.SetBasicAuth(r.Username, r.Password)
+		}
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer CloseResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return Token{}, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+	var body struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Token{}, fmt.Errorf("invalid token response: %w", err)
+	}
+	token := Token{Token: body.Token}
+	if token.Token == "" {
+		token.Token = body.AccessToken
+	}
+	if token.Token == "" {
+		return Token{}, errors.New("no token in token response")
+	}
+	expiresIn := defaultTokenExpiry
+	if body.ExpiresIn > 0 {
+		expiresIn = time.Duration(body.ExpiresIn) * time.Second
+	}
+	issued := body.IssuedAt
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	token.Expires = issued.Add(expiresIn - tokenExpiryLeeway)
+	return token, nil
+}
+
+// CloseResponse drains and closes the body of resp, so its connection can
+// be reused.
+func CloseResponse(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+}
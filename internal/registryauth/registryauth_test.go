@@ -0,0 +1,100 @@
+package registryauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := map[string][]Challenge{
+		`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:foo/bar:pull,push"`: {
+			{Scheme: "bearer", Params: map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:foo/bar:pull,push"}},
+		},
+		`Basic realm="Registry \"realm\""`: {
+			{Scheme: "basic", Params: map[string]string{"realm": `Registry "realm"`}},
+		},
+		`Basic realm=registry, Bearer realm="https://auth.example.com/token"`: {
+			{Scheme: "basic", Params: map[string]string{"realm": "registry"}},
+			{Scheme: "bearer", Params: map[string]string{"realm": "https://auth.example.com/token"}},
+		},
+		`realm="registry"`: nil,
+	}
+	for header, expected := range tests {
+		if challenges := ParseChallenges(header); !reflect.DeepEqual(challenges, expected) {
+			t.Errorf("%s: expected %v, got %v", header, expected, challenges)
+		}
+	}
+
+	c, ok := PreferredChallenge([]string{`Basic realm="registry"`, `Bearer realm="https://auth.example.com/token"`})
+	if !ok || c.Scheme != "bearer" {
+		t.Errorf("expected bearer challenge to be preferred, got %v", c)
+	}
+	if _, ok := PreferredChallenge([]string{`Negotiate`}); ok {
+		t.Error("expected no supported challenge")
+	}
+}
+
+func TestFetchToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("refresh_token") != "refresh-token" || r.PostForm.Get("client_id") != "test" {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"access_token": "access:%s", "expires_in": 3600}`, r.PostForm.Get("scope"))
+		default:
+			username, password, ok := r.BasicAuth()
+			if ok && (username != "foo" || password != "bar") {
+				http.Error(w, `{"details": "incorrect username or password"}`, http.StatusUnauthorized)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"token": "token:%s:%s:%v", "issued_at": "2024-01-02T03:04:05Z"}`, r.URL.Query().Get("service"), username, r.URL.Query()["scope"])
+		}
+	}))
+	t.Cleanup(server.Close)
+	c := Challenge{Scheme: "bearer", Params: map[string]string{"realm": server.URL + "/token", "service": "registry.example.com"}}
+	ctx := context.Background()
+
+	token, err := FetchToken(ctx, http.DefaultTransport, TokenRequest{Challenge: c, Scopes: []string{"repository:a:pull", "repository:b:pull"}, Username: "foo", Password: "bar", RegistryScheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Token{
+		Token:   "token:registry.example.com:foo:[repository:a:pull repository:b:pull]",
+		Expires: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Add(defaultTokenExpiry - tokenExpiryLeeway),
+	}
+	if token != expected {
+		t.Errorf("expected %v, got %v", expected, token)
+	}
+
+	token, err = FetchToken(ctx, http.DefaultTransport, TokenRequest{Challenge: c, Scopes: []string{"repository:a:pull"}, RefreshToken: "refresh-token", ClientID: "test", RegistryScheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "access:repository:a:pull" || time.Until(token.Expires) < 50*time.Minute {
+		t.Errorf("expected access token for an hour, got %v", token)
+	}
+
+	_, err = FetchToken(ctx, http.DefaultTransport, TokenRequest{Challenge: c, Username: "foo", Password: "wrong", RegistryScheme: "http"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized || statusErr.Body == "" {
+		t.Errorf("expected unauthorized status error, got %v", err)
+	}
+
+	// Credentials for an https registry are not sent over http.
+	if _, err := FetchToken(ctx, http.DefaultTransport, TokenRequest{Challenge: c, Username: "foo", Password: "bar", RegistryScheme: "https"}); err == nil {
+		t.Error("expected error with an http realm for an https registry")
+	}
+
+	c.Params["realm"] = "file:///etc/passwd"
+	if _, err := FetchToken(ctx, http.DefaultTransport, TokenRequest{Challenge: c}); err == nil {
+		t.Error("expected error with invalid realm")
+	}
+}
@@ -0,0 +1,341 @@
+// Package oidc implements a credentials helper for CI jobs, which exchanges
+// the OpenID Connect token issued to the job by the CI system, such as
+// GitHub Actions or GitLab CI, for a registry credential at a token
+// exchange endpoint (RFC 8693). No long-lived registry secret needs to be
+// stored in the CI configuration.
+//
+// Exchanged tokens are cached on disk until they expire, encrypted with a
+// key derived from the CI token, so the steps of a job pulling and pushing
+// images exchange the CI token only once.
+package oidc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker-credential-helpers/internal/fsutil"
+	"github.com/docker/docker-credential-helpers/internal/oauth2"
+	"github.com/docker/docker-credential-helpers/registryurl"
+)
+
+// Environment variables configuring the helper returned by FromEnv.
+const (
+	EnvTokenURL         = "DOCKER_CREDENTIAL_OIDC_TOKEN_URL"
+	EnvTokenFile        = "DOCKER_CREDENTIAL_OIDC_TOKEN_FILE"
+	EnvTokenEnv         = "DOCKER_CREDENTIAL_OIDC_TOKEN_ENV"
+	EnvSubjectTokenType = "DOCKER_CREDENTIAL_OIDC_SUBJECT_TOKEN_TYPE"
+	EnvAudience         = "DOCKER_CREDENTIAL_OIDC_AUDIENCE"
+	EnvScope            = "DOCKER_CREDENTIAL_OIDC_SCOPE"
+	EnvClientID         = "DOCKER_CREDENTIAL_OIDC_CLIENT_ID"
+	EnvUsername         = "DOCKER_CREDENTIAL_OIDC_USERNAME"
+	EnvRegistries       = "DOCKER_CREDENTIAL_OIDC_REGISTRIES"
+)
+
+// DefaultUsername is the username returned with exchanged tokens, unless
+// another one is configured. It is the username registries accepting
+// OAuth2 access tokens as passwords commonly expect.
+const DefaultUsername = "oauth2accesstoken"
+
+// exchangeTimeout bounds the time spent exchanging the CI token.
+const exchangeTimeout = 30 * time.Second
+
+// expiryLeeway is how long before their expiry cached tokens are exchanged
+// again, so they are still valid when the registry receives them.
+const expiryLeeway = 30 * time.Second
+
+// cacheFileHeader is authenticated along with the cached token, so a file
+// written by an incompatible version is discarded.
+var cacheFileHeader = []byte("docker-credential-oidc cache v1\n")
+
+// Config configures a Helper.
+type Config struct {
+	// TokenURL is the token exchange endpoint.
+	TokenURL string
+
+	// TokenFile is the file holding the CI token. If it is not set,
+	// the CI token is read from the environment variable named by
+	// TokenEnv.
+	TokenFile string
+	TokenEnv  string
+
+	// SubjectTokenType is the type of the CI token, a JSON web token by
+	// default.
+	SubjectTokenType string
+
+	// Audience is the audience of the requested token. The hostname of
+	// the registry is used if it is not set.
+	Audience string
+	Scope    string
+	ClientID string
+
+	// Username is the username returned with exchanged tokens,
+	// DefaultUsername if it is not set.
+	Username string
+
+	// Registries are the patterns, as parsed by registryurl.ParsePattern,
+	// of the registries tokens are exchanged for. Tokens are exchanged for
+	// all registries if it is empty.
+	Registries []string
+
+	// CacheDir is the directory exchanged tokens are cached in. If it is
+	// not set, they are cached in $XDG_RUNTIME_DIR, or in the user's cache
+	// directory.
+	CacheDir string
+}
+
+// cachedToken is a token stored in the cache.
+type cachedToken struct {
+	Username string    `json:"username"`
+	Secret   string    `json:"secret"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// Helper is a credentials.Helper returning registry credentials exchanged
+// for a CI token. It does not store credentials.
+type Helper struct {
+	config     Config
+	registries []*registryurl.Pattern
+	// err is the configuration error, returned by Get.
+	err error
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// New creates a Helper exchanging tokens as configured by config.
+func New(config Config) *Helper {
+	h := &Helper{config: config, client: &http.Client{Timeout: exchangeTimeout}, now: time.Now}
+	if h.config.SubjectTokenType == "" {
+		h.config.SubjectTokenType = oauth2.TokenTypeJWT
+	}
+	if h.config.Username == "" {
+		h.config.Username = DefaultUsername
+	}
+	switch {
+	case h.config.TokenURL == "":
+		h.err = errors.New("no token exchange endpoint configured")
+	case h.config.TokenFile == "" && h.config.TokenEnv == "":
+		h.err = errors.New("no CI token configured")
+	}
+	for _, pattern := range config.Registries {
+		p, err := registryurl.ParsePattern(pattern)
+		if err != nil {
+			h.err = fmt.Errorf("invalid registry pattern %q: %w", pattern, err)
+			break
+		}
+		h.registries = append(h.registries, p)
+	}
+	return h
+}
+
+// FromEnv creates a Helper configured by the DOCKER_CREDENTIAL_OIDC_*
+// environment variables. Configuration errors are returned by Get, so
+// that they are reported to the CI job.
+func FromEnv() *Helper {
+	config := Config{
+		TokenURL:         os.Getenv(EnvTokenURL),
+		TokenFile:        os.Getenv(EnvTokenFile),
+		TokenEnv:         os.Getenv(EnvTokenEnv),
+		SubjectTokenType: os.Getenv(EnvSubjectTokenType),
+		Audience:         os.Getenv(EnvAudience),
+		Scope:            os.Getenv(EnvScope),
+		ClientID:         os.Getenv(EnvClientID),
+		Username:         os.Getenv(EnvUsername),
+	}
+	for _, pattern := range strings.Split(os.Getenv(EnvRegistries), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			config.Registries = append(config.Registries, pattern)
+		}
+	}
+	return New(config)
+}
+
+// Add refuses to store credentials.
+func (h *Helper) Add(*credentials.Credentials) error {
+	return credentials.NewErrReadOnly()
+}
+
+// Delete removes the cached token for serverURL, if any.
+func (h *Helper) Delete(serverURL string) error {
+	if h.err != nil {
+		return h.err
+	}
+	path, err := h.cacheFile(serverURL)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Get returns the credentials for serverURL, exchanging the CI token for
+// them unless a valid token is cached.
+func (h *Helper) Get(serverURL string) (string, string, error) {
+	if h.err != nil {
+		return "", "", h.err
+	}
+	u, err := registryurl.Parse(serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	if !h.matches(serverURL) {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+	subjectToken, err := h.subjectToken()
+	if err != nil {
+		return "", "", err
+	}
+	path, err := h.cacheFile(serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	if token, ok := readCache(path, subjectToken); ok && h.now().Add(expiryLeeway).Before(token.Expiry) {
+		return token.Username, token.Secret, nil
+	}
+
+	audience := h.config.Audience
+	if audience == "" {
+		audience = u.Hostname()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exchangeTimeout)
+	defer cancel()
+	issued := h.now()
+	t, err := oauth2.Exchange(ctx, h.client, h.config.TokenURL, oauth2.TokenExchange{
+		SubjectToken:     subjectToken,
+		SubjectTokenType: h.config.SubjectTokenType,
+		Audience:         audience,
+		Scope:            h.config.Scope,
+		ClientID:         h.config.ClientID,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("exchanging CI token for %s: %w", serverURL, err)
+	}
+	token := cachedToken{Username: h.config.Username, Secret: t.AccessToken, Expiry: t.Expiry(issued)}
+	// Tokens without a lifetime are exchanged on every use.
+	if !token.Expiry.IsZero() {
+		if err := writeCache(path, subjectToken, token); err != nil {
+			credentials.Logger().Debug("caching exchanged token failed", "serverURL", serverURL, "error", err)
+		}
+	}
+	return token.Username, token.Secret, nil
+}
+
+// List returns no credentials, as tokens are only exchanged on demand.
+func (h *Helper) List() (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// matches returns whether tokens are exchanged for serverURL.
+func (h *Helper) matches(serverURL string) bool {
+	if len(h.registries) == 0 {
+		return true
+	}
+	for _, p := range h.registries {
+		if p.MatchString(serverURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectToken returns the CI token.
+func (h *Helper) subjectToken() (string, error) {
+	var token string
+	if h.config.TokenFile != "" {
+		b, err := os.ReadFile(h.config.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("reading CI token: %w", err)
+		}
+		token = string(b)
+	} else {
+		token = os.Getenv(h.config.TokenEnv)
+	}
+	if token = strings.TrimSpace(token); token == "" {
+		return "", errors.New("the CI token is empty")
+	}
+	return token, nil
+}
+
+// cacheFile returns the path of the file caching the token for serverURL.
+func (h *Helper) cacheFile(serverURL string) (string, error) {
+	dir := h.config.CacheDir
+	if dir == "" {
+		var err error
+		if dir, err = fsutil.RuntimeDir(); err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256([]byte(h.config.TokenURL + " " + h.config.Audience + " " + h.config.Scope + " " + serverURL))
+	return filepath.Join(dir, "oidc-"+hex.EncodeToString(sum[:8])+".token"), nil
+}
+
+// newAEAD returns the cipher encrypting the cache with a key derived from
+// the CI token: whoever can read the CI token can exchange it anyway.
+func newAEAD(subjectToken string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("docker-credential-oidc\x00" + subjectToken))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readCache returns the token cached in path, if it was cached for
+// subjectToken.
+func readCache(path, subjectToken string) (cachedToken, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cachedToken{}, false
+	}
+	aead, err := newAEAD(subjectToken)
+	if err != nil || len(data) < aead.NonceSize() {
+		return cachedToken{}, false
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], cacheFileHeader)
+	if err != nil {
+		return cachedToken{}, false
+	}
+	var token cachedToken
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return cachedToken{}, false
+	}
+	return token, true
+}
+
+// writeCache caches token in path, encrypted for subjectToken.
+func writeCache(path, subjectToken string, token cachedToken) error {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(subjectToken)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := aead.Seal(nonce, nonce, plaintext, cacheFileHeader)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, data)
+}
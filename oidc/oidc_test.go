@@ -0,0 +1,181 @@
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker-credential-helpers/internal/oauth2"
+)
+
+// newTestTokenEndpoint starts a token exchange endpoint accepting the
+// ci-token JWT, and issuing tokens named after their audience, valid for
+// lifetime seconds. It also returns the number of exchanges.
+func newTestTokenEndpoint(t *testing.T, lifetime int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var exchanges atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanges.Add(1)
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" ||
+			r.FormValue("subject_token") != "ci-token" || r.FormValue("subject_token_type") != oauth2.TokenTypeJWT {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "invalid subject token"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": "token-for-%s", "token_type": "Bearer", "expires_in": %d}`, r.FormValue("audience"), lifetime)
+	}))
+	t.Cleanup(server.Close)
+	return server, &exchanges
+}
+
+func writeToken(t *testing.T, token string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHelperGet(t *testing.T) {
+	server, exchanges := newTestTokenEndpoint(t, 300)
+	h := New(Config{TokenURL: server.URL, TokenFile: writeToken(t, "ci-token"), CacheDir: t.TempDir()})
+
+	username, secret, err := h.Get("https://registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != DefaultUsername || secret != "token-for-registry.example.com" {
+		t.Errorf("unexpected credentials %s:%s", username, secret)
+	}
+
+	// The exchanged token is cached until it expires.
+	if _, secret, err = h.Get("https://registry.example.com"); err != nil || secret != "token-for-registry.example.com" {
+		t.Errorf("unexpected secret %s, %v", secret, err)
+	}
+	if n := exchanges.Load(); n != 1 {
+		t.Errorf("expected 1 exchange, got %d", n)
+	}
+	h.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	if _, _, err = h.Get("https://registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := exchanges.Load(); n != 2 {
+		t.Errorf("expected the expired token to be exchanged again, got %d exchanges", n)
+	}
+
+	// Other registries get their own token.
+	if _, secret, err = h.Get("ghcr.io"); err != nil || secret != "token-for-ghcr.io" {
+		t.Errorf("unexpected secret %s, %v", secret, err)
+	}
+}
+
+func TestHelperGetTokenEnv(t *testing.T) {
+	server, _ := newTestTokenEndpoint(t, 300)
+	t.Setenv("CI_JOB_JWT", "ci-token")
+	h := New(Config{TokenURL: server.URL, TokenEnv: "CI_JOB_JWT", Audience: "registry", Username: "ci", CacheDir: t.TempDir()})
+	if username, secret, err := h.Get("registry.example.com"); err != nil || username != "ci" || secret != "token-for-registry" {
+		t.Errorf("unexpected credentials %s:%s, %v", username, secret, err)
+	}
+}
+
+func TestHelperCacheBoundToSubjectToken(t *testing.T) {
+	server, exchanges := newTestTokenEndpoint(t, 300)
+	cacheDir := t.TempDir()
+	h := New(Config{TokenURL: server.URL, TokenFile: writeToken(t, "ci-token"), CacheDir: cacheDir})
+	if _, _, err := h.Get("registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache cannot be read without the CI token it was written with.
+	other := New(Config{TokenURL: server.URL, TokenFile: writeToken(t, "other-token"), CacheDir: cacheDir})
+	if _, _, err := other.Get("registry.example.com"); err == nil || !strings.Contains(err.Error(), "invalid subject token") {
+		t.Errorf("expected exchange of other token to fail, got %v", err)
+	}
+	if n := exchanges.Load(); n != 2 {
+		t.Errorf("expected 2 exchanges, got %d", n)
+	}
+
+	files, err := filepath.Glob(filepath.Join(cacheDir, "*"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 cache file, got %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "token-for-") {
+		t.Error("expected the cached token to be encrypted")
+	}
+
+	if err := h.Delete("registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.Get("registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := exchanges.Load(); n != 3 {
+		t.Errorf("expected the token to be exchanged again after delete, got %d exchanges", n)
+	}
+}
+
+func TestHelperRegistries(t *testing.T) {
+	server, exchanges := newTestTokenEndpoint(t, 300)
+	h := New(Config{TokenURL: server.URL, TokenFile: writeToken(t, "ci-token"), Registries: []string{"*.example.com"}, CacheDir: t.TempDir()})
+	if _, _, err := h.Get("ghcr.io"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if _, _, err := h.Get("registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if n := exchanges.Load(); n != 1 {
+		t.Errorf("expected 1 exchange, got %d", n)
+	}
+}
+
+func TestHelperConfigErrors(t *testing.T) {
+	tests := []Config{
+		{TokenFile: "token"},
+		{TokenURL: "https://sts.example.com/token"},
+		{TokenURL: "https://sts.example.com/token", TokenFile: "token", Registries: []string{"ftp://registry"}},
+	}
+	for _, config := range tests {
+		if _, _, err := New(config).Get("registry.example.com"); err == nil {
+			t.Errorf("%+v: expected error", config)
+		}
+	}
+
+	h := New(Config{TokenURL: "https://sts.example.com/token", TokenFile: filepath.Join(t.TempDir(), "missing")})
+	if _, _, err := h.Get("registry.example.com"); err == nil {
+		t.Error("expected error with missing CI token")
+	}
+	if err := h.Add(&credentials.Credentials{ServerURL: "registry.example.com", Username: "foo", Secret: "bar"}); !credentials.IsErrReadOnly(err) {
+		t.Errorf("expected read-only error, got %v", err)
+	}
+	if l, err := h.List(); err != nil || len(l) != 0 {
+		t.Errorf("expected no credentials, got %v, %v", l, err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	server, _ := newTestTokenEndpoint(t, 300)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv(EnvTokenURL, server.URL)
+	t.Setenv(EnvTokenFile, writeToken(t, "ci-token"))
+	t.Setenv(EnvRegistries, "ghcr.io, registry.example.com")
+	h := FromEnv()
+	if _, secret, err := h.Get("registry.example.com"); err != nil || secret != "token-for-registry.example.com" {
+		t.Errorf("unexpected secret %s, %v", secret, err)
+	}
+	if _, _, err := h.Get("quay.io"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
@@ -61,6 +61,7 @@ func (h Osxkeychain) Add(creds *credentials.Credentials) error {
 		return err
 	}
 
+	credentials.Logger().Debug("adding keychain item", "serverURL", creds.ServerURL, "username", creds.Username)
 	return keychain.AddItem(item)
 }
 
@@ -71,6 +72,7 @@ func (h Osxkeychain) Delete(serverURL string) error {
 	if err := splitServer(serverURL, item); err != nil {
 		return err
 	}
+	credentials.Logger().Debug("deleting keychain item", "serverURL", serverURL)
 	if err := keychain.DeleteItem(item); err != nil {
 		switch err.Error() {
 		case errCredentialsNotFound:
@@ -95,8 +97,10 @@ func (h Osxkeychain) Get(serverURL string) (string, string, error) {
 		return "", "", err
 	}
 
+	credentials.Logger().Debug("querying keychain", "serverURL", serverURL)
 	res, err := keychain.QueryItem(item)
 	if err != nil {
+		credentials.Logger().Debug("keychain query failed", "serverURL", serverURL, "error", err)
 		switch err.Error() {
 		case errCredentialsNotFound:
 			return "", "", credentials.NewErrCredentialsNotFound()
@@ -2,6 +2,17 @@
 // as arguments to pass of the form: "$PASS_FOLDER/base64-url(serverURL)/username".
 // We base64-url encode the serverURL, because under the hood pass uses files and
 // folders, so /s will get translated into additional folders.
+//
+// Several accounts can be stored for a server URL. The account of a username
+// is selected with the server URLs returned by credentials.AccountServerURL,
+// and the default account of a server URL is the first username in
+// lexicographic order.
+//
+// Get looks up the server URL with registryurl.FindMatch, so credentials
+// stored for https://registry.example.com:5000 are found for
+// registry.example.com, and Delete with registryurl.FindSchemeMatch, so
+// that only the credentials stored for https://registry.example.com are
+// removed for registry.example.com.
 package pass
 
 import (
@@ -16,8 +27,11 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/docker/docker-credential-helpers/agent"
 	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker-credential-helpers/registryurl"
 )
 
 // PASS_FOLDER contains the directory where credentials are stored
@@ -65,6 +79,8 @@ func (p Pass) runPass(stdinContent string, args ...string) (string, error) {
 	return p.runPassHelper(stdinContent, args...)
 }
 
+// runPassHelper runs pass with the given arguments. Secrets must only be
+// passed through stdinContent, as the arguments are logged.
 func (p Pass) runPassHelper(stdinContent string, args ...string) (string, error) {
 	var stdout, stderr bytes.Buffer
 	cmd := exec.Command("pass", args...)
@@ -72,7 +88,9 @@ func (p Pass) runPassHelper(stdinContent string, args ...string) (string, error)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
+	start := time.Now()
 	err := cmd.Run()
+	credentials.Logger().Debug("ran pass", "args", args, "duration", time.Since(start), "error", err)
 	if err != nil {
 		return "", fmt.Errorf("%s: %s", err, stderr.String())
 	}
@@ -87,19 +105,41 @@ func (p Pass) Add(creds *credentials.Credentials) error {
 		return errors.New("missing credentials")
 	}
 
-	encoded := encodeServerURL(creds.ServerURL)
+	serverURL, _ := credentials.SplitAccountServerURL(creds.ServerURL)
+	// The default account of the server URL may change, so the agent
+	// evicts all its accounts.
+	if a := agent.NewClientFromEnv(); a != nil {
+		_ = a.Delete(serverURL)
+	}
+
+	encoded := encodeServerURL(serverURL)
 	_, err := p.runPass(creds.Secret, "insert", "-f", "-m", path.Join(PASS_FOLDER, encoded, creds.Username))
 	return err
 }
 
-// Delete removes credentials from the store.
+// Delete removes credentials from the store: all the accounts of a server
+// URL, or a single account if serverURL designates one.
 func (p Pass) Delete(serverURL string) error {
 	if serverURL == "" {
 		return errors.New("missing server url")
 	}
 
-	encoded := encodeServerURL(serverURL)
-	_, err := p.runPass("", "rm", "-rf", path.Join(PASS_FOLDER, encoded))
+	server, username := credentials.SplitAccountServerURL(serverURL)
+	// Removing an account may change the default account of the server
+	// URL, so the agent evicts all its accounts.
+	if a := agent.NewClientFromEnv(); a != nil {
+		_ = a.Delete(server)
+	}
+
+	encoded, err := lookupServer(server, registryurl.FindSchemeMatch)
+	if err != nil {
+		return err
+	}
+	if username != "" {
+		_, err := p.runPass("", "rm", "-f", path.Join(PASS_FOLDER, encoded, username))
+		return err
+	}
+	_, err = p.runPass("", "rm", "-rf", path.Join(PASS_FOLDER, encoded))
 	return err
 }
 
@@ -136,28 +176,123 @@ func listPassDir(args ...string) ([]os.FileInfo, error) {
 }
 
 // Get returns the username and secret to use for a given registry server URL.
+// If a credential agent is running, it is consulted before running `pass show`,
+// and populated afterwards.
 func (p Pass) Get(serverURL string) (string, string, error) {
 	if serverURL == "" {
 		return "", "", errors.New("missing server url")
 	}
 
-	encoded := encodeServerURL(serverURL)
-	usernames, err := listPassDir(encoded)
+	a := agent.NewClientFromEnv()
+	if a != nil {
+		username, secret, err := a.Get(serverURL)
+		if err == nil {
+			credentials.Logger().Debug("found credentials in agent", "serverURL", serverURL)
+			return username, secret, nil
+		}
+		credentials.Logger().Debug("credentials not available from agent", "serverURL", serverURL, "error", err)
+	}
+
+	server, username := credentials.SplitAccountServerURL(serverURL)
+	encoded, err := lookupServer(server, registryurl.FindMatch)
+	if err != nil {
+		return "", "", err
+	}
+	usernames, err := listUsernames(encoded)
 	if err != nil {
 		return "", "", err
 	}
 
-	if len(usernames) < 1 {
+	var actual string
+	for _, u := range usernames {
+		if username == "" || u == username {
+			actual = u
+			break
+		}
+	}
+	if actual == "" {
+		credentials.Logger().Debug("no credentials in password store", "serverURL", serverURL, "path", path.Join(PASS_FOLDER, encoded))
 		return "", "", credentials.NewErrCredentialsNotFound()
 	}
 
-	actual := strings.TrimSuffix(usernames[0].Name(), ".gpg")
 	secret, err := p.runPass("", "show", path.Join(PASS_FOLDER, encoded, actual))
-	return actual, secret, err
+	if err != nil {
+		return "", "", err
+	}
+
+	if a != nil {
+		_ = a.Add(&credentials.Credentials{
+			ServerURL: serverURL,
+			Username:  actual,
+			Secret:    secret,
+		}, agent.TTLFromEnv())
+	}
+	return actual, secret, nil
 }
 
-// List returns the stored URLs and corresponding usernames for a given credentials label
+// lookupServer returns the encoded server URL of the credentials stored
+// for server, as found by find, or the encoded server if there are none.
+func lookupServer(server string, find func(serverURL string, candidates []string) (string, bool)) (string, error) {
+	encoded := encodeServerURL(server)
+	if usernames, err := listUsernames(encoded); err != nil || len(usernames) > 0 {
+		return encoded, err
+	}
+
+	infos, err := listPassDir()
+	if err != nil {
+		return "", err
+	}
+	candidates := make(map[string]string, len(infos))
+	servers := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		serverURL, err := decodeServerURL(info.Name())
+		if err != nil {
+			continue
+		}
+		candidates[serverURL] = info.Name()
+		servers = append(servers, serverURL)
+	}
+	if match, ok := find(server, servers); ok {
+		credentials.Logger().Debug("found matching credentials in password store", "serverURL", server, "match", match)
+		return candidates[match], nil
+	}
+	return encoded, nil
+}
+
+// listUsernames returns the usernames of the accounts stored for the
+// encoded server URL, in lexicographic order.
+func listUsernames(encoded string) ([]string, error) {
+	infos, err := listPassDir(encoded)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".gpg") {
+			continue
+		}
+		usernames = append(usernames, strings.TrimSuffix(info.Name(), ".gpg"))
+	}
+	return usernames, nil
+}
+
+// List returns the stored URLs and the username of their default account.
 func (p Pass) List() (map[string]string, error) {
+	return p.list(false)
+}
+
+// ListAll is like List, but also returns the accounts other than the default
+// account of each server URL, under their account server URL.
+func (p Pass) ListAll() (map[string]string, error) {
+	return p.list(true)
+}
+
+// list returns the default account of each server URL, and the other
+// accounts too if all is set.
+func (p Pass) list(all bool) (map[string]string, error) {
 	servers, err := listPassDir()
 	if err != nil {
 		return nil, err
@@ -175,16 +310,19 @@ func (p Pass) List() (map[string]string, error) {
 			return nil, err
 		}
 
-		usernames, err := listPassDir(server.Name())
+		usernames, err := listUsernames(server.Name())
 		if err != nil {
 			return nil, err
 		}
 
-		if len(usernames) < 1 {
-			continue
+		for i, username := range usernames {
+			switch {
+			case i == 0:
+				resp[serverURL] = username
+			case all:
+				resp[credentials.AccountServerURL(serverURL, username)] = username
+			}
 		}
-
-		resp[serverURL] = strings.TrimSuffix(usernames[0].Name(), ".gpg")
 	}
 
 	return resp, nil
@@ -193,3 +193,102 @@ func TestMissingCred(t *testing.T) {
 		t.Errorf("expected credentials not found, actual: %v", err)
 	}
 }
+
+func TestPassHelperAccounts(t *testing.T) {
+	helper := Pass{}
+	if err := helper.checkInitialized(); err != nil {
+		t.Error(err)
+	}
+
+	const serverURL = "https://accounts.example.com"
+	creds := []*credentials.Credentials{
+		{ServerURL: serverURL, Username: "robot", Secret: "robot-secret"},
+		{ServerURL: serverURL, Username: "jane", Secret: "jane-secret"},
+	}
+	t.Cleanup(func() {
+		_ = helper.Delete(serverURL)
+	})
+	for _, cred := range creds {
+		if err := helper.Add(cred); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The default account is the first username.
+	if u, s, err := helper.Get(serverURL); err != nil || u != "jane" || s != "jane-secret" {
+		t.Errorf("unexpected default account %s:%s, %v", u, s, err)
+	}
+	if u, s, err := helper.Get(credentials.AccountServerURL(serverURL, "robot")); err != nil || u != "robot" || s != "robot-secret" {
+		t.Errorf("unexpected robot account %s:%s, %v", u, s, err)
+	}
+	if _, _, err := helper.Get(credentials.AccountServerURL(serverURL, "john")); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, actual: %v", err)
+	}
+
+	// List returns one entry per server URL, as docker expects, and ListAll
+	// every account.
+	credsList, err := helper.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credsList[serverURL] != "jane" {
+		t.Errorf("expected the default account to be listed, got %v", credsList)
+	}
+	if _, ok := credsList[credentials.AccountServerURL(serverURL, "robot")]; ok {
+		t.Errorf("expected other accounts not to be listed, got %v", credsList)
+	}
+	credsList, err = helper.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credsList[serverURL] != "jane" || credsList[credentials.AccountServerURL(serverURL, "robot")] != "robot" {
+		t.Errorf("expected both accounts to be listed, got %v", credsList)
+	}
+
+	if err := helper.Delete(credentials.AccountServerURL(serverURL, "jane")); err != nil {
+		t.Error(err)
+	}
+	if u, _, err := helper.Get(serverURL); err != nil || u != "robot" {
+		t.Errorf("expected robot to be the default account, got %s, %v", u, err)
+	}
+}
+
+func TestPassHelperApproximateMatch(t *testing.T) {
+	helper := Pass{}
+	if err := helper.checkInitialized(); err != nil {
+		t.Error(err)
+	}
+
+	const serverURL = "https://approximate.example.com:5000"
+	t.Cleanup(func() {
+		_ = helper.Delete(serverURL)
+	})
+	if err := helper.Add(&credentials.Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, readURL := range []string{"approximate.example.com", "https://approximate.example.com", "approximate.example.com:5000"} {
+		if u, s, err := helper.Get(readURL); err != nil || u != "foo" || s != "bar" {
+			t.Errorf("%s: unexpected credentials %s:%s, %v", readURL, u, s, err)
+		}
+	}
+	if _, _, err := helper.Get("http://approximate.example.com:5000"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials not found, actual: %v", err)
+	}
+
+	// Deleting only matches server URLs differing by the scheme.
+	for _, deleteURL := range []string{"approximate.example.com", "https://approximate.example.com"} {
+		if err := helper.Delete(deleteURL); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := helper.Get(serverURL); err != nil {
+			t.Errorf("%s: expected credentials to be kept, actual: %v", deleteURL, err)
+		}
+	}
+	if err := helper.Delete("approximate.example.com:5000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := helper.Get(serverURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials to be deleted, actual: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package registryurl
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// FindMatch returns the candidate, among the server URLs credentials are
+// stored for, that serverURL designates: the candidate equal to serverURL
+// once both are parsed, or else a candidate serverURL approximately
+// matches. serverURL approximately matches a candidate that only differs
+// by a scheme, port or path that serverURL lacks, so "registry.example.com"
+// matches "https://registry.example.com:5000/v1/", but "http://ghcr.io"
+// does not match "https://ghcr.io". When several candidates match, the
+// first in lexicographic order is returned, whatever the order of
+// candidates.
+//
+// If serverURL cannot be parsed, only a candidate with the same string
+// matches it. Candidates that cannot be parsed are ignored otherwise.
+func FindMatch(serverURL string, candidates []string) (string, bool) {
+	return find(serverURL, candidates, approximateMatch)
+}
+
+// FindSchemeMatch is like FindMatch, but serverURL only matches a
+// candidate that differs by a scheme serverURL lacks, so
+// "registry.example.com" matches "https://registry.example.com" but not
+// "https://registry.example.com:5000", which is another registry. It is
+// used to find the credentials to erase.
+func FindSchemeMatch(serverURL string, candidates []string) (string, bool) {
+	return find(serverURL, candidates, schemeMatch)
+}
+
+// find returns the candidate equal to serverURL, or else the first candidate
+// in lexicographic order serverURL matches with matches.
+func find(serverURL string, candidates []string, matches func(url.URL, url.URL) bool) (string, bool) {
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+
+	u, err := Parse(serverURL)
+	if err != nil {
+		for _, candidate := range sorted {
+			if candidate == serverURL {
+				return candidate, true
+			}
+		}
+		return "", false
+	}
+	if candidate, ok := findMatch(u, sorted, exactMatch); ok {
+		return candidate, true
+	}
+	return findMatch(u, sorted, matches)
+}
+
+func findMatch(serverURL *url.URL, candidates []string, matches func(url.URL, url.URL) bool) (string, bool) {
+	for _, candidate := range candidates {
+		c, err := Parse(candidate)
+		if err != nil {
+			continue
+		}
+		if matches(*serverURL, *c) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func exactMatch(serverURL, candidate url.URL) bool {
+	return serverURL.String() == candidate.String()
+}
+
+func schemeMatch(serverURL, candidate url.URL) bool {
+	if serverURL.Scheme == "" {
+		serverURL.Scheme = candidate.Scheme
+	}
+	return serverURL.String() == candidate.String()
+}
+
+func approximateMatch(serverURL, candidate url.URL) bool {
+	// if scheme is missing assume it is the same as candidate
+	if serverURL.Scheme == "" {
+		serverURL.Scheme = candidate.Scheme
+	}
+	// if port is missing assume it is the same as candidate
+	if serverURL.Port() == "" && candidate.Port() != "" {
+		serverURL.Host = serverURL.Host + ":" + candidate.Port()
+	}
+	// if path is missing assume it is the same as candidate
+	if serverURL.Path == "" {
+		serverURL.Path = candidate.Path
+	}
+	return serverURL.String() == candidate.String()
+}
+
+// LongestPrefixMatch returns the candidate server URL credentials stored
+// for would serve serverURL with: the candidate on the same host and port
+// whose path is the longest prefix of the path of serverURL, so credentials
+// stored for "ghcr.io/org-a" serve "ghcr.io/org-a/app" rather than those
+// stored for "ghcr.io". Paths are compared by segment, so "ghcr.io/org"
+// does not serve "ghcr.io/organization". Schemes are only compared if both
+// URLs have one. Among candidates with the same path, one with the scheme
+// of serverURL is preferred, then the first in lexicographic order.
+//
+// It returns false if no candidate serves serverURL, or if serverURL cannot
+// be parsed. Candidates that cannot be parsed are ignored.
+func LongestPrefixMatch(serverURL string, candidates []string) (string, bool) {
+	u, err := Parse(serverURL)
+	if err != nil {
+		return "", false
+	}
+	var (
+		best     string
+		bestURL  *url.URL
+		bestPath string
+	)
+	for _, candidate := range candidates {
+		c, err := Parse(candidate)
+		if err != nil || !sameHost(u, c) {
+			continue
+		}
+		p, ok := pathPrefix(c.Path, u.Path)
+		if !ok {
+			continue
+		}
+		switch {
+		case bestURL == nil, len(p) > len(bestPath):
+		case len(p) < len(bestPath):
+			continue
+		case (c.Scheme == u.Scheme) != (bestURL.Scheme == u.Scheme):
+			if c.Scheme != u.Scheme {
+				continue
+			}
+		case candidate > best:
+			continue
+		}
+		best, bestURL, bestPath = candidate, c, p
+	}
+	return best, bestURL != nil
+}
+
+// sameHost reports whether u and c have the same host and port, and the
+// same scheme if both have one.
+func sameHost(u, c *url.URL) bool {
+	if u.Scheme != "" && c.Scheme != "" && u.Scheme != c.Scheme {
+		return false
+	}
+	return strings.EqualFold(u.Hostname(), c.Hostname()) && u.Port() == c.Port()
+}
+
+// pathPrefix returns prefix without trailing slashes, and whether it is
+// the same path as urlPath or a parent of it.
+func pathPrefix(prefix, urlPath string) (string, bool) {
+	prefix, urlPath = strings.TrimRight(prefix, "/"), strings.TrimRight(urlPath, "/")
+	return prefix, prefix == "" || urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
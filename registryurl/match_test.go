@@ -0,0 +1,96 @@
+package registryurl
+
+import "testing"
+
+func TestFindMatch(t *testing.T) {
+	tests := []struct {
+		doc        string
+		serverURL  string
+		candidates []string
+		expected   string
+	}{
+		{doc: "exact match", serverURL: "https://foobar.docker.io", candidates: []string{"foobar.docker.io", "https://foobar.docker.io"}, expected: "https://foobar.docker.io"},
+		{doc: "exact match without scheme", serverURL: "foobar.docker.io", candidates: []string{"https://foobar.docker.io", "foobar.docker.io"}, expected: "foobar.docker.io"},
+		{doc: "stored with port, retrieved without", serverURL: "https://foobar.docker.io", candidates: []string{"https://foobar.docker.io:2376"}, expected: "https://foobar.docker.io:2376"},
+		{doc: "stored as https, retrieved without scheme", serverURL: "foobar.docker.io", candidates: []string{"https://foobar.docker.io"}, expected: "https://foobar.docker.io"},
+		{doc: "stored with path, retrieved without", serverURL: "https://foobar.docker.io", candidates: []string{"https://foobar.docker.io/one/two"}, expected: "https://foobar.docker.io/one/two"},
+		{doc: "first approximate match in lexicographic order", serverURL: "foobar.docker.io", candidates: []string{"https://foobar.docker.io:5000", "http://foobar.docker.io", "https://foobar.docker.io"}, expected: "http://foobar.docker.io"},
+		{doc: "unparsable server URL", serverURL: "ftp://foobar.docker.io", candidates: []string{"https://foobar.docker.io", "ftp://foobar.docker.io"}, expected: "ftp://foobar.docker.io"},
+		{doc: "stored as https, retrieved using http", serverURL: "http://foobar.docker.io:2376", candidates: []string{"https://foobar.docker.io:2376"}},
+		{doc: "stored as http, retrieved without scheme", serverURL: "foobar.docker.io:5678", candidates: []string{"http://foobar.docker.io"}},
+		{doc: "non-matching ports", serverURL: "https://foobar.docker.io:5678", candidates: []string{"https://foobar.docker.io:1234"}},
+		{doc: "non-matching paths", serverURL: "https://foobar.docker.io:1234/five/six", candidates: []string{"https://foobar.docker.io:1234/one/two"}},
+		{doc: "non-matching hosts", serverURL: "foobar.docker.io", candidates: []string{"https://docker.io", "ftp://foobar.docker.io"}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.doc, func(t *testing.T) {
+			actual, ok := FindMatch(tc.serverURL, tc.candidates)
+			if actual != tc.expected || ok != (tc.expected != "") {
+				t.Errorf("expected %q, got %q, %v", tc.expected, actual, ok)
+			}
+		})
+	}
+}
+
+func TestFindSchemeMatch(t *testing.T) {
+	tests := []struct {
+		doc        string
+		serverURL  string
+		candidates []string
+		expected   string
+	}{
+		{doc: "exact match", serverURL: "https://foobar.docker.io", candidates: []string{"foobar.docker.io", "https://foobar.docker.io"}, expected: "https://foobar.docker.io"},
+		{doc: "stored as https, erased without scheme", serverURL: "foobar.docker.io", candidates: []string{"https://foobar.docker.io"}, expected: "https://foobar.docker.io"},
+		{doc: "stored with port, erased without", serverURL: "foobar.docker.io", candidates: []string{"https://foobar.docker.io:5000"}},
+		{doc: "stored with path, erased without", serverURL: "https://foobar.docker.io", candidates: []string{"https://foobar.docker.io/v1/"}},
+		{doc: "stored as https, erased using http", serverURL: "http://foobar.docker.io", candidates: []string{"https://foobar.docker.io"}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.doc, func(t *testing.T) {
+			actual, ok := FindSchemeMatch(tc.serverURL, tc.candidates)
+			if actual != tc.expected || ok != (tc.expected != "") {
+				t.Errorf("expected %q, got %q, %v", tc.expected, actual, ok)
+			}
+		})
+	}
+}
+
+func TestLongestPrefixMatch(t *testing.T) {
+	candidates := []string{
+		"ghcr.io",
+		"ghcr.io/org-a",
+		"https://ghcr.io/org-b",
+		"http://ghcr.io/org-b",
+		"ghcr.io/org-a/team/",
+		"registry.example.com:5000/org-a",
+		"ftp://ghcr.io/org-a/app",
+	}
+	tests := []struct {
+		serverURL string
+		expected  string
+	}{
+		{serverURL: "ghcr.io/org-a/app", expected: "ghcr.io/org-a"},
+		{serverURL: "https://ghcr.io/org-a", expected: "ghcr.io/org-a"},
+		{serverURL: "GHCR.io/org-a/team/app", expected: "ghcr.io/org-a/team/"},
+		{serverURL: "https://ghcr.io/org-b/app", expected: "https://ghcr.io/org-b"},
+		{serverURL: "http://ghcr.io/org-b/app", expected: "http://ghcr.io/org-b"},
+		// Without a scheme, the first candidate in lexicographic order is
+		// preferred.
+		{serverURL: "ghcr.io/org-b", expected: "http://ghcr.io/org-b"},
+		{serverURL: "ghcr.io/org-c/app", expected: "ghcr.io"},
+		{serverURL: "ghcr.io/org-ab", expected: "ghcr.io"},
+		{serverURL: "https://ghcr.io", expected: "ghcr.io"},
+		{serverURL: "registry.example.com:5000/org-a/app", expected: "registry.example.com:5000/org-a"},
+		{serverURL: "registry.example.com/org-a/app", expected: ""},
+		{serverURL: "quay.io/org-a", expected: ""},
+		{serverURL: "ftp://ghcr.io/org-a", expected: ""},
+	}
+	for _, tc := range tests {
+		actual, ok := LongestPrefixMatch(tc.serverURL, candidates)
+		if actual != tc.expected || ok != (tc.expected != "") {
+			t.Errorf("%s: expected %q, got %q, %v", tc.serverURL, tc.expected, actual, ok)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package registryurl
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Pattern matches registry server URLs. A pattern consists of a host,
+// optionally preceded by a scheme and followed by a port and a path, for
+// example:
+//
+//	ghcr.io
+//	*.example.com
+//	https://registry.example.com:5000/team
+//
+// The host may contain shell-style wildcards as supported by [path.Match].
+// Scheme and port are only compared if the pattern contains them. A path
+// matches the same path and any path below it, so "ghcr.io/org" matches
+// "ghcr.io/org/app", but not "ghcr.io/organization".
+type Pattern struct {
+	raw    string
+	scheme string
+	host   string
+	port   string
+	path   string
+}
+
+// ParsePattern parses and validates a registry pattern.
+func ParsePattern(pattern string) (*Pattern, error) {
+	p := &Pattern{raw: pattern}
+
+	rest := pattern
+	if i := strings.Index(rest, "://"); i >= 0 {
+		p.scheme = strings.ToLower(rest[:i])
+		rest = rest[i+3:]
+		if p.scheme != "https" && p.scheme != "http" {
+			return nil, errors.New("unsupported scheme: " + p.scheme)
+		}
+	} else {
+		rest = strings.TrimPrefix(rest, "//")
+	}
+
+	host := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host, p.path = rest[:i], strings.TrimRight(rest[i:], "/")
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host, p.port = host[:i], host[i+1:]
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	p.host = strings.ToLower(host)
+
+	if p.host == "" {
+		return nil, errors.New("no hostname in pattern")
+	}
+	if _, err := path.Match(p.host, ""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// String returns the pattern as it was passed to [ParsePattern].
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether the given URL matches the pattern.
+func (p *Pattern) Match(u *url.URL) bool {
+	if p.scheme != "" && p.scheme != u.Scheme {
+		return false
+	}
+	if ok, _ := path.Match(p.host, strings.ToLower(u.Hostname())); !ok {
+		return false
+	}
+	if p.port != "" && p.port != u.Port() {
+		return false
+	}
+	if p.path != "" {
+		urlPath := strings.TrimRight(u.Path, "/")
+		if urlPath != p.path && !strings.HasPrefix(urlPath, p.path+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchString reports whether the given serverURL matches the pattern.
+// It returns false if serverURL cannot be parsed.
+func (p *Pattern) MatchString(serverURL string) bool {
+	u, err := Parse(serverURL)
+	if err != nil {
+		return false
+	}
+	return p.Match(u)
+}
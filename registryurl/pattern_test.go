@@ -0,0 +1,92 @@
+package registryurl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     error
+	}{
+		{pattern: "ghcr.io"},
+		{pattern: "*.example.com"},
+		{pattern: "https://registry.example.com:5000/team/"},
+		{pattern: "//registry.example.com"},
+		{pattern: "[::1]:5000"},
+		{
+			pattern: "ftp://registry.example.com",
+			err:     errors.New("unsupported scheme: ftp"),
+		},
+		{
+			pattern: "https:///some/path",
+			err:     errors.New("no hostname in pattern"),
+		},
+		{
+			pattern: "[.example.com",
+			err:     errors.New("syntax error in pattern"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.pattern, func(t *testing.T) {
+			p, err := ParsePattern(tc.pattern)
+			if tc.err == nil && err != nil {
+				t.Fatalf("Error: failed to parse pattern %q: %s", tc.pattern, err)
+			}
+			if tc.err != nil && err == nil {
+				t.Fatalf("Error: expected error %q, got none when parsing pattern %q", tc.err, tc.pattern)
+			}
+			if tc.err != nil && err.Error() != tc.err.Error() {
+				t.Fatalf("Error: expected error %q, got %q when parsing pattern %q", tc.err, err, tc.pattern)
+			}
+			if p != nil && p.String() != tc.pattern {
+				t.Errorf("Error: expected pattern %q, got %q", tc.pattern, p.String())
+			}
+		})
+	}
+}
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{pattern: "ghcr.io", url: "ghcr.io", match: true},
+		{pattern: "ghcr.io", url: "https://ghcr.io/v2/", match: true},
+		{pattern: "ghcr.io", url: "https://GHCR.io", match: true},
+		{pattern: "ghcr.io", url: "ghcr.io:443", match: true},
+		{pattern: "ghcr.io", url: "docker.io", match: false},
+		{pattern: "*.example.com", url: "registry.example.com", match: true},
+		{pattern: "*.example.com", url: "a.b.example.com", match: true},
+		{pattern: "*.example.com", url: "example.com", match: false},
+		{pattern: "*", url: "https://anything.example.org/path", match: true},
+		{pattern: "http://*", url: "http://registry.example.com", match: true},
+		{pattern: "http://*", url: "https://registry.example.com", match: false},
+		{pattern: "http://*", url: "registry.example.com", match: false},
+		{pattern: "registry.example.com:5000", url: "registry.example.com:5000", match: true},
+		{pattern: "registry.example.com:5000", url: "registry.example.com", match: false},
+		{pattern: "ghcr.io/org", url: "ghcr.io/org", match: true},
+		{pattern: "ghcr.io/org/", url: "ghcr.io/org/app", match: true},
+		{pattern: "ghcr.io/org", url: "ghcr.io/organization", match: false},
+		{pattern: "ghcr.io/org", url: "ghcr.io", match: false},
+		{pattern: "[::1]:5000", url: "http://[::1]:5000", match: true},
+		{pattern: "ghcr.io", url: "ftp://ghcr.io", match: false},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.pattern+"="+tc.url, func(t *testing.T) {
+			p, err := ParsePattern(tc.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := p.MatchString(tc.url); actual != tc.match {
+				t.Errorf("Error: expected %q to match %q: %t, got %t", tc.pattern, tc.url, tc.match, actual)
+			}
+		})
+	}
+}
@@ -3,10 +3,14 @@
 package main
 
 import (
+	"github.com/docker/docker-credential-helpers/agent"
 	"github.com/docker/docker-credential-helpers/credentials"
 	"github.com/docker/docker-credential-helpers/secretservice"
 )
 
 func main() {
+	if a := agent.NewClientFromEnv(); a != nil {
+		credentials.SetApprovalStore(a)
+	}
 	credentials.Serve(secretservice.Secretservice{})
 }
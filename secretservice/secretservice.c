@@ -30,13 +30,21 @@ GError *add(char *label, char *server, char *username, char *secret, char *displ
 	return err;
 }
 
-GError *delete(char *server) {
+GError *delete(char *server, char *username) {
 	GError *err = NULL;
 
-	secret_password_clear_sync(DOCKER_SCHEMA, NULL, &err,
-			"server", server,
-			"docker_cli", "1",
-			NULL);
+	if (username != NULL && *username != '\0') {
+		secret_password_clear_sync(DOCKER_SCHEMA, NULL, &err,
+				"server", server,
+				"username", username,
+				"docker_cli", "1",
+				NULL);
+	} else {
+		secret_password_clear_sync(DOCKER_SCHEMA, NULL, &err,
+				"server", server,
+				"docker_cli", "1",
+				NULL);
+	}
 	if (err != NULL)
 		return err;
 	return NULL;
@@ -57,7 +65,9 @@ char *get_attribute(const char *attribute, SecretItem *item) {
 	return NULL;
 }
 
-GError *get(char *server, char **username, char **secret) {
+// get returns the account of username for server or, if username is empty,
+// the account with the first username in lexicographic order.
+GError *get(char *server, char *username, char **found_username, char **secret) {
 	GError *err = NULL;
 	GHashTable *attributes;
 	SecretService *service;
@@ -66,10 +76,13 @@ GError *get(char *server, char **username, char **secret) {
 	SecretValue *secretValue;
 	gsize length;
 	gchar *value;
+	char *user;
 
 	attributes = g_hash_table_new_full(g_str_hash, g_str_equal, g_free, g_free);
 	g_hash_table_insert(attributes, g_strdup("server"), g_strdup(server));
 	g_hash_table_insert(attributes, g_strdup("docker_cli"), g_strdup("1"));
+	if (username != NULL && *username != '\0')
+		g_hash_table_insert(attributes, g_strdup("username"), g_strdup(username));
 
 	service = secret_service_get_sync(SECRET_SERVICE_NONE, NULL, &err);
 	if (err == NULL) {
@@ -82,15 +95,19 @@ GError *get(char *server, char **username, char **secret) {
 					continue;
 				}
 				g_free(value);
+				user = get_attribute("username", l->data);
+				if (*secret != NULL && (user == NULL || (*found_username != NULL && strcmp(user, *found_username) >= 0))) {
+					continue;
+				}
 				secretValue = secret_item_get_secret(l->data);
 				if (secretValue == NULL) {
 					continue;
 				}
-				if (secret != NULL) {
-					*secret = strdup(secret_value_get(secretValue, &length));
-					secret_value_unref(secretValue);
-				}
-				*username = get_attribute("username", l->data);
+				free(*secret);
+				*secret = strdup(secret_value_get(secretValue, &length));
+				secret_value_unref(secretValue);
+				free(*found_username);
+				*found_username = user != NULL ? strdup(user) : NULL;
 			}
 			g_list_free_full(items, g_object_unref);
 		}
@@ -132,8 +149,11 @@ GError *list(char *ref_label, char *** paths, char *** accts, unsigned int *list
 	GList *current;
 	int listNumber = 0;
 	for(current = items; current!=NULL; current = current->next) {
-		char *pathTmp = secret_item_get_label(current->data);
-		// you cannot have a key without a label in the gnome keyring
+		char *pathTmp = get_attribute("server", current->data);
+		if (pathTmp == NULL) {
+			// you cannot have a key without a label in the gnome keyring
+			pathTmp = secret_item_get_label(current->data);
+		}
 		char *acctTmp = get_attribute("username",current->data);
 		if (acctTmp==NULL) {
 			acctTmp = "account not defined";
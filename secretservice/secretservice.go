@@ -12,9 +12,11 @@ import "C"
 
 import (
 	"errors"
+	"sort"
 	"unsafe"
 
 	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/docker/docker-credential-helpers/registryurl"
 )
 
 // Secretservice handles secrets using Linux secret-service as a store.
@@ -27,15 +29,17 @@ func (h Secretservice) Add(creds *credentials.Credentials) error {
 	}
 	credsLabel := C.CString(credentials.CredsLabel)
 	defer C.free(unsafe.Pointer(credsLabel))
-	server := C.CString(creds.ServerURL)
+	serverURL, _ := credentials.SplitAccountServerURL(creds.ServerURL)
+	server := C.CString(serverURL)
 	defer C.free(unsafe.Pointer(server))
 	username := C.CString(creds.Username)
 	defer C.free(unsafe.Pointer(username))
 	secret := C.CString(creds.Secret)
 	defer C.free(unsafe.Pointer(secret))
-	displayLabel := C.CString("Registry credentials for " + creds.ServerURL)
+	displayLabel := C.CString("Registry credentials for " + serverURL)
 	defer C.free(unsafe.Pointer(displayLabel))
 
+	credentials.Logger().Debug("storing item in secret service", "label", credentials.CredsLabel, "server", serverURL, "username", creds.Username)
 	if err := C.add(credsLabel, server, username, secret, displayLabel); err != nil {
 		defer C.g_error_free(err)
 		errMsg := (*C.char)(unsafe.Pointer(err.message))
@@ -44,15 +48,24 @@ func (h Secretservice) Add(creds *credentials.Credentials) error {
 	return nil
 }
 
-// Delete removes credentials from the store.
+// Delete removes credentials from the store: all the accounts of a server
+// URL, or a single account if serverURL designates one. If there are no
+// credentials for the server URL, those of the stored server URL differing
+// by the scheme serverURL lacks, as found by registryurl.FindSchemeMatch,
+// are removed.
 func (h Secretservice) Delete(serverURL string) error {
 	if serverURL == "" {
 		return errors.New("missing server url")
 	}
+	serverURL, user := credentials.SplitAccountServerURL(serverURL)
+	serverURL = h.lookup(serverURL, registryurl.FindSchemeMatch)
 	server := C.CString(serverURL)
 	defer C.free(unsafe.Pointer(server))
+	username := C.CString(user)
+	defer C.free(unsafe.Pointer(username))
 
-	if err := C.delete(server); err != nil {
+	credentials.Logger().Debug("deleting item from secret service", "server", serverURL, "username", user)
+	if err := C.delete(server, username); err != nil {
 		defer C.g_error_free(err)
 		errMsg := (*C.char)(unsafe.Pointer(err.message))
 		return errors.New(C.GoString(errMsg))
@@ -61,18 +74,39 @@ func (h Secretservice) Delete(serverURL string) error {
 }
 
 // Get returns the username and secret to use for a given registry server URL.
+// The default account of a server URL is the first username in lexicographic
+// order. If there are no credentials for the server URL, those of the stored
+// server URL it matches, as found by registryurl.FindMatch, are returned.
 func (h Secretservice) Get(serverURL string) (string, string, error) {
 	if serverURL == "" {
 		return "", "", errors.New("missing server url")
 	}
+	username, secret, err := h.get(serverURL)
+	if !credentials.IsErrCredentialsNotFound(err) {
+		return username, secret, err
+	}
+	server, account := credentials.SplitAccountServerURL(serverURL)
+	match := h.lookup(server, registryurl.FindMatch)
+	if match == server {
+		return "", "", err
+	}
+	return h.get(credentials.AccountServerURL(match, account))
+}
+
+// get returns the username and secret stored for serverURL.
+func (h Secretservice) get(serverURL string) (string, string, error) {
 	var username *C.char
-	defer C.free(unsafe.Pointer(username))
+	defer func() { C.free(unsafe.Pointer(username)) }()
 	var secret *C.char
-	defer C.free(unsafe.Pointer(secret))
+	defer func() { C.free(unsafe.Pointer(secret)) }()
+	serverURL, account := credentials.SplitAccountServerURL(serverURL)
 	server := C.CString(serverURL)
 	defer C.free(unsafe.Pointer(server))
+	wanted := C.CString(account)
+	defer C.free(unsafe.Pointer(wanted))
 
-	err := C.get(server, &username, &secret)
+	credentials.Logger().Debug("looking up item in secret service", "server", serverURL, "username", account)
+	err := C.get(server, wanted, &username, &secret)
 	if err != nil {
 		defer C.g_error_free(err)
 		errMsg := (*C.char)(unsafe.Pointer(err.message))
@@ -81,13 +115,46 @@ func (h Secretservice) Get(serverURL string) (string, string, error) {
 	user := C.GoString(username)
 	pass := C.GoString(secret)
 	if pass == "" {
+		credentials.Logger().Debug("no item in secret service", "server", serverURL)
 		return "", "", credentials.NewErrCredentialsNotFound()
 	}
 	return user, pass, nil
 }
 
-// List returns the stored URLs and corresponding usernames for a given credentials label
+// lookup returns the stored server URL matching serverURL, as found by find,
+// or serverURL if there is none.
+func (h Secretservice) lookup(serverURL string, find func(serverURL string, candidates []string) (string, bool)) string {
+	accts, err := h.List()
+	if err != nil {
+		credentials.Logger().Debug("cannot list items in secret service", "error", err)
+		return serverURL
+	}
+	servers := make([]string, 0, len(accts))
+	for server := range accts {
+		servers = append(servers, server)
+	}
+	if match, ok := find(serverURL, servers); ok {
+		credentials.Logger().Debug("found matching item in secret service", "serverURL", serverURL, "match", match)
+		return match
+	}
+	return serverURL
+}
+
+// List returns the stored URLs and the username of their default account for
+// a given credentials label.
 func (h Secretservice) List() (map[string]string, error) {
+	return h.list(false)
+}
+
+// ListAll is like List, but also returns the accounts other than the default
+// account of each server URL, under their account server URL.
+func (h Secretservice) ListAll() (map[string]string, error) {
+	return h.list(true)
+}
+
+// list returns the default account of each server URL, and the other
+// accounts too if all is set.
+func (h Secretservice) list(all bool) (map[string]string, error) {
 	credsLabelC := C.CString(credentials.CredsLabel)
 	defer C.free(unsafe.Pointer(credsLabelC))
 
@@ -96,6 +163,7 @@ func (h Secretservice) List() (map[string]string, error) {
 	var acctsC **C.char
 	defer C.free(unsafe.Pointer(acctsC))
 	var listLenC C.uint
+	credentials.Logger().Debug("listing items in secret service", "label", credentials.CredsLabel)
 	err := C.list(credsLabelC, &pathsC, &acctsC, &listLenC)
 	defer C.freeListData(&pathsC, listLenC)
 	defer C.freeListData(&acctsC, listLenC)
@@ -116,8 +184,21 @@ func (h Secretservice) List() (map[string]string, error) {
 	// and (2^29)*4 == math.MaxInt32 + 1. -- See issue golang/go#13656
 	pathTmp := (*[(1 << 29) - 1]*C.char)(unsafe.Pointer(pathsC))[:listLen:listLen]
 	acctTmp := (*[(1 << 29) - 1]*C.char)(unsafe.Pointer(acctsC))[:listLen:listLen]
+	accounts := make(map[string][]string)
 	for i := 0; i < listLen; i++ {
-		resp[C.GoString(pathTmp[i])] = C.GoString(acctTmp[i])
+		serverURL := C.GoString(pathTmp[i])
+		accounts[serverURL] = append(accounts[serverURL], C.GoString(acctTmp[i]))
+	}
+	for serverURL, usernames := range accounts {
+		sort.Strings(usernames)
+		for i, username := range usernames {
+			switch {
+			case i == 0:
+				resp[serverURL] = username
+			case all:
+				resp[credentials.AccountServerURL(serverURL, username)] = username
+			}
+		}
 	}
 
 	return resp, nil
@@ -89,3 +89,41 @@ func TestMissingCredentials(t *testing.T) {
 		t.Fatalf("expected ErrCredentialsNotFound, got %v", err)
 	}
 }
+
+func TestSecretServiceHelperApproximateMatch(t *testing.T) {
+	t.Skip("test requires gnome-keyring but travis CI doesn't have it")
+
+	const serverURL = "https://approximate.example.com:5000"
+	helper := Secretservice{}
+	t.Cleanup(func() {
+		_ = helper.Delete(serverURL)
+	})
+	if err := helper.Add(&credentials.Credentials{ServerURL: serverURL, Username: "foo", Secret: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, readURL := range []string{"approximate.example.com", "https://approximate.example.com", "approximate.example.com:5000"} {
+		if username, secret, err := helper.Get(readURL); err != nil || username != "foo" || secret != "bar" {
+			t.Errorf("%s: unexpected credentials %s:%s, %v", readURL, username, secret, err)
+		}
+	}
+	if _, _, err := helper.Get("http://approximate.example.com:5000"); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected ErrCredentialsNotFound, got %v", err)
+	}
+
+	// Deleting only matches server URLs differing by the scheme.
+	for _, deleteURL := range []string{"approximate.example.com", "https://approximate.example.com"} {
+		if err := helper.Delete(deleteURL); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := helper.Get(serverURL); err != nil {
+			t.Errorf("%s: expected credentials to be kept, got %v", deleteURL, err)
+		}
+	}
+	if err := helper.Delete("approximate.example.com:5000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := helper.Get(serverURL); !credentials.IsErrCredentialsNotFound(err) {
+		t.Errorf("expected credentials to be deleted, got %v", err)
+	}
+}
@@ -4,7 +4,6 @@ package wincred
 
 import (
 	"bytes"
-	"net/url"
 
 	winc "github.com/danieljoos/wincred"
 	"github.com/docker/docker-credential-helpers/credentials"
@@ -63,8 +62,8 @@ func (h Wincred) Get(serverURL string) (string, string, error) {
 }
 
 func getTarget(serverURL string) (string, error) {
-	s, err := registryurl.Parse(serverURL)
-	if err != nil {
+	if _, err := registryurl.Parse(serverURL); err != nil {
+		credentials.Logger().Debug("cannot parse server URL, using it as target", "serverURL", serverURL, "error", err)
 		return serverURL, nil
 	}
 
@@ -82,50 +81,15 @@ func getTarget(serverURL string) (string, error) {
 		}
 	}
 
-	if target, found := findMatch(s, targets, exactMatch); found {
-		return target, nil
-	}
-
-	if target, found := findMatch(s, targets, approximateMatch); found {
+	if target, found := registryurl.FindMatch(serverURL, targets); found {
+		credentials.Logger().Debug("found matching credentials", "serverURL", serverURL, "target", target)
 		return target, nil
 	}
 
+	credentials.Logger().Debug("no matching credentials", "serverURL", serverURL, "candidates", len(targets))
 	return "", nil
 }
 
-func findMatch(serverUrl *url.URL, targets []string, matches func(url.URL, url.URL) bool) (string, bool) {
-	for _, target := range targets {
-		tURL, err := registryurl.Parse(target)
-		if err != nil {
-			continue
-		}
-		if matches(*serverUrl, *tURL) {
-			return target, true
-		}
-	}
-	return "", false
-}
-
-func exactMatch(serverURL, target url.URL) bool {
-	return serverURL.String() == target.String()
-}
-
-func approximateMatch(serverURL, target url.URL) bool {
-	// if scheme is missing assume it is the same as target
-	if serverURL.Scheme == "" {
-		serverURL.Scheme = target.Scheme
-	}
-	// if port is missing assume it is the same as target
-	if serverURL.Port() == "" && target.Port() != "" {
-		serverURL.Host = serverURL.Host + ":" + target.Port()
-	}
-	// if path is missing assume it is the same as target
-	if serverURL.Path == "" {
-		serverURL.Path = target.Path
-	}
-	return serverURL.String() == target.String()
-}
-
 // List returns the stored URLs and corresponding usernames for a given credentials label.
 func (h Wincred) List() (map[string]string, error) {
 	creds, err := winc.List()
@@ -289,3 +289,27 @@ func TestMissingCredentials(t *testing.T) {
 		t.Fatalf("expected ErrCredentialsNotFound, got %v", err)
 	}
 }
+
+func TestWinCredHelperNonURLTarget(t *testing.T) {
+	// Not a registry URL: "ftp" is not a supported scheme.
+	creds := &credentials.Credentials{
+		ServerURL: "ftp://docker-credential-helpers-test",
+		Username:  "foobar",
+		Secret:    "foobarbaz",
+	}
+	helper := Wincred{}
+	if err := helper.Add(creds); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = helper.Delete(creds.ServerURL)
+	})
+
+	username, secret, err := helper.Get(creds.ServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != creds.Username || secret != creds.Secret {
+		t.Errorf("expected %s:%s, got %s:%s", creds.Username, creds.Secret, username, secret)
+	}
+}